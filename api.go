@@ -0,0 +1,254 @@
+package main
+
+import (
+	"time"
+
+	"gonews/controllers"
+	"gonews/openapi"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// apiSpec documents every route registered in NewRouter. NewRouter
+// compares the two with openapi.Check and fails if they disagree.
+var apiSpec = openapi.Build(openapi.Info{Title: "GoNews", Version: "1.0.0"}, []openapi.Route{
+	{
+		Method: "GET", Path: "/", Summary: "Home page",
+		Response: openapi.Fields{"message": ""},
+	},
+	{
+		Method: "GET", Path: "/openapi.json", Summary: "This OpenAPI document",
+		Response: openapi.Fields{},
+	},
+	{
+		Method: "GET", Path: "/healthz", Summary: "Liveness probe",
+		Response: openapi.Fields{"status": ""},
+	},
+	{
+		Method: "GET", Path: "/readyz", Summary: "Readiness probe, fails while the database is unreachable or the server is shutting down",
+		Response: openapi.Fields{"status": ""},
+	},
+	{
+		Method: "GET", Path: "/metrics", Summary: "Prometheus metrics",
+		ResponseType: "text/plain",
+	},
+	{
+		Method: "GET", Path: "/users", Summary: "List all users; only the user themselves and user admins see more than id, username and created_at",
+		Response: openapi.Fields{"status": "", "message": "", "count": 0, "users": []controllers.UserResponse{}},
+	},
+	{
+		Method: "GET", Path: "/users/:username", Summary: "Get the user with the given username; only the user themselves and user admins see more than id, username and created_at",
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}},
+	},
+	{
+		Method: "POST", Path: "/users", Summary: "Create a user",
+		Request:  controllers.UserRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}, "res": primitive.ObjectID{}},
+	},
+	{
+		Method: "POST", Path: "/auth/login", Summary: "Exchange a username and password for a bearer token, or for a challenge when two_factor_required is set; restore takes a deleted user out of the trash",
+		Request:  controllers.LoginRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "token": "", "expires_at": time.Time{}, "two_factor_required": false, "two_factor_setup_required": false, "challenge": ""},
+	},
+	{
+		Method: "POST", Path: "/auth/login/2fa", Summary: "Exchange a login challenge and a TOTP or recovery code for a bearer token",
+		Request:  controllers.TwoFactorLoginRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "token": "", "expires_at": time.Time{}},
+	},
+	{
+		Method: "GET", Path: "/auth/oidc", Summary: "List the OpenID Connect providers users can sign in with",
+		Response: openapi.Fields{"status": "", "message": "", "providers": []string{}},
+	},
+	{
+		Method: "GET", Path: "/auth/oidc/:provider/login", Summary: "Start signing in with a provider, send the user to the returned authorization URL",
+		Response: openapi.Fields{"status": "", "message": "", "authorization_url": ""},
+	},
+	{
+		Method: "GET", Path: "/auth/oidc/:provider/callback", Summary: "Finish signing in with a provider, linking or creating the user by their verified email; responds as /auth/login",
+		Query:    []string{"code", "state", "error"},
+		Response: openapi.Fields{"status": "", "message": "", "token": "", "expires_at": time.Time{}, "two_factor_required": false, "two_factor_setup_required": false, "challenge": ""},
+	},
+	{
+		Method: "POST", Path: "/auth/logout", Summary: "End the session of the bearer token",
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/auth/2fa/enroll", Summary: "Start two-factor enrollment with a new TOTP secret and its otpauth:// URI",
+		Response: openapi.Fields{"status": "", "message": "", "secret": "", "uri": ""},
+	},
+	{
+		Method: "POST", Path: "/auth/2fa/confirm", Summary: "Enable two-factor authentication with a code of the new secret and get recovery codes",
+		Request:  controllers.TwoFactorCodeRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "recovery_codes": []string{}},
+	},
+	{
+		Method: "POST", Path: "/auth/2fa/recovery-codes", Summary: "Replace the recovery codes, given a current TOTP or recovery code",
+		Request:  controllers.TwoFactorCodeRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "recovery_codes": []string{}},
+	},
+	{
+		Method: "POST", Path: "/auth/2fa/disable", Summary: "Disable two-factor authentication, given a current TOTP or recovery code",
+		Request:  controllers.TwoFactorCodeRequest{},
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/auth/verify-email", Summary: "Verify the email address a verification token was sent to",
+		Request:  controllers.TokenRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}},
+	},
+	{
+		Method: "POST", Path: "/auth/verify-email/resend", Summary: "Email the authenticated user a new verification token",
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/auth/password-reset", Summary: "Email a password reset token to every account with the given address",
+		Request:  controllers.PasswordResetRequest{},
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/auth/password-reset/confirm", Summary: "Set a new password with a reset token and end every session of the user",
+		Request:  controllers.PasswordResetConfirmRequest{},
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "PUT", Path: "/users/:username", Summary: "Replace every mutable field of the user with the given username",
+		Request:  controllers.UserRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}, "modified": false},
+	},
+	{
+		Method: "PATCH", Path: "/users/:username", Summary: "Apply a JSON merge patch to the username, email or password of the user",
+		Request:  openapi.Fields{"username": "", "email": "", "password": ""},
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}, "modified": false},
+	},
+	{
+		Method: "DELETE", Path: "/users/:username", Summary: "Move the user with the given username and their posts to the trash; once purged, ?policy=hard deletes their posts and ?policy=anonymize keeps them under the author \"deleted\"",
+		Query:    []string{"policy"},
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}, "restore_until": time.Time{}},
+	},
+	{
+		Method: "POST", Path: "/users/:username/restore", Summary: "Restore the user with the given username and their posts from the trash",
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}},
+	},
+	{
+		Method: "GET", Path: "/users/:username/export", Summary: "Download a zip archive of the user's data",
+		ResponseType: "application/zip",
+	},
+	{
+		Method: "GET", Path: "/users/:username/notifications", Summary: "List the latest notifications of the given user, only unread ones with ?unread=true",
+		Query:    []string{"unread"},
+		Response: openapi.Fields{"status": "", "message": "", "count": 0, "notifications": []controllers.NotificationResponse{}},
+	},
+	{
+		Method: "POST", Path: "/users/:username/notifications/read", Summary: "Mark every notification of the given user as read",
+		Response: openapi.Fields{"status": "", "message": "", "marked_count": 0},
+	},
+	{
+		Method: "GET", Path: "/users/:username/api-keys", Summary: "List the API keys of the given user, without the keys themselves",
+		Response: openapi.Fields{"status": "", "message": "", "count": 0, "api_keys": []controllers.APIKeyResponse{}},
+	},
+	{
+		Method: "POST", Path: "/users/:username/api-keys", Summary: "Create an API key for the given user with scopes and an optional expires_in duration; the key is only shown in this response",
+		Request:  controllers.APIKeyRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "key": "", "api_key": controllers.APIKeyResponse{}},
+	},
+	{
+		Method: "DELETE", Path: "/users/:username/api-keys/:id", Summary: "Revoke an API key of the given user",
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "GET", Path: "/jobs/:id", Summary: "Get the state of a background job (admins)",
+		Response: openapi.Fields{"status": "", "message": "", "job": controllers.JobResponse{}},
+	},
+	{
+		Method: "GET", Path: "/posts", Summary: "List all posts",
+		Response: openapi.Fields{"status": "", "message": "", "count": 0, "posts": []controllers.PostResponse{}},
+	},
+	{
+		Method: "GET", Path: "/tags/:tag", Summary: "List posts with the given hashtag, newest first, a page of limit posts at a time continuing before the given post ID",
+		Query:    []string{"limit", "before"},
+		Response: openapi.Fields{"status": "", "message": "", "post_count": int64(0), "posts": []controllers.PostResponse{}, "next": ""},
+	},
+	{
+		Method: "GET", Path: "/users/:username/posts", Summary: "List all posts by the given user",
+		Response: openapi.Fields{"status": "", "message": "", "posts": []controllers.PostResponse{}},
+	},
+	{
+		Method: "GET", Path: "/posts/:id", Summary: "Get the post with the given ID",
+		Response: openapi.Fields{"status": "", "message": "", "post": controllers.PostResponse{}},
+	},
+	{
+		Method: "POST", Path: "/posts/:id/report", Summary: "Report the post with the given ID to the moderators",
+		Request:  controllers.ReportRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "report": controllers.ReportResponse{}},
+	},
+	{
+		Method: "POST", Path: "/users/:username/posts", Summary: "Create a post by the given user; flagged content is held for review and rejected content returns content_rejected",
+		Request:  controllers.PostRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "post": controllers.PostResponse{}, "res": primitive.ObjectID{}},
+	},
+	{
+		Method: "DELETE", Path: "/users/:username/posts/:id", Summary: "Move the post with the given ID to the trash",
+		Response: openapi.Fields{"status": "", "message": "", "res": int64(0)},
+	},
+	{
+		Method: "POST", Path: "/users/:username/posts/:id/restore", Summary: "Restore the post with the given ID from the trash",
+		Response: openapi.Fields{"status": "", "message": "", "post": controllers.PostResponse{}},
+	},
+	{
+		Method: "PUT", Path: "/admin/users/:username/role", Summary: "Set the role of a user to user, moderator or admin (admins)",
+		Request:  controllers.RoleRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}},
+	},
+	{
+		Method: "DELETE", Path: "/admin/users/:username/2fa", Summary: "Turn two-factor authentication off for a user who lost their authenticator and recovery codes (admins)",
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/admin/users/:username/unlock", Summary: "Clear the failed logins of a user, lifting any delay or lockout (admins)",
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "GET", Path: "/admin/audit", Summary: "List audit entries, newest first, filtered by actor, resource, resource_id, action and an RFC 3339 since/until range (admins)",
+		Query:    []string{"actor", "resource", "resource_id", "action", "since", "until", "limit"},
+		Response: openapi.Fields{"status": "", "message": "", "count": 0, "entries": []controllers.AuditEntryResponse{}},
+	},
+	{
+		Method: "GET", Path: "/admin/reports", Summary: "List reported posts with their reports grouped per post, most reported first (moderators)",
+		Query:    []string{"status", "limit"},
+		Response: openapi.Fields{"status": "", "message": "", "count": 0, "reports": []controllers.ReportGroupResponse{}},
+	},
+	{
+		Method: "POST", Path: "/admin/reports/:id/resolve", Summary: "Dismiss, hide, delete, warn the author of or suspend the author of a reported post and resolve its reports (moderators)",
+		Request:  controllers.ResolveReportsRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "resolved_count": 0},
+	},
+	{
+		Method: "POST", Path: "/admin/users/:username/suspend", Summary: "Suspend a user for a duration such as 72h, or indefinitely (moderators)",
+		Request:  controllers.SuspendRequest{},
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}},
+	},
+	{
+		Method: "POST", Path: "/admin/users/:username/unsuspend", Summary: "Lift the suspension of a user (moderators)",
+		Response: openapi.Fields{"status": "", "message": "", "user": controllers.UserResponse{}},
+	},
+	{
+		Method: "POST", Path: "/admin/posts/:id/hide", Summary: "Hide a post from every listing (moderators)",
+		Request:  controllers.ModerationRequest{},
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/admin/posts/:id/unhide", Summary: "Unhide a hidden post (moderators)",
+		Request:  controllers.ModerationRequest{},
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/admin/tags/:tag/lock", Summary: "Stop new posts from using a tag (moderators)",
+		Request:  controllers.ModerationRequest{},
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+	{
+		Method: "POST", Path: "/admin/tags/:tag/unlock", Summary: "Unlock a locked tag (moderators)",
+		Request:  controllers.ModerationRequest{},
+		Response: openapi.Fields{"status": "", "message": ""},
+	},
+})
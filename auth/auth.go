@@ -0,0 +1,230 @@
+// Package auth carries the authenticated user and the origin of each
+// request through contexts and decides what each role may do
+package auth
+
+import (
+	"context"
+
+	"gonews/models"
+)
+
+// Permission names a privileged ability. Every user may act on their own
+// account and posts; permissions extend that to other users' resources.
+type Permission string
+
+const (
+	// ManageUsers allows updating, deleting, restoring and exporting any user
+	ManageUsers Permission = "users:manage"
+	// SuspendUsers allows suspending and unsuspending users with the role user
+	SuspendUsers Permission = "users:suspend"
+	// ModeratePosts allows deleting, restoring, hiding and unhiding any post
+	ModeratePosts Permission = "posts:moderate"
+	// LockTags allows locking and unlocking tags
+	LockTags Permission = "tags:lock"
+	// AssignRoles allows changing the role of any user
+	AssignRoles Permission = "roles:assign"
+	// ViewJobs allows reading background jobs
+	ViewJobs Permission = "jobs:read"
+	// ViewAudit allows reading the audit log
+	ViewAudit Permission = "audit:read"
+)
+
+// Scope names what an API key may do. Keys only act within their
+// scopes, and then only as far as their user's permissions reach.
+type Scope string
+
+const (
+	// ScopeUsersRead allows reading private user data such as exports and
+	// notifications
+	ScopeUsersRead Scope = "users:read"
+	// ScopeUsersWrite allows updating, deleting and restoring users
+	ScopeUsersWrite Scope = "users:write"
+	// ScopePostsWrite allows creating, deleting, restoring and reporting
+	// posts
+	ScopePostsWrite Scope = "posts:write"
+	// ScopeAdmin allows the admin and moderation routes
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes lists every scope
+var Scopes = []Scope{ScopeUsersRead, ScopeUsersWrite, ScopePostsWrite, ScopeAdmin}
+
+// ValidScope reports whether scope is one of Scopes
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if string(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// rolePermissions lists the permissions of each role
+var rolePermissions = map[string][]Permission{
+	models.RoleUser:      {},
+	models.RoleModerator: {SuspendUsers, ModeratePosts, LockTags},
+	models.RoleAdmin:     {ManageUsers, SuspendUsers, ModeratePosts, LockTags, AssignRoles, ViewJobs, ViewAudit},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether role has permission
+func Can(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type userKey struct{}
+
+type systemKey struct{}
+
+type requestKey struct{}
+
+type apiKeyKey struct{}
+
+type sessionKey struct{}
+
+// Request describes where a request came from
+type Request struct {
+	IP        string
+	Method    string
+	Route     string
+	RequestID string
+}
+
+// WithRequest returns a copy of ctx carrying the origin of the request
+func WithRequest(ctx context.Context, request Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, request)
+}
+
+// RequestFromContext returns the origin of the request, empty for
+// background work
+func RequestFromContext(ctx context.Context) Request {
+	request, _ := ctx.Value(requestKey{}).(Request)
+	return request
+}
+
+// WithSystem returns a copy of ctx for background work done by the
+// server itself rather than on behalf of a user
+func WithSystem(ctx context.Context) context.Context {
+	return context.WithValue(ctx, systemKey{}, true)
+}
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// WithAPIKey returns a copy of ctx noting that the request authenticated
+// with key rather than a session
+func WithAPIKey(ctx context.Context, key *models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyKey{}, key)
+}
+
+// WithSession returns a copy of ctx noting the session the request
+// authenticated with
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+// SessionFromContext returns the session the request authenticated
+// with, or nil for API keys and anonymous requests
+func SessionFromContext(ctx context.Context) *models.Session {
+	session, _ := ctx.Value(sessionKey{}).(*models.Session)
+	return session
+}
+
+// APIKeyFromContext returns the API key the request authenticated with,
+// or nil for sessions and anonymous requests
+func APIKeyFromContext(ctx context.Context) *models.APIKey {
+	key, _ := ctx.Value(apiKeyKey{}).(*models.APIKey)
+	return key
+}
+
+// UserFromContext returns the authenticated user, or nil for anonymous
+// requests
+func UserFromContext(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userKey{}).(*models.User)
+	return user
+}
+
+// Actor returns the username of the authenticated user, "system" for
+// background work and "anonymous" otherwise
+func Actor(ctx context.Context) string {
+	if user := UserFromContext(ctx); user != nil {
+		return user.Username
+	} else if system, _ := ctx.Value(systemKey{}).(bool); system {
+		return "system"
+	}
+	return "anonymous"
+}
+
+// Require returns an error unless the authenticated user has permission
+func Require(ctx context.Context, permission Permission) error {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return models.ErrAuthRequired
+	} else if !Can(user.EffectiveRole(), permission) {
+		return models.ErrPermissionDenied
+	}
+	return nil
+}
+
+// RequireSelf returns an error unless the authenticated user is the user
+// with the given username
+func RequireSelf(ctx context.Context, username string) error {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return models.ErrAuthRequired
+	} else if user.Username != username {
+		return models.ErrPermissionDenied
+	}
+	return nil
+}
+
+// RequireSelfOr returns an error unless the authenticated user is the
+// user with the given username or has permission
+func RequireSelfOr(ctx context.Context, username string, permission Permission) error {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return models.ErrAuthRequired
+	} else if user.Username != username && !Can(user.EffectiveRole(), permission) {
+		return models.ErrPermissionDenied
+	}
+	return nil
+}
+
+// CanSeeAccount reports whether the authenticated user may see the
+// email, role and other account details of the user with the given
+// username: their own, or anyone's with ManageUsers
+func CanSeeAccount(ctx context.Context, username string) bool {
+	return RequireSelfOr(ctx, username, ManageUsers) == nil
+}
+
+// RequireScope returns an error if the request authenticated with an API
+// key lacking scope. Sessions have every scope.
+func RequireScope(ctx context.Context, scope Scope) error {
+	if key := APIKeyFromContext(ctx); key != nil && !key.HasScope(string(scope)) {
+		return models.ErrInsufficientScope
+	}
+	return nil
+}
+
+// RequireSession returns an error unless the request authenticated with
+// a session, for routes that manage credentials
+func RequireSession(ctx context.Context) error {
+	if UserFromContext(ctx) == nil {
+		return models.ErrAuthRequired
+	} else if APIKeyFromContext(ctx) != nil {
+		return models.ErrSessionRequired
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config holds every setting of the GoNews server. Each field is read
+// from, in increasing order of precedence: its default, the config file
+// (key), the environment (env) and the command line (-key with dots
+// replaced by dashes).
+type Config struct {
+	HTTP      HTTPConfig      `key:"http"`
+	GRPC      GRPCConfig      `key:"grpc"`
+	Mongo     MongoConfig     `key:"mongo"`
+	Database  DatabaseConfig  `key:"database"`
+	Log       LogConfig       `key:"log"`
+	Tracing   TracingConfig   `key:"tracing"`
+	RateLimit RateLimitConfig `key:"rate_limit"`
+	Accounts  AccountsConfig  `key:"accounts"`
+	Jobs      JobsConfig      `key:"jobs"`
+	Trash     TrashConfig     `key:"trash"`
+	Auth      AuthConfig      `key:"auth"`
+	Lockout   LockoutConfig   `key:"lockout"`
+	Reports   ReportsConfig   `key:"reports"`
+	Filters   FiltersConfig   `key:"filters"`
+	Mail      MailConfig      `key:"mail"`
+	OIDC      OIDCConfig      `key:"oidc"`
+}
+
+type HTTPConfig struct {
+	Addr             string        `key:"addr" env:"HTTP_ADDR" usage:"HTTP listen address"`
+	ShutdownTimeout  time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long to drain in-flight requests on shutdown"`
+	ReadinessTimeout time.Duration `key:"readiness_timeout" env:"READINESS_TIMEOUT" usage:"timeout for the database ping in /readyz"`
+	TrustedProxies   []string      `key:"trusted_proxies" env:"TRUSTED_PROXIES" usage:"comma-separated proxy CIDRs allowed to set X-Forwarded-For"`
+	MaxBodyBytes     int64         `key:"max_body_bytes" env:"HTTP_MAX_BODY_BYTES" usage:"largest request body accepted, in bytes"`
+}
+
+type GRPCConfig struct {
+	Addr string `key:"addr" env:"GRPC_ADDR" usage:"gRPC listen address"`
+}
+
+type MongoConfig struct {
+	URL            string        `key:"url" env:"MONGODB_URL" usage:"MongoDB connection string"`
+	Username       string        `key:"username" env:"MONGODB_USERNAME" usage:"MongoDB user"`
+	Password       string        `key:"password" env:"MONGODB_PASSWORD" usage:"MongoDB password"`
+	AuthMechanism  string        `key:"auth_mechanism" env:"MONGODB_AUTH_MECHANISM" usage:"MongoDB authentication mechanism"`
+	Database       string        `key:"database" env:"MONGODB_DATABASE" usage:"MongoDB database name"`
+	ConnectTimeout time.Duration `key:"connect_timeout" env:"MONGODB_CONNECT_TIMEOUT" usage:"timeout for connecting to MongoDB"`
+}
+
+// DatabaseConfig bounds each kind of database operation
+type DatabaseConfig struct {
+	QueryTimeout  time.Duration `key:"query_timeout" env:"DB_QUERY_TIMEOUT" usage:"timeout for database queries"`
+	InsertTimeout time.Duration `key:"insert_timeout" env:"DB_INSERT_TIMEOUT" usage:"timeout for database inserts"`
+	UpdateTimeout time.Duration `key:"update_timeout" env:"DB_UPDATE_TIMEOUT" usage:"timeout for database updates"`
+	DeleteTimeout time.Duration `key:"delete_timeout" env:"DB_DELETE_TIMEOUT" usage:"timeout for database deletes"`
+}
+
+type LogConfig struct {
+	Format string `key:"format" env:"LOG_FORMAT" usage:"log format, json or text"`
+	Level  string `key:"level" env:"LOG_LEVEL" usage:"log level, debug, info, warn or error"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are exported
+type TracingConfig struct {
+	Exporter    string  `key:"exporter" env:"OTEL_TRACES_EXPORTER" usage:"trace exporter, none, stdout or otlp"`
+	Endpoint    string  `key:"endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"OTLP gRPC collector host:port"`
+	Insecure    bool    `key:"insecure" env:"OTEL_EXPORTER_OTLP_INSECURE" usage:"disable TLS for the OTLP exporter"`
+	SampleRatio float64 `key:"sample_ratio" env:"OTEL_TRACES_SAMPLE_RATIO" usage:"fraction of new traces to sample"`
+	ServiceName string  `key:"service_name" env:"OTEL_SERVICE_NAME" usage:"service name reported with every span"`
+}
+
+// RateLimitConfig sets the token bucket of each route group. A rate of 0
+// disables limiting for the group.
+type RateLimitConfig struct {
+	Backend     string  `key:"backend" env:"RATE_LIMIT_BACKEND" usage:"rate limit store, memory or mongo"`
+	ReadRate    float64 `key:"read_rate" env:"RATE_LIMIT_READ_RATE" usage:"read requests per second"`
+	ReadBurst   int     `key:"read_burst" env:"RATE_LIMIT_READ_BURST" usage:"read request burst"`
+	SignupRate  float64 `key:"signup_rate" env:"RATE_LIMIT_SIGNUP_RATE" usage:"user creations per second"`
+	SignupBurst int     `key:"signup_burst" env:"RATE_LIMIT_SIGNUP_BURST" usage:"user creation burst"`
+	PostRate    float64 `key:"post_rate" env:"RATE_LIMIT_POST_RATE" usage:"post creations per second"`
+	PostBurst   int     `key:"post_burst" env:"RATE_LIMIT_POST_BURST" usage:"post creation burst"`
+	WriteRate   float64 `key:"write_rate" env:"RATE_LIMIT_WRITE_RATE" usage:"other updates and deletes per second"`
+	WriteBurst  int     `key:"write_burst" env:"RATE_LIMIT_WRITE_BURST" usage:"other update and delete burst"`
+	IPRate      float64 `key:"ip_rate" env:"RATE_LIMIT_IP_RATE" usage:"requests per second per client IP, checked before authentication"`
+	IPBurst     int     `key:"ip_burst" env:"RATE_LIMIT_IP_BURST" usage:"request burst per client IP"`
+}
+
+// AccountsConfig controls account lifecycle
+type AccountsConfig struct {
+	DeletionPolicy string `key:"deletion_policy" env:"ACCOUNT_DELETION_POLICY" usage:"what happens to a deleted user's posts by default, hard or anonymize"`
+}
+
+// JobsConfig tunes the background job runner
+type JobsConfig struct {
+	PollInterval time.Duration `key:"poll_interval" env:"JOBS_POLL_INTERVAL" usage:"how often to look for pending jobs"`
+	Lease        time.Duration `key:"lease" env:"JOBS_LEASE" usage:"how long a job stays claimed without a heartbeat before another worker resumes it"`
+	MaxAttempts  int           `key:"max_attempts" env:"JOBS_MAX_ATTEMPTS" usage:"attempts before a job is marked failed"`
+}
+
+// TrashConfig controls how long deleted users and posts can be restored
+type TrashConfig struct {
+	Retention     time.Duration `key:"retention" env:"TRASH_RETENTION" usage:"how long deleted users and posts can be restored before they are purged"`
+	PurgeInterval time.Duration `key:"purge_interval" env:"TRASH_PURGE_INTERVAL" usage:"how often to purge expired users and posts"`
+}
+
+// ReportsConfig controls reports of abusive posts
+type ReportsConfig struct {
+	HideThreshold int `key:"hide_threshold" env:"REPORTS_HIDE_THRESHOLD" usage:"open reports from distinct users after which a post is hidden until reviewed, 0 to never hide"`
+}
+
+// FiltersConfig controls the checks run on the content of new posts
+type FiltersConfig struct {
+	MaxLength         int           `key:"max_length" env:"FILTERS_MAX_LENGTH" usage:"longest post content in characters, 0 for no limit"`
+	BannedWords       []string      `key:"banned_words" env:"FILTERS_BANNED_WORDS" usage:"comma-separated words not allowed in posts, also matched when written in leetspeak"`
+	BannedWordsAction string        `key:"banned_words_action" env:"FILTERS_BANNED_WORDS_ACTION" usage:"what to do with posts containing banned words: rewrite, flag or reject"`
+	MaxLinks          int           `key:"max_links" env:"FILTERS_MAX_LINKS" usage:"most links a post may contain, negative for no limit"`
+	LinksAction       string        `key:"links_action" env:"FILTERS_LINKS_ACTION" usage:"what to do with posts over max_links: flag or reject"`
+	DuplicateWindow   time.Duration `key:"duplicate_window" env:"FILTERS_DUPLICATE_WINDOW" usage:"how long an author cannot repeat a post, 0 to allow duplicates"`
+	SpamThreshold     float64       `key:"spam_threshold" env:"FILTERS_SPAM_THRESHOLD" usage:"spam probability from which posts are flagged, 0 to disable the classifier"`
+	SpamMinTraining   int           `key:"spam_min_training" env:"FILTERS_SPAM_MIN_TRAINING" usage:"moderator decisions of each kind the classifier needs before it is used"`
+}
+
+// MailConfig controls outgoing email
+type MailConfig struct {
+	Backend      string `key:"backend" env:"MAIL_BACKEND" usage:"how to send email: smtp, file or log"`
+	From         string `key:"from" env:"MAIL_FROM" usage:"sender address of outgoing email"`
+	SMTPAddr     string `key:"smtp_addr" env:"SMTP_ADDR" usage:"host:port of the SMTP server"`
+	SMTPUsername string `key:"smtp_username" env:"SMTP_USERNAME" usage:"SMTP user, empty to send without authentication"`
+	SMTPPassword string `key:"smtp_password" env:"SMTP_PASSWORD" usage:"SMTP password"`
+	Dir          string `key:"dir" env:"MAIL_DIR" usage:"directory the file backend writes messages to"`
+}
+
+// AuthConfig controls logins
+type AuthConfig struct {
+	SessionTTL      time.Duration `key:"session_ttl" env:"AUTH_SESSION_TTL" usage:"how long a login token stays valid"`
+	VerificationTTL time.Duration `key:"verification_ttl" env:"AUTH_VERIFICATION_TTL" usage:"how long an email verification token stays valid"`
+	ResetTTL        time.Duration `key:"reset_ttl" env:"AUTH_RESET_TTL" usage:"how long a password reset token stays valid"`
+	Admins          []string      `key:"admins" env:"AUTH_ADMINS" usage:"comma-separated usernames promoted to admin when they log in"`
+	TwoFactorRoles  []string      `key:"two_factor_roles" env:"AUTH_TWO_FACTOR_ROLES" usage:"comma-separated roles that must enable two-factor authentication, such as moderator,admin"`
+}
+
+// LockoutConfig throttles failed logins per account and per client IP
+type LockoutConfig struct {
+	DelayAfter  int           `key:"delay_after" env:"LOCKOUT_DELAY_AFTER" usage:"failed logins of an account before further attempts are delayed"`
+	Delay       time.Duration `key:"delay" env:"LOCKOUT_DELAY" usage:"first delay between attempts, doubled with each further failure"`
+	Threshold   int           `key:"threshold" env:"LOCKOUT_THRESHOLD" usage:"failed logins that lock an account and email its owner, 0 to never lock"`
+	IPThreshold int           `key:"ip_threshold" env:"LOCKOUT_IP_THRESHOLD" usage:"failed logins from one IP across all accounts that lock out the IP, 0 to never lock"`
+	Duration    time.Duration `key:"duration" env:"LOCKOUT_DURATION" usage:"how long locked accounts and IPs stay locked"`
+	Window      time.Duration `key:"window" env:"LOCKOUT_WINDOW" usage:"how long a failed login counts against an account or IP"`
+}
+
+// OIDCConfig controls single sign-on with OpenID Connect providers
+type OIDCConfig struct {
+	PublicURL string        `key:"public_url" env:"OIDC_PUBLIC_URL" usage:"external URL of this server, the base of each provider's redirect URI"`
+	StateTTL  time.Duration `key:"state_ttl" env:"OIDC_STATE_TTL" usage:"how long a user has to sign in at the provider"`
+	// Providers are keyed by the name used in their routes
+	Providers map[string]OIDCProvider `key:"providers" env:"OIDC_PROVIDERS" usage:"comma-separated names of providers configured in the environment"`
+}
+
+// OIDCProvider is an OpenID Connect provider users may sign in with
+type OIDCProvider struct {
+	Issuer       string   `key:"issuer" env:"ISSUER" usage:"issuer URL serving /.well-known/openid-configuration"`
+	ClientID     string   `key:"client_id" env:"CLIENT_ID" usage:"client ID registered with the provider"`
+	ClientSecret string   `key:"client_secret" env:"CLIENT_SECRET" usage:"client secret, empty for public clients"`
+	Scopes       []string `key:"scopes" env:"SCOPES" usage:"scopes to request besides openid, email and profile"`
+}
+
+// Default returns the configuration used when nothing else is set
+func Default() Config {
+	return Config{
+		HTTP: HTTPConfig{
+			Addr:             ":8000",
+			ShutdownTimeout:  30 * time.Second,
+			ReadinessTimeout: 2 * time.Second,
+			MaxBodyBytes:     1 << 20,
+		},
+		GRPC: GRPCConfig{Addr: ":9000"},
+		Mongo: MongoConfig{
+			AuthMechanism:  "SCRAM-SHA-1",
+			ConnectTimeout: 10 * time.Second,
+		},
+		Database: DatabaseConfig{
+			QueryTimeout:  10 * time.Second,
+			InsertTimeout: 10 * time.Second,
+			UpdateTimeout: 10 * time.Second,
+			DeleteTimeout: 10 * time.Second,
+		},
+		Log: LogConfig{Format: "json", Level: "info"},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+			ServiceName: "gonews",
+		},
+		RateLimit: RateLimitConfig{
+			Backend:     "memory",
+			ReadRate:    20,
+			ReadBurst:   40,
+			SignupRate:  1.0 / 60,
+			SignupBurst: 5,
+			PostRate:    0.2,
+			PostBurst:   10,
+			WriteRate:   1,
+			WriteBurst:  10,
+			IPRate:      50,
+			IPBurst:     100,
+		},
+		Accounts: AccountsConfig{DeletionPolicy: "anonymize"},
+		Jobs: JobsConfig{
+			PollInterval: 5 * time.Second,
+			Lease:        time.Minute,
+			MaxAttempts:  5,
+		},
+		Trash: TrashConfig{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Auth: AuthConfig{
+			SessionTTL:      7 * 24 * time.Hour,
+			VerificationTTL: 48 * time.Hour,
+			ResetTTL:        time.Hour,
+		},
+		Lockout: LockoutConfig{
+			DelayAfter:  3,
+			Delay:       time.Second,
+			Threshold:   10,
+			IPThreshold: 100,
+			Duration:    15 * time.Minute,
+			Window:      time.Hour,
+		},
+		Reports: ReportsConfig{HideThreshold: 5},
+		OIDC: OIDCConfig{
+			PublicURL: "http://localhost:8000",
+			StateTTL:  10 * time.Minute,
+		},
+		Filters: FiltersConfig{
+			MaxLength:         5000,
+			BannedWordsAction: "rewrite",
+			MaxLinks:          5,
+			LinksAction:       "flag",
+			DuplicateWindow:   24 * time.Hour,
+			SpamThreshold:     0.95,
+			SpamMinTraining:   20,
+		},
+		Mail: MailConfig{
+			Backend:  "log",
+			From:     "gonews@localhost",
+			SMTPAddr: "localhost:587",
+			Dir:      "mail",
+		},
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.HTTP.Addr != "", "http.addr is required")
+	check(c.GRPC.Addr != "", "grpc.addr is required")
+	check(c.HTTP.Addr != c.GRPC.Addr, "http.addr and grpc.addr must differ")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout must be positive")
+	check(c.HTTP.ReadinessTimeout > 0, "http.readiness_timeout must be positive")
+	check(c.HTTP.MaxBodyBytes > 0, "http.max_body_bytes must be positive")
+	check(c.Mongo.URL != "", "mongo.url (MONGODB_URL) is required")
+	check(c.Mongo.Database != "", "mongo.database (MONGODB_DATABASE) is required")
+	check(c.Mongo.ConnectTimeout > 0, "mongo.connect_timeout must be positive")
+	check(c.Database.QueryTimeout > 0, "database.query_timeout must be positive")
+	check(c.Database.InsertTimeout > 0, "database.insert_timeout must be positive")
+	check(c.Database.UpdateTimeout > 0, "database.update_timeout must be positive")
+	check(c.Database.DeleteTimeout > 0, "database.delete_timeout must be positive")
+	check(c.Log.Format == "json" || c.Log.Format == "text", "log.format must be json or text")
+	check(isLogLevel(c.Log.Level), "log.level must be debug, info, warn or error")
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "stdout" || c.Tracing.Exporter == "otlp",
+		"tracing.exporter must be none, stdout or otlp")
+	check(c.RateLimit.Backend == "memory" || c.RateLimit.Backend == "mongo", "rate_limit.backend must be memory or mongo")
+	check(c.RateLimit.ReadRate >= 0 && c.RateLimit.SignupRate >= 0 && c.RateLimit.PostRate >= 0 && c.RateLimit.WriteRate >= 0 && c.RateLimit.IPRate >= 0,
+		"rate_limit rates must not be negative")
+	check(c.Accounts.DeletionPolicy == "hard" || c.Accounts.DeletionPolicy == "anonymize",
+		"accounts.deletion_policy must be hard or anonymize")
+	check(c.Jobs.PollInterval > 0, "jobs.poll_interval must be positive")
+	check(c.Jobs.Lease > 0, "jobs.lease must be positive")
+	check(c.Jobs.MaxAttempts > 0, "jobs.max_attempts must be positive")
+	check(c.Trash.Retention >= 0, "trash.retention must not be negative")
+	check(c.Trash.PurgeInterval > 0, "trash.purge_interval must be positive")
+	check(c.Auth.SessionTTL > 0, "auth.session_ttl must be positive")
+	check(c.Auth.VerificationTTL > 0, "auth.verification_ttl must be positive")
+	check(c.Auth.ResetTTL > 0, "auth.reset_ttl must be positive")
+	for _, role := range c.Auth.TwoFactorRoles {
+		check(role == "user" || role == "moderator" || role == "admin", "auth.two_factor_roles must list user, moderator or admin")
+	}
+	check(c.Lockout.DelayAfter >= 0, "lockout.delay_after must not be negative")
+	check(c.Lockout.Delay >= 0, "lockout.delay must not be negative")
+	check(c.Lockout.Threshold >= 0 && c.Lockout.IPThreshold >= 0, "lockout thresholds must not be negative")
+	check(c.Lockout.Duration > 0, "lockout.duration must be positive")
+	check(c.Lockout.Window > 0, "lockout.window must be positive")
+	check(c.Mail.Backend == "smtp" || c.Mail.Backend == "file" || c.Mail.Backend == "log", "mail.backend must be smtp, file or log")
+	check(c.Mail.From != "", "mail.from is required")
+	check(c.Mail.Backend != "smtp" || c.Mail.SMTPAddr != "", "mail.smtp_addr is required for the smtp backend")
+	check(c.Mail.Backend != "file" || c.Mail.Dir != "", "mail.dir is required for the file backend")
+	check(c.OIDC.StateTTL > 0, "oidc.state_ttl must be positive")
+	check(len(c.OIDC.Providers) == 0 || c.OIDC.PublicURL != "", "oidc.public_url is required with providers")
+	for name, provider := range c.OIDC.Providers {
+		check(isProviderName(name), fmt.Sprintf("oidc provider %q must be named with lowercase letters, digits and _", name))
+		check(provider.Issuer != "", fmt.Sprintf("oidc.providers.%s.issuer is required", name))
+		check(provider.ClientID != "", fmt.Sprintf("oidc.providers.%s.client_id is required", name))
+	}
+	check(c.Reports.HideThreshold >= 0, "reports.hide_threshold must not be negative")
+	check(c.Filters.MaxLength >= 0 && c.Filters.MaxLength <= 10000, "filters.max_length must be between 0 and 10000, the longest post accepted")
+	check(c.Filters.BannedWordsAction == "rewrite" || c.Filters.BannedWordsAction == "flag" || c.Filters.BannedWordsAction == "reject",
+		"filters.banned_words_action must be rewrite, flag or reject")
+	check(c.Filters.LinksAction == "flag" || c.Filters.LinksAction == "reject", "filters.links_action must be flag or reject")
+	check(c.Filters.DuplicateWindow >= 0, "filters.duplicate_window must not be negative")
+	check(c.Filters.SpamThreshold >= 0 && c.Filters.SpamThreshold <= 1, "filters.spam_threshold must be between 0 and 1")
+	check(c.Filters.SpamMinTraining >= 0, "filters.spam_min_training must not be negative")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func isLogLevel(level string) bool {
+	switch strings.ToLower(level) {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+func isProviderName(name string) bool {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return name != ""
+}
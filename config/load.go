@@ -0,0 +1,369 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// field is a single leaf setting of Config
+type field struct {
+	key   string // dotted file key, e.g. mongo.url
+	env   string
+	usage string
+	value reflect.Value
+}
+
+// Load builds the configuration from defaults, the optional .env file,
+// the optional YAML or TOML file named by -config or GONEWS_CONFIG, the
+// environment and the command line arguments, then validates it
+func Load(args []string) (Config, error) {
+	cfg := Default()
+	fields := collectFields(reflect.ValueOf(&cfg).Elem(), "")
+
+	flags := flag.NewFlagSet("gonews", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML or TOML config file")
+	envFile := flags.String("env-file", ".env", "path to an optional .env file")
+	flagValues := map[string]*string{}
+	for _, f := range fields {
+		flagValues[f.key] = flags.String(flagName(f.key), "", f.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// .env only fills variables that are not already set in the environment
+	if err := godotenv.Load(*envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, fmt.Errorf("loading %s: %w", *envFile, err)
+	}
+
+	if *configFile == "" {
+		*configFile = os.Getenv("GONEWS_CONFIG")
+	}
+	fileValues := map[string]interface{}{}
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return cfg, err
+		}
+		fileValues = values
+		if err := checkKeys(values, fields, collectMaps(reflect.ValueOf(&cfg).Elem(), "")); err != nil {
+			return cfg, fmt.Errorf("%s: %w", *configFile, err)
+		}
+		for _, f := range fields {
+			if value, ok := values[f.key]; ok {
+				if err := set(f.value, fmt.Sprint(value)); err != nil {
+					return cfg, fmt.Errorf("%s: %s: %w", *configFile, f.key, err)
+				}
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := os.LookupEnv(f.env); ok && f.env != "" {
+			if err := set(f.value, value); err != nil {
+				return cfg, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, m := range collectMaps(reflect.ValueOf(&cfg).Elem(), "") {
+		if err := loadMap(m, fileValues); err != nil {
+			return cfg, err
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if flagName(f.key) == fl.Name && flagErr == nil {
+				if err := set(f.value, *flagValues[f.key]); err != nil {
+					flagErr = fmt.Errorf("-%s: %w", fl.Name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return cfg, flagErr
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func collectFields(v reflect.Value, prefix string) []field {
+	var fields []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("key")
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectFields(v.Field(i), key)...)
+			continue
+		} else if sf.Type.Kind() == reflect.Map {
+			continue // see collectMaps
+		}
+		fields = append(fields, field{
+			key:   key,
+			env:   sf.Tag.Get("env"),
+			usage: sf.Tag.Get("usage"),
+			value: v.Field(i),
+		})
+	}
+	return fields
+}
+
+// mapField is a setting holding named groups of settings, such as
+// oidc.providers.<name>.issuer. Its env variable lists names to read
+// from the environment, where each setting of the group is read from
+// <env>_<NAME>_<setting env>. Groups cannot be set on the command line.
+type mapField struct {
+	key   string
+	env   string
+	value reflect.Value
+}
+
+func collectMaps(v reflect.Value, prefix string) []mapField {
+	var maps []mapField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("key")
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch sf.Type.Kind() {
+		case reflect.Struct:
+			maps = append(maps, collectMaps(v.Field(i), key)...)
+		case reflect.Map:
+			maps = append(maps, mapField{key: key, env: sf.Tag.Get("env"), value: v.Field(i)})
+		}
+	}
+	return maps
+}
+
+// loadMap reads the groups of m named in the config file or in the
+// environment
+func loadMap(m mapField, fileValues map[string]interface{}) error {
+	names := map[string]bool{}
+	for key := range fileValues {
+		if rest, ok := strings.CutPrefix(key, m.key+"."); ok {
+			name, _, _ := strings.Cut(rest, ".")
+			names[name] = true
+		}
+	}
+	if value, ok := os.LookupEnv(m.env); ok && m.env != "" {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names[name] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	if m.value.IsNil() {
+		m.value.Set(reflect.MakeMap(m.value.Type()))
+	}
+	for name := range names {
+		group := reflect.New(m.value.Type().Elem()).Elem()
+		if existing := m.value.MapIndex(reflect.ValueOf(name)); existing.IsValid() {
+			group.Set(existing)
+		}
+		for _, f := range collectFields(group, m.key+"."+name) {
+			if value, ok := fileValues[f.key]; ok {
+				if err := set(f.value, fmt.Sprint(value)); err != nil {
+					return fmt.Errorf("%s: %w", f.key, err)
+				}
+			}
+			env := strings.ToUpper(strings.ReplaceAll(m.env+"_"+name+"_"+f.env, "-", "_"))
+			if value, ok := os.LookupEnv(env); ok && f.env != "" {
+				if err := set(f.value, value); err != nil {
+					return fmt.Errorf("%s: %w", env, err)
+				}
+			}
+		}
+		m.value.SetMapIndex(reflect.ValueOf(name), group)
+	}
+	return nil
+}
+
+// checkKeys returns an error naming every key of the config file that is
+// no setting, so misspelled keys do not silently fall back to defaults
+func checkKeys(values map[string]interface{}, fields []field, maps []mapField) error {
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[f.key] = true
+	}
+
+	var unknown []string
+	for key, value := range values {
+		if known[key] || value == nil {
+			// Empty sections parse as nil
+			continue
+		}
+		if !isMapKey(key, maps) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// isMapKey reports whether key is a setting of a named group of one of
+// maps, such as oidc.providers.<name>.issuer
+func isMapKey(key string, maps []mapField) bool {
+	for _, m := range maps {
+		rest, ok := strings.CutPrefix(key, m.key+".")
+		if !ok {
+			continue
+		}
+		name, setting, ok := strings.Cut(rest, ".")
+		if !ok {
+			return false
+		}
+		group := reflect.New(m.value.Type().Elem()).Elem()
+		for _, f := range collectFields(group, "") {
+			if f.key == setting && name != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func flagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// readFile parses a YAML or TOML file into dotted keys
+func readFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		raw = normalize(doc)
+	case ".toml":
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+
+	values := map[string]interface{}{}
+	flatten(raw, "", values)
+	return values, nil
+}
+
+// normalize converts the map[interface{}]interface{} produced by yaml.v2
+func normalize(m map[interface{}]interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range m {
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			out[fmt.Sprint(k)] = normalize(nested)
+		} else {
+			out[fmt.Sprint(k)] = v
+		}
+	}
+	return out
+}
+
+func flatten(m map[string]interface{}, prefix string, out map[string]interface{}) {
+	for k, v := range m {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			flatten(v, key, out)
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		default:
+			out[key] = v
+		}
+	}
+}
+
+// set parses s into the setting v
+func set(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
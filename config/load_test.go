@@ -0,0 +1,61 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, "gonews.yaml", `
+mongo:
+  url: mongodb://localhost:27017
+  database: gonews
+auth:
+  session_ttl: 1h
+oidc:
+  providers:
+    corp:
+      issuer: https://login.example.com
+      client_id: gonews
+`)
+	cfg, err := Load([]string{"-config", path, "-env-file", filepath.Join(t.TempDir(), "none")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.SessionTTL != time.Hour {
+		t.Errorf("auth.session_ttl = %v, want 1h", cfg.Auth.SessionTTL)
+	}
+	if got := cfg.OIDC.Providers["corp"].Issuer; got != "https://login.example.com" {
+		t.Errorf("oidc.providers.corp.issuer = %q", got)
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	tests := []struct {
+		name, content, unknown string
+	}{
+		{"gonews.yaml", "auth:\n  sesion_ttl: 1h\n", "auth.sesion_ttl"},
+		{"gonews.toml", "[auth]\nsesion_ttl = \"1h\"\n", "auth.sesion_ttl"},
+		{"gonews.yaml", "oidc:\n  providers:\n    corp:\n      isuer: https://login.example.com\n", "oidc.providers.corp.isuer"},
+		{"gonews.yaml", "htttp:\n  addr: \":80\"\n", "htttp.addr"},
+	}
+	for _, tt := range tests {
+		path := writeConfig(t, tt.name, tt.content)
+		_, err := Load([]string{"-config", path, "-env-file", filepath.Join(t.TempDir(), "none")})
+		if err == nil || !strings.Contains(err.Error(), tt.unknown) {
+			t.Errorf("%s: error %v, want one naming %s", tt.content, err, tt.unknown)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"gonews/models"
+	"gonews/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RoleRequest is the body of PUT /admin/users/:username/role
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// ModerationRequest is the body of moderator actions
+type ModerationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// SuspendRequest is the body of POST /admin/users/:username/suspend.
+// Duration is a Go duration such as "72h", empty to suspend indefinitely.
+type SuspendRequest struct {
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"`
+}
+
+// SetRole changes the role of a user
+func SetRole(c *gin.Context, db *mongo.Database, username string) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	user, err := services.SetRole(c.Request.Context(), db, username, req.Role)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully changed role",
+			"user":    newUserResponse(user),
+		})
+}
+
+// ResetTwoFactor turns two-factor authentication off for a user who lost
+// their authenticator and recovery codes
+func ResetTwoFactor(c *gin.Context, db *mongo.Database, username string) {
+	if err := services.ResetTwoFactor(c.Request.Context(), db, username); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully reset two-factor authentication",
+		})
+}
+
+// UnlockUser clears the failed logins of a user, lifting any lockout
+func UnlockUser(c *gin.Context, db *mongo.Database, username string) {
+	if err := services.UnlockUser(c.Request.Context(), db, username); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully unlocked user",
+		})
+}
+
+// SetPostHidden hides or unhides a post
+func SetPostHidden(c *gin.Context, db *mongo.Database, id string, hidden bool) {
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	if err := services.SetPostHidden(c.Request.Context(), db, id, hidden, req.Reason); err != nil {
+		c.Error(err)
+		return
+	}
+
+	message := "successfully hid post"
+	if !hidden {
+		message = "successfully unhid post"
+	}
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": message,
+		})
+}
+
+// SetTagLocked locks or unlocks a tag
+func SetTagLocked(c *gin.Context, db *mongo.Database, tag string, locked bool) {
+	var req ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	if err := services.SetTagLocked(c.Request.Context(), db, tag, locked, req.Reason); err != nil {
+		c.Error(err)
+		return
+	}
+
+	message := "successfully locked tag"
+	if !locked {
+		message = "successfully unlocked tag"
+	}
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": message,
+		})
+}
+
+// SuspendUser suspends a user for a duration or indefinitely
+func SuspendUser(c *gin.Context, db *mongo.Database, username string) {
+	var req SuspendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil {
+			c.Error(models.NewValidationError("invalid_duration", "Duration must be like 72h",
+				[]models.FieldError{{Field: "duration", Message: err.Error()}}))
+			return
+		}
+	}
+
+	user, err := services.SuspendUser(c.Request.Context(), db, username, duration, req.Reason)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully suspended user",
+			"user":    newUserResponse(user),
+		})
+}
+
+// UnsuspendUser lifts the suspension of a user
+func UnsuspendUser(c *gin.Context, db *mongo.Database, username string) {
+	user, err := services.UnsuspendUser(c.Request.Context(), db, username)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully unsuspended user",
+			"user":    newUserResponse(user),
+		})
+}
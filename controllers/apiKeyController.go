@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"gonews/models"
+	"gonews/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIKeyRequest is the body of POST /users/:username/api-keys
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is a duration such as 720h, empty for keys that never
+	// expire
+	ExpiresIn string `json:"expires_in"`
+}
+
+// APIKeyResponse is an API key as returned by the API, without its hash
+type APIKeyResponse struct {
+	ID         primitive.ObjectID `json:"id"`
+	Name       string             `json:"name"`
+	Prefix     string             `json:"prefix"`
+	Scopes     []string           `json:"scopes"`
+	CreatedAt  time.Time          `json:"created_at"`
+	ExpiresAt  *time.Time         `json:"expires_at"`
+	LastUsedAt *time.Time         `json:"last_used_at"`
+}
+
+func newAPIKeyResponse(key *models.APIKey) *APIKeyResponse {
+	return &APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     append([]string{}, key.Scopes...),
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+// ReadAPIKeys returns the API keys of a user
+func ReadAPIKeys(c *gin.Context, db *mongo.Database, username string) {
+	keys, err := services.ListAPIKeys(c.Request.Context(), db, username)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	res := make([]*APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, newAPIKeyResponse(key))
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"status":   "success",
+			"message":  "successfully retrieved api keys",
+			"count":    len(keys),
+			"api_keys": res,
+		},
+	)
+}
+
+// CreateAPIKey gives a user a new API key, returned only in this
+// response
+func CreateAPIKey(c *gin.Context, db *mongo.Database, username string) {
+	var req APIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	var ttl time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.ExpiresIn); err != nil {
+			c.Error(models.NewValidationError("invalid_duration", "expires_in must be like 720h",
+				[]models.FieldError{{Field: "expires_in", Message: err.Error()}}))
+			return
+		}
+	}
+
+	secret, key, err := services.CreateAPIKey(c.Request.Context(), db, username, req.Name, req.Scopes, ttl)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully created api key, store it now as it is not shown again",
+			"key":     secret,
+			"api_key": newAPIKeyResponse(key),
+		})
+}
+
+// DeleteAPIKey revokes an API key of a user
+func DeleteAPIKey(c *gin.Context, db *mongo.Database, username, id string) {
+	if err := services.DeleteAPIKey(c.Request.Context(), db, username, id); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully deleted api key",
+		})
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"gonews/models"
+	"gonews/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AuditEntryResponse is an audit entry as returned by the API. Before and
+// After are the stored documents, with their database field names.
+type AuditEntryResponse struct {
+	ID         primitive.ObjectID     `json:"id"`
+	Actor      string                 `json:"actor"`
+	IP         string                 `json:"ip"`
+	Method     string                 `json:"method"`
+	Route      string                 `json:"route"`
+	RequestID  string                 `json:"request_id"`
+	Action     string                 `json:"action"`
+	Resource   string                 `json:"resource"`
+	ResourceID string                 `json:"resource_id"`
+	Before     map[string]interface{} `json:"before"`
+	After      map[string]interface{} `json:"after"`
+	Details    map[string]string      `json:"details"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// ReadAuditEntries returns the audit entries matching the actor, resource,
+// resource_id, action, since, until and limit query parameters, newest
+// first
+func ReadAuditEntries(c *gin.Context, db *mongo.Database) {
+	filter := services.AuditFilter{
+		Actor:      c.Query("actor"),
+		Resource:   c.Query("resource"),
+		ResourceID: c.Query("resource_id"),
+		Action:     c.Query("action"),
+	}
+
+	var fieldErrs []models.FieldError
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if value := c.Query(param.name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				fieldErrs = append(fieldErrs, models.FieldError{Field: param.name, Message: "must be an RFC 3339 time"})
+				continue
+			}
+			*param.dest = t
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: "limit", Message: "must be an integer"})
+		}
+		filter.Limit = limit
+	}
+	if len(fieldErrs) > 0 {
+		c.Error(models.NewValidationError("invalid_query", "Invalid query parameters", fieldErrs))
+		return
+	}
+
+	entries, err := services.ListAuditEntries(c.Request.Context(), db, filter)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	res := make([]*AuditEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		res = append(res, &AuditEntryResponse{
+			ID:         entry.ID,
+			Actor:      entry.Actor,
+			IP:         entry.IP,
+			Method:     entry.Method,
+			Route:      entry.Route,
+			RequestID:  entry.RequestID,
+			Action:     entry.Action,
+			Resource:   entry.Resource,
+			ResourceID: entry.ResourceID,
+			Before:     entry.Before,
+			After:      entry.After,
+			Details:    entry.Details,
+			CreatedAt:  entry.CreatedAt,
+		})
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully retrieved audit entries",
+			"count":   len(entries),
+			"entries": res,
+		},
+	)
+}
@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"gonews/auth"
+	"gonews/config"
+	"gonews/mail"
+	"gonews/models"
+	"gonews/services"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LoginRequest is the body of POST /auth/login
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Restore takes the user out of the trash instead of refusing them
+	Restore bool `json:"restore"`
+}
+
+// TwoFactorLoginRequest is the body of POST /auth/login/2fa
+type TwoFactorLoginRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// TwoFactorCodeRequest is the body of the /auth/2fa routes that need a
+// current TOTP or recovery code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// TokenRequest is the body of POST /auth/verify-email
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+// PasswordResetRequest is the body of POST /auth/password-reset
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetConfirmRequest is the body of POST
+// /auth/password-reset/confirm
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Login exchanges a username and password for a bearer token, or for a
+// challenge if the user enabled two-factor authentication
+func Login(c *gin.Context, db *mongo.Database, sender mail.Sender, ttl, retention time.Duration, admins, twoFactorRoles []string, lockout config.LockoutConfig) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	result, err := services.Login(c.Request.Context(), db, sender, req.Username, req.Password, req.Restore, ttl, retention, admins, twoFactorRoles, lockout)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	renderLogin(c, result)
+}
+
+// renderLogin responds with the session or two-factor challenge of a
+// login
+func renderLogin(c *gin.Context, result *services.LoginResult) {
+	if result.TwoFactorRequired {
+		c.JSON(http.StatusOK,
+			gin.H{
+				"status":              "success",
+				"message":             "enter a two-factor code at /auth/login/2fa",
+				"two_factor_required": true,
+				"challenge":           result.Token,
+				"expires_at":          result.ExpiresAt,
+			})
+		return
+	}
+
+	message := "successfully logged in"
+	if result.TwoFactorSetupRequired {
+		message = "successfully logged in, enable two-factor authentication to use the privileges of your role"
+	}
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":                    "success",
+			"message":                   message,
+			"token":                     result.Token,
+			"expires_at":                result.ExpiresAt,
+			"two_factor_setup_required": result.TwoFactorSetupRequired,
+		})
+}
+
+// CompleteLogin exchanges a login challenge and a two-factor code for a
+// bearer token
+func CompleteLogin(c *gin.Context, db *mongo.Database, sender mail.Sender, ttl, retention time.Duration, lockout config.LockoutConfig) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	result, err := services.CompleteLogin(c.Request.Context(), db, sender, req.Challenge, req.Code, ttl, retention, lockout)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":     "success",
+			"message":    "successfully logged in",
+			"token":      result.Token,
+			"expires_at": result.ExpiresAt,
+		})
+}
+
+// EnrollTwoFactor gives the authenticated user a new TOTP secret
+func EnrollTwoFactor(c *gin.Context, db *mongo.Database) {
+	enrollment, err := services.EnrollTwoFactor(c.Request.Context(), db, auth.Actor(c.Request.Context()))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "add the secret to your authenticator app and confirm it with a code",
+			"secret":  enrollment.Secret,
+			"uri":     enrollment.URI,
+		})
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the
+// authenticated user
+func ConfirmTwoFactor(c *gin.Context, db *mongo.Database) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	codes, err := services.ConfirmTwoFactor(c.Request.Context(), db, auth.Actor(c.Request.Context()), req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":         "success",
+			"message":        "two-factor authentication enabled, store the recovery codes somewhere safe",
+			"recovery_codes": codes,
+		})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the
+// authenticated user
+func RegenerateRecoveryCodes(c *gin.Context, db *mongo.Database) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	codes, err := services.RegenerateRecoveryCodes(c.Request.Context(), db, auth.Actor(c.Request.Context()), req.Code)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":         "success",
+			"message":        "successfully replaced recovery codes",
+			"recovery_codes": codes,
+		})
+}
+
+// DisableTwoFactor turns two-factor authentication off for the
+// authenticated user
+func DisableTwoFactor(c *gin.Context, db *mongo.Database, twoFactorRoles []string) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	if err := services.DisableTwoFactor(c.Request.Context(), db, auth.Actor(c.Request.Context()), req.Code, twoFactorRoles); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "two-factor authentication disabled",
+		})
+}
+
+// Logout ends the session of the request's bearer token
+func Logout(c *gin.Context, db *mongo.Database) {
+	_, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+
+	if err := services.Logout(c.Request.Context(), db, token); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully logged out",
+		})
+}
+
+// VerifyEmail marks the address a verification token was sent to as
+// verified
+func VerifyEmail(c *gin.Context, db *mongo.Database) {
+	var req TokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	user, err := services.VerifyEmail(c.Request.Context(), db, req.Token)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully verified email",
+			"user":    newUserResponse(user),
+		})
+}
+
+// ResendVerification emails the authenticated user a new verification
+// token
+func ResendVerification(c *gin.Context, db *mongo.Database, sender mail.Sender, ttl time.Duration) {
+	username := auth.Actor(c.Request.Context())
+	if err := services.ResendVerification(c.Request.Context(), db, sender, username, ttl); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "verification email sent",
+		})
+}
+
+// RequestPasswordReset emails a reset token to the accounts with an
+// address
+func RequestPasswordReset(c *gin.Context, db *mongo.Database, sender mail.Sender, ttl time.Duration) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	if err := services.RequestPasswordReset(c.Request.Context(), db, sender, req.Email, ttl); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "if an account uses this address, a reset email is on its way",
+		})
+}
+
+// ConfirmPasswordReset sets a new password with a reset token
+func ConfirmPasswordReset(c *gin.Context, db *mongo.Database) {
+	var req PasswordResetConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	if err := services.ResetPassword(c.Request.Context(), db, req.Token, req.Password); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully reset password, log in again",
+		})
+}
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"gonews/logging"
+	"gonews/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Healthz reports that the process is alive
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz reports whether the server can serve traffic: it is not shutting
+// down and the database answers a ping within timeout
+func Readyz(c *gin.Context, db *mongo.Database, timeout time.Duration, draining *atomic.Bool) {
+	if draining.Load() {
+		c.Error(&models.Error{Kind: models.ErrUnavailable, Code: "not_ready", Message: "Service is shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	if err := db.Client().Ping(ctx, nil); err != nil {
+		logging.FromContext(c.Request.Context()).Warn("Database ping failed", slog.Any("error", err))
+		c.Error(&models.Error{Kind: models.ErrUnavailable, Code: "not_ready", Message: "Database is unreachable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
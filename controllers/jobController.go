@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"gonews/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JobResponse is the state of a background job as returned by the API
+type JobResponse struct {
+	ID        primitive.ObjectID `json:"id"`
+	Type      string             `json:"type"`
+	State     string             `json:"state"`
+	Step      string             `json:"step"`
+	Attempts  int                `json:"attempts"`
+	Error     string             `json:"error"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// ReadJob returns the state of a background job
+func ReadJob(c *gin.Context, db *mongo.Database, id string) {
+	job, err := services.GetJob(c.Request.Context(), db, id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully retrieved job",
+			"job": &JobResponse{
+				ID:        job.ID,
+				Type:      job.Type,
+				State:     job.State,
+				Step:      job.Step,
+				Attempts:  job.Attempts,
+				Error:     job.Error,
+				CreatedAt: job.CreatedAt,
+				UpdatedAt: job.UpdatedAt,
+			},
+		},
+	)
+}
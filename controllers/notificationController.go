@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"gonews/models"
+	"gonews/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NotificationResponse is a notification as returned by the API
+type NotificationResponse struct {
+	ID        primitive.ObjectID  `json:"id"`
+	Kind      string              `json:"kind"`
+	Message   string              `json:"message"`
+	PostID    *primitive.ObjectID `json:"post_id"`
+	CreatedAt time.Time           `json:"created_at"`
+	ReadAt    *time.Time          `json:"read_at"`
+}
+
+func newNotificationResponses(notifications models.Notifications) []*NotificationResponse {
+	res := make([]*NotificationResponse, 0, len(notifications))
+	for _, n := range notifications {
+		res = append(res, &NotificationResponse{
+			ID:        n.ID,
+			Kind:      n.Kind,
+			Message:   n.Message,
+			PostID:    n.PostID,
+			CreatedAt: n.CreatedAt,
+			ReadAt:    n.ReadAt,
+		})
+	}
+	return res
+}
+
+// ReadNotifications returns the latest notifications of a user, only
+// unread ones with ?unread=true
+func ReadNotifications(c *gin.Context, db *mongo.Database, username string) {
+	unread := c.Query("unread") == "true"
+	notifications, err := services.ListNotifications(c.Request.Context(), db, username, unread)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"status":        "success",
+			"message":       "successfully retrieved notifications",
+			"count":         len(notifications),
+			"notifications": newNotificationResponses(notifications),
+		},
+	)
+}
+
+// MarkNotificationsRead marks every notification of a user as read
+func MarkNotificationsRead(c *gin.Context, db *mongo.Database, username string) {
+	marked, err := services.MarkNotificationsRead(c.Request.Context(), db, username)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"status":       "success",
+			"message":      "successfully marked notifications as read",
+			"marked_count": marked,
+		},
+	)
+}
@@ -1,77 +1,98 @@
 package controllers
 
 import (
+	"gonews/filters"
 	"gonews/models"
 	"gonews/services"
+	"gonews/validation"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func CreatePost(c *gin.Context, dbClient *mongo.Client, username string) {
-	post := models.Post{}
+// PostRequest is the body of POST /users/:username/posts. The author and
+// tags come from the path and the content.
+type PostRequest struct {
+	Content string `json:"content" binding:"required"`
+}
 
-	// Bind the request body to the Post struct
-	if err := c.ShouldBindJSON(&post); err != nil {
-		// If there is an error, return a Bad Request response
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
+// toModel returns the post the request describes, leaving every
+// server-owned field zero
+func (req PostRequest) toModel() models.Post {
+	return models.Post{Content: req.Content}
+}
 
-	post.CreatedAt, post.UpdatedAt = time.Now(), time.Now()
-	post.Author = username
+// PostResponse is a post as returned by the API
+type PostResponse struct {
+	ID        primitive.ObjectID `json:"id"`
+	Author    string             `json:"author"`
+	Content   string             `json:"content"`
+	Tags      []string           `json:"tags"`
+	Hidden    bool               `json:"hidden"`
+	DeletedAt *time.Time         `json:"deleted_at"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
 
-	// Parse hashtags from content
-	tags := services.ParseHashtags(post.Content)
+func newPostResponse(post *models.Post) *PostResponse {
+	return &PostResponse{
+		ID:        post.ID,
+		Author:    post.Author,
+		Content:   post.Content,
+		Tags:      append([]string{}, post.Tags...),
+		Hidden:    post.Hidden,
+		DeletedAt: post.DeletedAt,
+		CreatedAt: post.CreatedAt,
+		UpdatedAt: post.UpdatedAt,
+	}
+}
 
-	// Iterate through tags and create them in the database if they don't already exist
-	for _, tag := range tags {
-		models.DbInsertTag(dbClient, tag)
+func newPostResponses(posts models.Posts) []*PostResponse {
+	res := make([]*PostResponse, 0, len(posts))
+	for _, post := range posts {
+		res = append(res, newPostResponse(post))
 	}
+	return res
+}
 
-	post.Tags = tags
+func CreatePost(c *gin.Context, db *mongo.Database, username string, contentFilters *filters.Pipeline) {
+	var req PostRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// If there is an error, return a Bad Request response listing the invalid fields
+		c.Error(validation.FromBinding(err))
+		return
+	}
 
-	// Insert post to database
-	dbPost, err := models.DbInsertPost(dbClient, post)
+	// Insert post and its hashtags to database
+	dbPost, err := services.CreatePost(c.Request.Context(), db, username, req.toModel(), contentFilters)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
-	// Convert dbPost to a primitive.ObjectID
-	dbPostId := dbPost.(primitive.ObjectID)
-
-	// Iterate through tags and add the post ID to the tag
-	for _, tag := range tags {
-		err = models.DbAddPostToTag(dbClient, tag, dbPostId)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
+	message := "successfully created post"
+	if dbPost.Hidden {
+		message = "post is held for review by a moderator"
 	}
-
 	c.JSON(http.StatusOK,
 		gin.H{
 			"status":  "success",
-			"message": "successfully created post",
-			"user":    &post,
-			"res":     dbPost,
+			"message": message,
+			"post":    newPostResponse(dbPost),
+			"res":     dbPost.ID,
 		})
 }
 
-// DeleteUser deletes a user from the database with the given username
-func DeletePost(c *gin.Context, dbClient *mongo.Client, id string) {
-	// Create a filter to find the user by ID
-	filter := bson.M{"id": id}
-
+// DeletePost moves the post with the given ID to the trash
+func DeletePost(c *gin.Context, db *mongo.Database, username, id string) {
 	// Delete the post from the database
-	deleteResult, err := models.DbDeletePost(dbClient, filter)
+	deleteResult, err := services.DeletePost(c.Request.Context(), db, username, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -84,11 +105,27 @@ func DeletePost(c *gin.Context, dbClient *mongo.Client, id string) {
 		})
 }
 
+// RestorePost takes the post with the given ID out of the trash
+func RestorePost(c *gin.Context, db *mongo.Database, username, id string, retention time.Duration) {
+	post, err := services.RestorePost(c.Request.Context(), db, username, id, retention)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully restored post",
+			"post":    newPostResponse(post),
+		})
+}
+
 // Returns all posts
-func ReadPosts(c *gin.Context, dbClient *mongo.Client) {
-	posts, err, count := models.DbQueryPosts(dbClient, bson.M{})
+func ReadPosts(c *gin.Context, db *mongo.Database) {
+	posts, count, err := services.ListPosts(c.Request.Context(), db)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -98,22 +135,16 @@ func ReadPosts(c *gin.Context, dbClient *mongo.Client) {
 			"status":  "success",
 			"message": "successfully retrieved posts",
 			"count":   count,
-			"posts":   posts,
+			"posts":   newPostResponses(posts),
 		},
 	)
 }
 
 // Returns all posts from specific user
-func ReadUserPosts(c *gin.Context, dbClient *mongo.Client, username string) {
-	// Create a filter to find all posts with given author
-	filter := bson.M{"author": username}
-
-	posts, err, count := models.DbQueryPosts(dbClient, filter)
+func ReadUserPosts(c *gin.Context, db *mongo.Database, username string) {
+	posts, _, err := services.ListUserPosts(c.Request.Context(), db, username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	} else if count == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Author does not exist"})
+		c.Error(err)
 		return
 	}
 
@@ -122,74 +153,52 @@ func ReadUserPosts(c *gin.Context, dbClient *mongo.Client, username string) {
 		gin.H{
 			"status":  "success",
 			"message": "successfully retrieved user posts",
-			"posts":   posts,
+			"posts":   newPostResponses(posts),
 		},
 	)
 }
 
-// Returns all posts with given hasthag
-func ReadPostsByTag(c *gin.Context, dbClient *mongo.Client, tag string) {
-	// Create a filter to find all posts with given author
-	filter := bson.M{"name": tag}
+// Returns a page of posts with given hashtag, newest first, continuing
+// after the post ID in the before query parameter
+func ReadPostsByTag(c *gin.Context, db *mongo.Database, tag string) {
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			c.Error(models.NewValidationError("invalid_query", "Invalid query parameters",
+				[]models.FieldError{{Field: "limit", Message: "must be an integer"}}))
+			return
+		}
+	}
 
-	tags, err, count := models.DbQueryTags(dbClient, filter)
+	page, err := services.ListPostsByTag(c.Request.Context(), db, tag, c.Query("before"), limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	} else if count == 0 {
-		emptyPosts := []models.Post{}
-		c.JSON(
-			http.StatusOK,
-			gin.H{
-				"status":  "success",
-				"message": "this tag has no posts",
-				"posts":   emptyPosts,
-			},
-		)
+		c.Error(err)
 		return
 	}
-	tagObject := tags[0]
 
-	// Get all posts from tagObject.Posts
-	postIds := make([]primitive.ObjectID, 0, len(tagObject.Posts))
-	for _, id := range tagObject.Posts {
-		postIds = append(postIds, id)
-	}
-
-	posts, err := models.DbDereferencePosts(dbClient, postIds)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	message := "successfully retrieved tag posts"
+	if page.PostCount == 0 {
+		message = "this tag has no posts"
 	}
 
 	c.JSON(
 		http.StatusOK,
 		gin.H{
-			"status":  "success",
-			"message": "successfully retrieved user posts",
-			"posts":   posts,
+			"status":     "success",
+			"message":    message,
+			"post_count": page.PostCount,
+			"posts":      newPostResponses(page.Posts),
+			"next":       page.Next,
 		},
 	)
 }
 
 // Returns post with specified ID
-func ReadSinglePost(c *gin.Context, dbClient *mongo.Client, id string) {
-	// Convert the string ID to a primitive ObjectID
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID format"})
-		return
-	}
-
-	// Create a filter to find the post by ID
-	filter := bson.M{"_id": objectID}
-
-	post, err, count := models.DbQueryPosts(dbClient, filter)
+func ReadSinglePost(c *gin.Context, db *mongo.Database, id string) {
+	post, err := services.GetPost(c.Request.Context(), db, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	} else if count == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Post does not exist"})
+		c.Error(err)
 		return
 	}
 
@@ -198,7 +207,7 @@ func ReadSinglePost(c *gin.Context, dbClient *mongo.Client, id string) {
 		gin.H{
 			"status":  "success",
 			"message": "successfully retrieved post",
-			"post":    post,
+			"post":    newPostResponse(post),
 		},
 	)
 }
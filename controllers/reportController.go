@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"gonews/models"
+	"gonews/services"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReportRequest is the body of POST /posts/:id/report
+type ReportRequest struct {
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+// ResolveReportsRequest is the body of POST /admin/reports/:id/resolve.
+// Duration only applies to the suspend action, empty to suspend
+// indefinitely.
+type ResolveReportsRequest struct {
+	Action   string `json:"action"`
+	Note     string `json:"note"`
+	Duration string `json:"duration"`
+}
+
+// ReportResponse is a report as returned by the API
+type ReportResponse struct {
+	ID         primitive.ObjectID `json:"id"`
+	PostID     primitive.ObjectID `json:"post_id"`
+	Reason     string             `json:"reason"`
+	Comment    string             `json:"comment"`
+	Status     string             `json:"status"`
+	Resolution string             `json:"resolution"`
+	ResolvedBy string             `json:"resolved_by"`
+	ResolvedAt *time.Time         `json:"resolved_at"`
+	CreatedAt  time.Time          `json:"created_at"`
+}
+
+// ReportGroupResponse sums up the reports of one post, Post is null once
+// the post is deleted
+type ReportGroupResponse struct {
+	PostID          primitive.ObjectID    `json:"post_id"`
+	Count           int                   `json:"count"`
+	Reasons         []ReasonCountResponse `json:"reasons"`
+	FirstReportedAt time.Time             `json:"first_reported_at"`
+	LastReportedAt  time.Time             `json:"last_reported_at"`
+	Post            *PostResponse         `json:"post"`
+}
+
+// ReasonCountResponse is the number of reports of a post with one reason
+type ReasonCountResponse struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+func newReportGroupResponse(group *models.ReportGroup) *ReportGroupResponse {
+	res := &ReportGroupResponse{
+		PostID:          group.PostID,
+		Count:           group.Count,
+		Reasons:         make([]ReasonCountResponse, 0, len(group.Reasons)),
+		FirstReportedAt: group.FirstReportedAt,
+		LastReportedAt:  group.LastReportedAt,
+	}
+	for _, reason := range group.Reasons {
+		res.Reasons = append(res.Reasons, ReasonCountResponse{Reason: reason.Reason, Count: reason.Count})
+	}
+	if group.Post != nil {
+		res.Post = newPostResponse(group.Post)
+	}
+	return res
+}
+
+// ReportPost files a report of a post by the authenticated user
+func ReportPost(c *gin.Context, db *mongo.Database, id string, threshold int) {
+	var req ReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	report, err := services.ReportPost(c.Request.Context(), db, id, req.Reason, req.Comment, threshold)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully reported post",
+			"report": &ReportResponse{
+				ID:         report.ID,
+				PostID:     report.PostID,
+				Reason:     report.Reason,
+				Comment:    report.Comment,
+				Status:     report.Status,
+				Resolution: report.Resolution,
+				ResolvedBy: report.ResolvedBy,
+				ResolvedAt: report.ResolvedAt,
+				CreatedAt:  report.CreatedAt,
+			},
+		})
+}
+
+// ReadReports returns the moderation queue: reported posts with the
+// status query parameter, open by default, grouped per post
+func ReadReports(c *gin.Context, db *mongo.Database) {
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			c.Error(models.NewValidationError("invalid_query", "Invalid query parameters",
+				[]models.FieldError{{Field: "limit", Message: "must be an integer"}}))
+			return
+		}
+	}
+
+	groups, err := services.ListReportGroups(c.Request.Context(), db, c.Query("status"), limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	res := make([]*ReportGroupResponse, 0, len(groups))
+	for i := range groups {
+		res = append(res, newReportGroupResponse(&groups[i]))
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":  "success",
+			"message": "successfully retrieved reports",
+			"count":   len(groups),
+			"reports": res,
+		})
+}
+
+// ResolveReports takes a moderator action on a reported post and resolves
+// its open reports
+func ResolveReports(c *gin.Context, db *mongo.Database, id string) {
+	var req ResolveReportsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil {
+			c.Error(models.NewValidationError("invalid_duration", "Duration must be like 72h",
+				[]models.FieldError{{Field: "duration", Message: err.Error()}}))
+			return
+		}
+	}
+
+	resolved, err := services.ResolveReports(c.Request.Context(), db, id, req.Action, req.Note, duration)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":         "success",
+			"message":        "successfully resolved reports",
+			"resolved_count": resolved,
+		})
+}
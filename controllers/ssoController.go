@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"gonews/models"
+	"gonews/oidc"
+	"gonews/services"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ReadOIDCProviders returns the names of the providers users can sign in
+// with
+func ReadOIDCProviders(c *gin.Context, providers oidc.Providers) {
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":    "success",
+			"message":   "successfully retrieved sign-in providers",
+			"providers": providers.Names(),
+		})
+}
+
+// StartOIDCLogin returns the URL to send the user to for signing in with a
+// provider
+func StartOIDCLogin(c *gin.Context, db *mongo.Database, providers oidc.Providers, name string, stateTTL time.Duration) {
+	authURL, err := services.StartOIDCLogin(c.Request.Context(), db, providers, name, stateTTL)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":            "success",
+			"message":           "sign in at the authorization url",
+			"authorization_url": authURL,
+		})
+}
+
+// OIDCCallback logs in the user the provider redirected back, responding
+// as Login does
+func OIDCCallback(c *gin.Context, db *mongo.Database, providers oidc.Providers, name string, ttl time.Duration, twoFactorRoles []string) {
+	// The provider reports a denied or failed sign-in in place of a code
+	if c.Query("error") != "" || c.Query("code") == "" {
+		c.Error(models.ErrOIDCFailed)
+		return
+	}
+
+	result, err := services.CompleteOIDCLogin(c.Request.Context(), db, providers, name, c.Query("state"), c.Query("code"), ttl, twoFactorRoles)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	renderLogin(c, result)
+}
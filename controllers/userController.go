@@ -1,30 +1,116 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"gonews/auth"
+	"gonews/logging"
+	"gonews/mail"
 	"gonews/models"
+	"gonews/services"
+	"gonews/validation"
+	"log/slog"
+	"mime"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func CreateUser(c *gin.Context, dbClient *mongo.Client) {
-	user := models.User{}
+// UserRequest is the body of POST /users and PUT /users/:username. The
+// rules on models.User apply once it is mapped to one.
+type UserRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
 
-	// Bind the request body to the User struct
-	if err := c.ShouldBindJSON(&user); err != nil {
-		// If there is an error, return a Bad Request response
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// toModel returns the user the request describes, leaving every
+// server-owned field zero
+func (req UserRequest) toModel() models.User {
+	return models.User{Username: req.Username, Email: req.Email, Password: req.Password}
+}
+
+// UserResponse is a user as returned by the API, without the password,
+// two-factor secrets and other internal state
+type UserResponse struct {
+	ID               primitive.ObjectID `json:"id"`
+	Username         string             `json:"username"`
+	Email            string             `json:"email"`
+	Role             string             `json:"role"`
+	EmailVerifiedAt  *time.Time         `json:"email_verified_at"`
+	TwoFactorEnabled bool               `json:"two_factor_enabled"`
+	Identities       []IdentityResponse `json:"identities"`
+	SuspendedUntil   *time.Time         `json:"suspended_until"`
+	DeletedAt        *time.Time         `json:"deleted_at"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+}
+
+// PublicUserResponse is a user as shown to anyone but themselves and
+// user admins
+type PublicUserResponse struct {
+	ID        primitive.ObjectID `json:"id"`
+	Username  string             `json:"username"`
+	CreatedAt time.Time          `json:"created_at"`
+}
+
+// IdentityResponse is an OIDC provider a user signs in with
+type IdentityResponse struct {
+	Provider string    `json:"provider"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+func newUserResponse(user *models.User) *UserResponse {
+	res := &UserResponse{
+		ID:               user.ID,
+		Username:         user.Username,
+		Email:            user.Email,
+		Role:             user.EffectiveRole(),
+		EmailVerifiedAt:  user.EmailVerifiedAt,
+		TwoFactorEnabled: user.TwoFactorEnabled(),
+		Identities:       make([]IdentityResponse, 0, len(user.Identities)),
+		SuspendedUntil:   user.SuspendedUntil,
+		DeletedAt:        user.DeletedAt,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+	}
+	for _, identity := range user.Identities {
+		res.Identities = append(res.Identities, IdentityResponse{Provider: identity.Provider, LinkedAt: identity.LinkedAt})
 	}
+	return res
+}
 
-	user.CreatedAt, user.UpdatedAt = time.Now(), time.Now()
-	dbUser, err := models.DbInsertUser(dbClient, user)
+// newVisibleUserResponse returns the full response for the user
+// themselves and user admins, and the public one for everyone else
+func newVisibleUserResponse(c *gin.Context, user *models.User) interface{} {
+	if auth.CanSeeAccount(c.Request.Context(), user.Username) {
+		return newUserResponse(user)
+	}
+	return &PublicUserResponse{ID: user.ID, Username: user.Username, CreatedAt: user.CreatedAt}
+}
 
+func newVisibleUserResponses(c *gin.Context, users models.Users) []interface{} {
+	res := make([]interface{}, 0, len(users))
+	for _, user := range users {
+		res = append(res, newVisibleUserResponse(c, user))
+	}
+	return res
+}
+
+func CreateUser(c *gin.Context, db *mongo.Database, sender mail.Sender, verificationTTL time.Duration) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// If there is an error, return a Bad Request response listing the invalid fields
+		c.Error(validation.FromBinding(err))
+		return
+	}
+
+	dbUser, err := services.CreateUser(c.Request.Context(), db, req.toModel(), sender, verificationTTL)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -32,70 +118,133 @@ func CreateUser(c *gin.Context, dbClient *mongo.Client) {
 		gin.H{
 			"status":  "success",
 			"message": "successfully created user",
-			"user":    &user,
-			"res":     dbUser,
+			"user":    newUserResponse(dbUser),
+			"res":     dbUser.ID,
 		})
 }
 
-func UpdateUser(c *gin.Context, dbClient *mongo.Client, username string) {
-	user := models.User{}
+// UpdateUser replaces every mutable field of a user
+func UpdateUser(c *gin.Context, db *mongo.Database, username string, sender mail.Sender, verificationTTL time.Duration) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// If there is an error, return a Bad Request response listing the invalid fields
+		c.Error(validation.FromBinding(err))
+		return
+	}
 
-	// Bind the request body to the User struct
-	if err := c.ShouldBindJSON(&user); err != nil {
-		// If there is an error, return a Bad Request response
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Update the user in the database
+	updatedUser, modified, err := services.ReplaceUser(c.Request.Context(), db, username, req.toModel(), sender, verificationTTL)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
-	// Update the updated_at field
-	user.UpdatedAt = time.Now()
+	// Return a success response
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":   "success",
+			"message":  "successfully updated user",
+			"user":     newUserResponse(updatedUser),
+			"modified": modified,
+		})
+}
 
-	// Create a filter to find the user by username
-	filter := bson.M{"username": username}
+// PatchUser applies a JSON merge patch to a user
+func PatchUser(c *gin.Context, db *mongo.Database, username string, sender mail.Sender, verificationTTL time.Duration) {
+	patch := map[string]interface{}{}
+
+	// A merge patch must be a JSON object
+	if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Error(models.ErrBodyTooLarge)
+		} else {
+			c.Error(models.NewValidationError("invalid_body", "Merge patch must be a JSON object", nil))
+		}
+		return
+	}
 
 	// Update the user in the database
-	updateResult, err := models.DbUpdateUser(dbClient, filter, user)
+	updatedUser, modified, err := services.PatchUser(c.Request.Context(), db, username, patch, sender, verificationTTL)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
+	message := "successfully updated user"
+	if !modified {
+		message = "user already up to date"
+	}
+
 	// Return a success response
 	c.JSON(http.StatusOK,
 		gin.H{
-			"status":  "success",
-			"message": "successfully updated user",
-			"user":    &user,
-			"res":     updateResult,
+			"status":   "success",
+			"message":  message,
+			"user":     newUserResponse(updatedUser),
+			"modified": modified,
 		})
 }
 
-// DeleteUser deletes a user from the database with the given username
-func DeleteUser(c *gin.Context, dbClient *mongo.Client, username string) {
-	// Create a filter to find the user by ID
-	filter := bson.M{"username": username}
+// DeleteUser moves a user and their posts to the trash. The policy query
+// parameter overrides defaultPolicy.
+func DeleteUser(c *gin.Context, db *mongo.Database, username, defaultPolicy string, retention time.Duration) {
+	policy := c.DefaultQuery("policy", defaultPolicy)
 
-	// Delete the user from the database
-	deleteResult, err := models.DbDeleteUser(dbClient, filter)
+	user, err := services.DeleteUser(c.Request.Context(), db, username, policy)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
 	// Return a success response
+	c.JSON(http.StatusOK,
+		gin.H{
+			"status":        "success",
+			"message":       "successfully deleted user",
+			"user":          newUserResponse(user),
+			"restore_until": user.DeletedAt.Add(retention),
+		})
+}
+
+// RestoreUser takes a user and their posts out of the trash
+func RestoreUser(c *gin.Context, db *mongo.Database, username string, retention time.Duration) {
+	user, err := services.RestoreUser(c.Request.Context(), db, username, retention)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
 	c.JSON(http.StatusOK,
 		gin.H{
 			"status":  "success",
-			"message": "successfully deleted user",
-			"res":     deleteResult,
+			"message": "successfully restored user",
+			"user":    newUserResponse(user),
 		})
 }
 
+// ExportUser downloads a zip archive of everything stored about a user
+func ExportUser(c *gin.Context, db *mongo.Database, username string) {
+	export, err := services.ExportUser(c.Request.Context(), db, username)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": username + "-export.zip"}))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	if err := export.WriteZip(c.Writer); err != nil {
+		// Too late for an error response, the archive is cut short
+		logging.FromContext(c.Request.Context()).Error("Error writing export", slog.Any("error", err))
+	}
+}
+
 // Returns all users
-func ReadUsers(c *gin.Context, dbClient *mongo.Client) {
-	users, err, count := models.DbQueryUsers(dbClient, bson.M{})
+func ReadUsers(c *gin.Context, db *mongo.Database) {
+	users, count, err := services.ListUsers(c.Request.Context(), db)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.Error(err)
 		return
 	}
 
@@ -105,22 +254,16 @@ func ReadUsers(c *gin.Context, dbClient *mongo.Client) {
 			"status":  "success",
 			"message": "successfully retrieved users",
 			"count":   count,
-			"users":   users,
+			"users":   newVisibleUserResponses(c, users),
 		},
 	)
 }
 
 // Returns user with specified ID
-func ReadSingleUser(c *gin.Context, dbClient *mongo.Client, username string) {
-	// Create a filter to find the user by ID
-	filter := bson.M{"username": username}
-
-	user, err, count := models.DbQueryUsers(dbClient, filter)
+func ReadSingleUser(c *gin.Context, db *mongo.Database, username string) {
+	user, err := services.GetUser(c.Request.Context(), db, username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	} else if count == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User does not exist"})
+		c.Error(err)
 		return
 	}
 
@@ -129,7 +272,7 @@ func ReadSingleUser(c *gin.Context, dbClient *mongo.Client, username string) {
 		gin.H{
 			"status":  "success",
 			"message": "successfully retrieved user",
-			"user":    user,
+			"user":    newVisibleUserResponse(c, user),
 		},
 	)
 }
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"context"
+
+	"gonews/config"
+	"gonews/tracing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DB connection
+func CreateConnection(cfg config.MongoConfig) (*mongo.Client, error) {
+	clientOpts := options.Client().ApplyURI(cfg.URL).SetMonitor(tracing.CommandMonitor())
+	if cfg.Username != "" {
+		credential := options.Credential{
+			AuthMechanism: cfg.AuthMechanism,
+			Username:      cfg.Username, // mongodb user
+			Password:      cfg.Password,
+		}
+		clientOpts.SetAuth(credential)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+
+	return mongo.Connect(ctx, clientOpts)
+}
@@ -0,0 +1,120 @@
+package filters
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Classifier is a naive Bayes spam classifier trained with Train. It
+// flags content whose spam probability reaches threshold, once it has
+// seen minTraining spam and minTraining ham posts.
+type Classifier struct {
+	db          *mongo.Database
+	threshold   float64
+	minTraining int64
+}
+
+// NewClassifier returns a classifier stage backed by db
+func NewClassifier(db *mongo.Database, threshold float64, minTraining int) *Classifier {
+	return &Classifier{db: db, threshold: threshold, minTraining: int64(minTraining)}
+}
+
+func (c *Classifier) Name() string { return "spam_classifier" }
+
+func (c *Classifier) Check(ctx context.Context, input Input) (Verdict, error) {
+	tokens := Tokens(input.Content)
+	if len(tokens) == 0 {
+		return Verdict{Action: Allow}, nil
+	}
+
+	totals, err := models.DbQuerySpamTotals(ctx, c.db)
+	if err != nil {
+		return Verdict{}, err
+	} else if totals.Spam < c.minTraining || totals.Ham < c.minTraining || totals.Spam == 0 || totals.Ham == 0 {
+		return Verdict{Action: Allow}, nil
+	}
+	counts, err := models.DbQuerySpamTokens(ctx, c.db, tokens)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	if p := spamProbability(tokens, counts, totals); p >= c.threshold {
+		return Verdict{Action: Flag, Reason: fmt.Sprintf("spam probability %.2f", p)}, nil
+	}
+	return Verdict{Action: Allow}, nil
+}
+
+// spamProbability combines the prior with the Laplace-smoothed share of
+// spam and ham posts each token appeared in. Tokens never seen carry no
+// evidence and are skipped.
+func spamProbability(tokens []string, counts map[string]models.SpamToken, totals models.SpamTotals) float64 {
+	logOdds := math.Log(float64(totals.Spam) / float64(totals.Ham))
+	for _, token := range tokens {
+		count, ok := counts[token]
+		if !ok || count.Spam+count.Ham == 0 {
+			continue
+		}
+		pSpam := (float64(count.Spam) + 1) / (float64(totals.Spam) + 2)
+		pHam := (float64(count.Ham) + 1) / (float64(totals.Ham) + 2)
+		logOdds += math.Log(pSpam / pHam)
+	}
+	return 1 / (1 + math.Exp(-logOdds))
+}
+
+// Train teaches the classifier that the post with the given ID and
+// content is spam or not. Training the same post again replaces its
+// earlier label. The label is swapped first, so concurrent trainings of
+// one post never count it twice; if updating the counts then fails the
+// post is left out of them.
+func Train(ctx context.Context, db *mongo.Database, postID primitive.ObjectID, content string, spam bool) error {
+	tokens := Tokens(content)
+	previous, err := models.DbSwapSpamLabel(ctx, db, models.SpamLabel{PostID: postID, Spam: spam, Tokens: tokens})
+	if err != nil {
+		return err
+	} else if previous != nil && previous.Spam == spam {
+		return nil
+	}
+
+	counts, totals := trainingCounts(previous, tokens, spam)
+	return models.DbIncSpamCounts(ctx, db, counts, totals)
+}
+
+// trainingCounts returns the changes to the token counts and totals that
+// replace the previous label, if any, with tokens trained as spam or ham
+func trainingCounts(previous *models.SpamLabel, tokens []string, spam bool) ([]models.SpamToken, models.SpamTotals) {
+	index := map[string]int{}
+	var counts []models.SpamToken
+	var totals models.SpamTotals
+	add := func(tokens []string, spam bool, n int64) {
+		if spam {
+			totals.Spam += n
+		} else {
+			totals.Ham += n
+		}
+		for _, token := range tokens {
+			i, ok := index[token]
+			if !ok {
+				i = len(counts)
+				index[token] = i
+				counts = append(counts, models.SpamToken{Token: token})
+			}
+			if spam {
+				counts[i].Spam += n
+			} else {
+				counts[i].Ham += n
+			}
+		}
+	}
+
+	if previous != nil {
+		add(previous.Tokens, previous.Spam, -1)
+	}
+	add(tokens, spam, 1)
+	return counts, totals
+}
@@ -0,0 +1,82 @@
+package filters
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"gonews/models"
+)
+
+func TestSpamProbability(t *testing.T) {
+	totals := models.SpamTotals{Spam: 10, Ham: 10}
+	counts := map[string]models.SpamToken{
+		"pills": {Token: "pills", Spam: 9, Ham: 0},
+		"cheap": {Token: "cheap", Spam: 6, Ham: 2},
+		"hello": {Token: "hello", Spam: 1, Ham: 8},
+	}
+	tests := []struct {
+		tokens   []string
+		min, max float64
+	}{
+		{nil, 0.5, 0.5},
+		{[]string{"unseen"}, 0.5, 0.5},
+		{[]string{"pills"}, 0.9, 1},
+		{[]string{"cheap", "pills"}, 0.95, 1},
+		{[]string{"hello"}, 0, 0.2},
+		{[]string{"hello", "pills"}, 0.4, 0.8},
+	}
+	for _, tt := range tests {
+		if p := spamProbability(tt.tokens, counts, totals); p < tt.min || p > tt.max {
+			t.Errorf("%q: %.3f, want between %.2f and %.2f", tt.tokens, p, tt.min, tt.max)
+		}
+	}
+
+	// The prior leans towards the class seen more often
+	if p := spamProbability(nil, counts, models.SpamTotals{Spam: 30, Ham: 10}); math.Abs(p-0.75) > 1e-9 {
+		t.Errorf("prior: %.3f, want 0.75", p)
+	}
+}
+
+func TestTrainingCounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous *models.SpamLabel
+		tokens   []string
+		spam     bool
+		counts   []models.SpamToken
+		totals   models.SpamTotals
+	}{
+		{
+			name:   "first spam",
+			tokens: []string{"buy", "pills"},
+			spam:   true,
+			counts: []models.SpamToken{{Token: "buy", Spam: 1}, {Token: "pills", Spam: 1}},
+			totals: models.SpamTotals{Spam: 1},
+		},
+		{
+			name:   "first ham",
+			tokens: []string{"hello"},
+			counts: []models.SpamToken{{Token: "hello", Ham: 1}},
+			totals: models.SpamTotals{Ham: 1},
+		},
+		{
+			name:     "ham relabeled spam",
+			previous: &models.SpamLabel{Spam: false, Tokens: []string{"buy", "now"}},
+			tokens:   []string{"buy", "pills"},
+			spam:     true,
+			counts: []models.SpamToken{
+				{Token: "buy", Spam: 1, Ham: -1},
+				{Token: "now", Ham: -1},
+				{Token: "pills", Spam: 1},
+			},
+			totals: models.SpamTotals{Spam: 1, Ham: -1},
+		},
+	}
+	for _, tt := range tests {
+		counts, totals := trainingCounts(tt.previous, tt.tokens, tt.spam)
+		if !reflect.DeepEqual(counts, tt.counts) || totals != tt.totals {
+			t.Errorf("%s: %+v, %+v, want %+v, %+v", tt.name, counts, totals, tt.counts, tt.totals)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Package filters checks the content of new posts in a pipeline of
+// stages. Each stage may allow the content, rewrite it for the stages
+// after it, flag the post for review by moderators or reject it.
+package filters
+
+import (
+	"context"
+	"fmt"
+
+	"gonews/config"
+	"gonews/metrics"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Action is the decision of a stage
+type Action string
+
+const (
+	Allow   Action = "allow"
+	Rewrite Action = "rewrite"
+	Flag    Action = "flag"
+	Reject  Action = "reject"
+)
+
+// Input is a post about to be created
+type Input struct {
+	Author  string
+	Content string
+}
+
+// Verdict is the outcome of one stage. Content is the new content of a
+// Rewrite, Reason explains a Flag or Reject.
+type Verdict struct {
+	Action  Action
+	Content string
+	Reason  string
+}
+
+// Stage is one check of a Pipeline
+type Stage interface {
+	Name() string
+	Check(ctx context.Context, input Input) (Verdict, error)
+}
+
+// Result is the outcome of a Pipeline: the content to store and why the
+// post was flagged, if it was
+type Result struct {
+	Content string
+	Flags   []string
+}
+
+// Flagged reports whether any stage flagged the post
+func (r *Result) Flagged() bool {
+	return len(r.Flags) > 0
+}
+
+// Pipeline runs stages in order
+type Pipeline struct {
+	stages []Stage
+}
+
+// NewPipeline returns a pipeline running stages in the given order
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+// FromConfig returns the pipeline of built-in stages enabled by cfg
+func FromConfig(db *mongo.Database, cfg config.FiltersConfig) *Pipeline {
+	var stages []Stage
+	if cfg.MaxLength > 0 {
+		stages = append(stages, MaxLength(cfg.MaxLength))
+	}
+	if len(cfg.BannedWords) > 0 {
+		stages = append(stages, BannedWords(cfg.BannedWords, Action(cfg.BannedWordsAction)))
+	}
+	if cfg.MaxLinks >= 0 {
+		stages = append(stages, MaxLinks(cfg.MaxLinks, Action(cfg.LinksAction)))
+	}
+	if cfg.DuplicateWindow > 0 {
+		stages = append(stages, Duplicates(db, cfg.DuplicateWindow))
+	}
+	if cfg.SpamThreshold > 0 {
+		stages = append(stages, NewClassifier(db, cfg.SpamThreshold, cfg.SpamMinTraining))
+	}
+	return NewPipeline(stages...)
+}
+
+// Run passes input through every stage. A rejection stops the pipeline
+// with a validation error carrying the reason.
+func (p *Pipeline) Run(ctx context.Context, input Input) (*Result, error) {
+	result := &Result{Content: input.Content}
+	for _, stage := range p.stages {
+		verdict, err := stage.Check(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("content filter %s: %w", stage.Name(), err)
+		}
+		if verdict.Action != Allow {
+			metrics.PostsFiltered.WithLabelValues(stage.Name(), string(verdict.Action)).Inc()
+		}
+
+		switch verdict.Action {
+		case Rewrite:
+			input.Content = verdict.Content
+			result.Content = verdict.Content
+		case Flag:
+			result.Flags = append(result.Flags, stage.Name()+": "+verdict.Reason)
+		case Reject:
+			return nil, models.NewValidationError("content_rejected", "Post rejected: "+verdict.Reason,
+				[]models.FieldError{{Field: "content", Message: verdict.Reason}})
+		}
+	}
+	return result, nil
+}
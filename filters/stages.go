@@ -0,0 +1,130 @@
+package filters
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type maxLength struct {
+	limit int
+}
+
+// MaxLength rejects content longer than limit characters
+func MaxLength(limit int) Stage {
+	return maxLength{limit: limit}
+}
+
+func (s maxLength) Name() string { return "max_length" }
+
+func (s maxLength) Check(ctx context.Context, input Input) (Verdict, error) {
+	if n := utf8.RuneCountInString(input.Content); n > s.limit {
+		return Verdict{Action: Reject, Reason: fmt.Sprintf("content is %d characters, the limit is %d", n, s.limit)}, nil
+	}
+	return Verdict{Action: Allow}, nil
+}
+
+type bannedWords struct {
+	words  map[string]bool
+	action Action
+}
+
+// BannedWords finds the given words in content, also when written in
+// leetspeak or with a letter repeated three or more times. With Rewrite they are masked with
+// asterisks, otherwise the post is flagged or rejected.
+func BannedWords(list []string, action Action) Stage {
+	s := bannedWords{words: map[string]bool{}, action: action}
+	for _, w := range list {
+		if w = strings.TrimSpace(w); w != "" {
+			s.words[normalize(w)] = true
+		}
+	}
+	return s
+}
+
+func (s bannedWords) Name() string { return "banned_words" }
+
+func (s bannedWords) Check(ctx context.Context, input Input) (Verdict, error) {
+	var found []word
+	for _, w := range words(input.Content) {
+		// "spaaam" is "spam" and "asss" is "ass"
+		if s.words[normalize(w.text)] || s.words[fold(w.text, 1)] {
+			found = append(found, w)
+		}
+	}
+	if len(found) == 0 {
+		return Verdict{Action: Allow}, nil
+	}
+
+	if s.action != Rewrite {
+		return Verdict{Action: s.action, Reason: "content contains banned words"}, nil
+	}
+	var b strings.Builder
+	last := 0
+	for _, w := range found {
+		b.WriteString(input.Content[last:w.start])
+		b.WriteString(strings.Repeat("*", utf8.RuneCountInString(w.text)))
+		last = w.end
+	}
+	b.WriteString(input.Content[last:])
+	return Verdict{Action: Rewrite, Content: b.String()}, nil
+}
+
+// linkPattern matches URLs and bare www. hosts
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+type maxLinks struct {
+	limit  int
+	action Action
+}
+
+// MaxLinks flags or rejects content with more than limit links
+func MaxLinks(limit int, action Action) Stage {
+	return maxLinks{limit: limit, action: action}
+}
+
+func (s maxLinks) Name() string { return "max_links" }
+
+func (s maxLinks) Check(ctx context.Context, input Input) (Verdict, error) {
+	if n := len(linkPattern.FindAllStringIndex(input.Content, -1)); n > s.limit {
+		return Verdict{Action: s.action, Reason: fmt.Sprintf("content has %d links, the limit is %d", n, s.limit)}, nil
+	}
+	return Verdict{Action: Allow}, nil
+}
+
+type duplicates struct {
+	db     *mongo.Database
+	window time.Duration
+}
+
+// Duplicates rejects content its author already posted within window,
+// compared by ContentHash
+func Duplicates(db *mongo.Database, window time.Duration) Stage {
+	return duplicates{db: db, window: window}
+}
+
+func (s duplicates) Name() string { return "duplicates" }
+
+func (s duplicates) Check(ctx context.Context, input Input) (Verdict, error) {
+	filter := bson.M{
+		"author":       input.Author,
+		"content_hash": ContentHash(input.Content),
+		"created_at":   bson.M{"$gte": time.Now().Add(-s.window)},
+		"hidden":       models.AnyVisibility,
+	}
+	count, err := models.DbCountPosts(ctx, s.db, filter)
+	if err != nil {
+		return Verdict{}, err
+	} else if count > 0 {
+		return Verdict{Action: Reject, Reason: "you already posted this recently"}, nil
+	}
+	return Verdict{Action: Allow}, nil
+}
@@ -0,0 +1,105 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gonews/models"
+)
+
+func TestMaxLength(t *testing.T) {
+	tests := []struct {
+		content string
+		action  Action
+	}{
+		{"", Allow},
+		{"hello", Allow},
+		{"héllo", Allow},
+		{"hello!", Reject},
+	}
+	for _, tt := range tests {
+		verdict, err := MaxLength(5).Check(context.Background(), Input{Content: tt.content})
+		if err != nil || verdict.Action != tt.action {
+			t.Errorf("%q: %v, %v, want %v", tt.content, verdict.Action, err, tt.action)
+		}
+	}
+}
+
+func TestBannedWords(t *testing.T) {
+	stage := BannedWords([]string{"spam", "ass", " butt "}, Rewrite)
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"nothing to see", "nothing to see"},
+		{"buy spam now", "buy **** now"},
+		{"SPAM, Sp4m and spaaaam!", "****, **** and *******!"},
+		{"as but", "as but"},
+		{"ass and butt", "*** and ****"},
+		{"a55 and asssss", "*** and ******"},
+		{"spammer", "spammer"},
+	}
+	for _, tt := range tests {
+		verdict, err := stage.Check(context.Background(), Input{Content: tt.content})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.content, err)
+		}
+		got := tt.content
+		if verdict.Action == Rewrite {
+			got = verdict.Content
+		} else if verdict.Action != Allow {
+			t.Errorf("%q: %v, want rewrite or allow", tt.content, verdict.Action)
+		}
+		if got != tt.want {
+			t.Errorf("%q: rewritten to %q, want %q", tt.content, got, tt.want)
+		}
+	}
+
+	for _, action := range []Action{Flag, Reject} {
+		verdict, err := BannedWords([]string{"spam"}, action).Check(context.Background(), Input{Content: "sp4m"})
+		if err != nil || verdict.Action != action {
+			t.Errorf("%v: %v, %v", action, verdict.Action, err)
+		}
+	}
+}
+
+func TestMaxLinks(t *testing.T) {
+	tests := []struct {
+		content string
+		action  Action
+	}{
+		{"no links", Allow},
+		{"see https://example.com", Allow},
+		{"see https://example.com and www.example.org", Allow},
+		{"http://a.example https://b.example WWW.c.example", Flag},
+	}
+	for _, tt := range tests {
+		verdict, err := MaxLinks(2, Flag).Check(context.Background(), Input{Content: tt.content})
+		if err != nil || verdict.Action != tt.action {
+			t.Errorf("%q: %v, %v, want %v", tt.content, verdict.Action, err, tt.action)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	pipeline := NewPipeline(BannedWords([]string{"spam"}, Rewrite), MaxLinks(0, Flag), MaxLength(20))
+
+	result, err := pipeline.Run(context.Background(), Input{Content: "spam www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Content != "**** www.example.com" {
+		t.Errorf("content %q, want the banned word masked", result.Content)
+	}
+	if len(result.Flags) != 1 || !strings.HasPrefix(result.Flags[0], "max_links: ") {
+		t.Errorf("flags %q, want one from max_links", result.Flags)
+	}
+
+	_, err = pipeline.Run(context.Background(), Input{Content: strings.Repeat("a", 21)})
+	var modelErr *models.Error
+	if !errors.As(err, &modelErr) || modelErr.Code != "content_rejected" {
+		t.Errorf("too long: %v, want content_rejected", err)
+	}
+}
@@ -0,0 +1,115 @@
+package filters
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+// leetspeak maps characters commonly substituted for letters back to them
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l',
+}
+
+// word is a run of word characters in content, with its byte offsets
+type word struct {
+	text       string
+	start, end int
+}
+
+// isWordRune reports whether r can be part of a word, leetspeak included
+func isWordRune(r rune) bool {
+	_, leet := leetspeak[r]
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || leet
+}
+
+// words splits content into words. Trailing "!" and "|" are punctuation
+// rather than leetspeak.
+func words(content string) []word {
+	var found []word
+	add := func(start, end int) {
+		text := strings.TrimRight(content[start:end], "!|")
+		if text != "" {
+			found = append(found, word{text, start, start + len(text)})
+		}
+	}
+	start := -1
+	for i, r := range content {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+		} else if start >= 0 {
+			add(start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		add(start, len(content))
+	}
+	return found
+}
+
+// normalize lowercases s, undoes leetspeak and shortens runs of three or
+// more of a letter to two, so "Sp4aaaM" becomes "spaam". Doubled letters
+// are kept, "butt" is not "but". Digits-only words are kept as they are.
+func normalize(s string) string {
+	return fold(s, 2)
+}
+
+// fold is normalize shortening runs of three or more to keep letters
+func fold(s string, keep int) string {
+	hasLetter := false
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			hasLetter = true
+			break
+		}
+	}
+
+	var runes []rune
+	for _, r := range strings.ToLower(s) {
+		if letter, ok := leetspeak[r]; ok && hasLetter {
+			r = letter
+		}
+		runes = append(runes, r)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		n := j - i
+		if n >= 3 && unicode.IsLetter(runes[i]) {
+			n = keep
+		}
+		b.WriteString(strings.Repeat(string(runes[i]), n))
+		i = j
+	}
+	return b.String()
+}
+
+// Tokens returns the distinct normalized words of content
+func Tokens(content string) []string {
+	seen := map[string]bool{}
+	var tokens []string
+	for _, w := range words(content) {
+		token := normalize(w.text)
+		if len(token) < 2 || seen[token] {
+			continue
+		}
+		seen[token] = true
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// ContentHash identifies content regardless of case and whitespace
+func ContentHash(content string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(strings.ToLower(content)), " ")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,52 @@
+package filters
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Spam", "spam"},
+		{"Sp4m", "spam"},
+		{"$P@M", "spam"},
+		{"spaaaam", "spaam"},
+		{"butt", "butt"},
+		{"ass", "ass"},
+		{"a55", "ass"},
+		{"1337", "1337"},
+		{"2000", "2000"},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.in); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	got := Tokens("Buy CHEAP pills, buy ch3ap pills now! a")
+	want := []string{"buy", "cheap", "pills", "now"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokens = %q, want %q", got, want)
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Hello world", "hello   WORLD", true},
+		{"Hello world", " hello\nworld ", true},
+		{"Hello world", "Hello world!", false},
+		{"Hello world", "Hello wor ld", false},
+	}
+	for _, tt := range tests {
+		if same := ContentHash(tt.a) == ContentHash(tt.b); same != tt.same {
+			t.Errorf("ContentHash(%q) == ContentHash(%q) is %v, want %v", tt.a, tt.b, same, tt.same)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package jobs runs background work: jobs stored in the jobs collection
+// and periodic tasks. Any number of instances may run a Runner against
+// the same database; each job is leased to one of them at a time and
+// resumed by another if its lease runs out. Both run as the "system"
+// actor of the audit log.
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gonews/auth"
+	"gonews/config"
+	"gonews/logging"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Handler performs a job. It may be called again for the same job after
+// a crash or error, so every step must be idempotent.
+type Handler func(ctx context.Context, db *mongo.Database, job *models.Job) error
+
+// Runner polls for jobs and dispatches them to their handlers
+type Runner struct {
+	db       *mongo.Database
+	logger   *slog.Logger
+	cfg      config.JobsConfig
+	handlers map[string]Handler
+}
+
+// NewRunner returns a runner with no handlers
+func NewRunner(db *mongo.Database, logger *slog.Logger, cfg config.JobsConfig) *Runner {
+	return &Runner{db: db, logger: logger, cfg: cfg, handlers: map[string]Handler{}}
+}
+
+// Handle registers the handler for jobs of the given type
+func (r *Runner) Handle(jobType string, handler Handler) {
+	r.handlers[jobType] = handler
+}
+
+// Run processes jobs until ctx is cancelled
+func (r *Runner) Run(ctx context.Context) {
+	var types []string
+	for jobType := range r.handlers {
+		types = append(types, jobType)
+	}
+
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		// Drain everything claimable before waiting for the next tick
+		for ctx.Err() == nil {
+			job, err := models.DbClaimJob(ctx, r.db, types, r.cfg.Lease)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.logger.Error("Error claiming job", slog.Any("error", err))
+				}
+				break
+			} else if job == nil {
+				break
+			}
+			r.run(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run performs a claimed job, renewing its lease while the handler runs
+func (r *Runner) run(ctx context.Context, job *models.Job) {
+	logger := r.logger.With(
+		slog.String("job_id", job.ID.Hex()),
+		slog.String("job_type", job.Type),
+		slog.Int("attempt", job.Attempts),
+	)
+	ctx = logging.WithLogger(auth.WithSystem(ctx), logger)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.heartbeat(jobCtx, job)
+
+	logger.Info("Running job", slog.String("step", job.Step))
+	err := r.handlers[job.Type](jobCtx, r.db, job)
+	if ctx.Err() != nil {
+		// Shutting down, the lease expires and another worker resumes the job
+		logger.Info("Job interrupted by shutdown")
+		return
+	}
+
+	changes := bson.M{"state": models.JobDone, "error": ""}
+	if err != nil {
+		changes = bson.M{"state": models.JobPending, "error": err.Error()}
+		if job.Attempts >= r.cfg.MaxAttempts {
+			changes["state"] = models.JobFailed
+		}
+		logger.Error("Job failed", slog.Any("error", err), slog.String("state", changes["state"].(string)))
+	} else {
+		logger.Info("Job done")
+	}
+
+	if err := models.DbUpdateJob(ctx, r.db, job.ID, changes); err != nil {
+		logger.Error("Error recording job result", slog.Any("error", err))
+	}
+}
+
+// heartbeat extends the lease of job until ctx is cancelled
+func (r *Runner) heartbeat(ctx context.Context, job *models.Job) {
+	ticker := time.NewTicker(r.cfg.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := models.DbUpdateJob(ctx, r.db, job.ID, bson.M{"locked_until": time.Now().Add(r.cfg.Lease)})
+			if err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).Warn("Error renewing job lease", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// SetStep records that job has completed everything before step, so a
+// retry resumes there
+func SetStep(ctx context.Context, db *mongo.Database, job *models.Job, step string) error {
+	if err := models.DbUpdateJob(ctx, db, job.ID, bson.M{"step": step}); err != nil {
+		return fmt.Errorf("recording step %s: %w", step, err)
+	}
+	job.Step = step
+	return nil
+}
+
+// Every calls fn every interval until ctx is cancelled. Errors are logged
+// and fn is tried again at the next interval.
+func Every(ctx context.Context, logger *slog.Logger, name string, interval time.Duration, fn func(context.Context) error) {
+	logger = logger.With(slog.String("task", name))
+	ctx = logging.WithLogger(auth.WithSystem(ctx), logger)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := fn(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("Periodic task failed", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"log/slog"
+	"strings"
+
+	"gonews/auth"
+	"gonews/logging"
+	"gonews/models"
+	"gonews/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Authenticate resolves an "Authorization: Bearer <token>" header to the
+// user of the session or API key, stored with auth.WithUser in the
+// request context and under UserKey. Sessions are also stored with
+// auth.WithSession and API keys with auth.WithAPIKey. Requests without the header stay anonymous; an invalid
+// token is rejected with 401. Users whose role is listed in
+// twoFactorRoles act as models.RoleUser until they enable two-factor
+// authentication.
+func Authenticate(db *mongo.Database, twoFactorRoles []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.Next()
+			return
+		}
+
+		scheme, token, _ := strings.Cut(header, " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			c.Error(models.ErrMalformedAuth)
+			c.Abort()
+			return
+		}
+
+		ctx := c.Request.Context()
+		var user *models.User
+		var err error
+		if services.IsAPIKey(token) {
+			var key *models.APIKey
+			user, key, err = services.AuthenticateAPIKey(ctx, db, token, twoFactorRoles)
+			ctx = auth.WithAPIKey(ctx, key)
+		} else {
+			var session *models.Session
+			user, session, err = services.Authenticate(ctx, db, token, twoFactorRoles)
+			ctx = auth.WithSession(ctx, session)
+		}
+		if err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Set(UserKey, user.Username)
+		ctx = auth.WithUser(ctx, user)
+		ctx = logging.WithLogger(ctx, logging.FromContext(ctx).With(slog.String("actor", user.Username)))
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// RequireUser rejects anonymous requests with 401
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if auth.UserFromContext(c.Request.Context()) == nil {
+			c.Error(models.ErrAuthRequired)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// Require rejects requests whose user lacks permission, with 401 for
+// anonymous requests and 403 otherwise
+func Require(permission auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := auth.Require(c.Request.Context(), permission); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelf rejects requests unless the user is the one named by the
+// :username route parameter
+func RequireSelf() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := auth.RequireSelf(c.Request.Context(), c.Param("username")); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOr rejects requests unless the user is the one named by the
+// :username route parameter or has permission
+func RequireSelfOr(permission auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := auth.RequireSelfOr(c.Request.Context(), c.Param("username"), permission); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests authenticated with an API key lacking
+// scope with 403
+func RequireScope(scope auth.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := auth.RequireScope(c.Request.Context(), scope); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects anonymous requests with 401 and requests
+// authenticated with an API key with 403
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := auth.RequireSession(c.Request.Context()); err != nil {
+			c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"gonews/logging"
+	"gonews/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrorBody is the JSON body of every error response
+type ErrorBody struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id"`
+}
+
+// statusByKind maps model error kinds to HTTP status codes
+var statusByKind = []struct {
+	kind   error
+	status int
+}{
+	{models.ErrNotFound, http.StatusNotFound},
+	{models.ErrConflict, http.StatusConflict},
+	{models.ErrValidation, http.StatusBadRequest},
+	{models.ErrTimeout, http.StatusGatewayTimeout},
+	{models.ErrUnavailable, http.StatusServiceUnavailable},
+	{models.ErrRateLimited, http.StatusTooManyRequests},
+	{models.ErrUnauthorized, http.StatusUnauthorized},
+	{models.ErrForbidden, http.StatusForbidden},
+	{models.ErrTooLarge, http.StatusRequestEntityTooLarge},
+}
+
+// Errors renders the last error attached to the context with c.Error as
+// the standard error envelope, unless a response was already written.
+// Errors telling when to retry also set Retry-After.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		err := c.Errors.Last().Err
+		status, body := errorResponse(err)
+		body.RequestID = c.GetString(RequestIDKey)
+		var domainErr *models.Error
+		if errors.As(err, &domainErr) {
+			if seconds, ok := domainErr.RetryAfter(); ok {
+				c.Header("Retry-After", strconv.Itoa(seconds))
+			}
+		}
+		if errors.Is(err, context.Canceled) {
+			// The client went away, there is nobody to answer
+			logging.FromContext(c.Request.Context()).Info("Request canceled", slog.Any("error", err))
+		} else if status == http.StatusInternalServerError {
+			logging.FromContext(c.Request.Context()).Error("Request failed", slog.Any("error", err))
+		}
+
+		c.JSON(status, gin.H{
+			"status": "error",
+			"error":  body,
+		})
+	}
+}
+
+func errorResponse(err error) (int, ErrorBody) {
+	var domainErr *models.Error
+	if errors.As(err, &domainErr) {
+		for _, mapping := range statusByKind {
+			if errors.Is(domainErr, mapping.kind) {
+				return mapping.status, ErrorBody{
+					Code:    domainErr.Code,
+					Message: domainErr.Message,
+					Details: domainErr.Details,
+				}
+			}
+		}
+	}
+
+	// Never leak internal error messages to clients
+	return http.StatusInternalServerError, ErrorBody{
+		Code:    "internal_error",
+		Message: "Internal server error",
+	}
+}
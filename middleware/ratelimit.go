@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+
+	"gonews/logging"
+	"gonews/models"
+	"gonews/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserKey is the gin context key holding the authenticated username, if any
+const UserKey = "user"
+
+// RateLimit takes a token from the bucket of the route group for the
+// authenticated user, or the client IP for anonymous requests, and
+// rejects the request with 429 and Retry-After when the bucket is empty.
+// A failing store lets requests through rather than taking the API down.
+func RateLimit(group string, store ratelimit.Store, limit ratelimit.Limit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		take(c, store, ratelimit.Key(group, c.GetString(UserKey), c.ClientIP()), limit)
+	}
+}
+
+// RateLimitIP takes a token from the client IP's bucket for every
+// request. It runs before Authenticate so floods of requests with made up
+// tokens are turned away before the session lookup reaches the database.
+func RateLimitIP(store ratelimit.Store, limit ratelimit.Limit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		take(c, store, ratelimit.Key(ratelimit.GroupIP, "", c.ClientIP()), limit)
+	}
+}
+
+// take takes a token from the bucket for key and aborts the request when
+// it is empty
+func take(c *gin.Context, store ratelimit.Store, key string, limit ratelimit.Limit) {
+	if !limit.Enabled() {
+		c.Next()
+		return
+	}
+
+	allowed, retryAfter, err := store.Take(c.Request.Context(), key, limit)
+	if err != nil {
+		logging.FromContext(c.Request.Context()).Error("Rate limit store failed", slog.Any("error", err))
+		c.Next()
+		return
+	}
+	if !allowed {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.Error(models.ErrTooManyRequests)
+		c.Abort()
+		return
+	}
+
+	c.Next()
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIKey lets programs act as a user within its scopes. Only the SHA-256
+// hash of the key is stored; Prefix is kept to tell keys apart.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	Name       string             `bson:"name"`
+	Prefix     string             `bson:"prefix"`
+	KeyHash    string             `bson:"key_hash" json:"-"`
+	Scopes     []string           `bson:"scopes"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty"`
+}
+
+type APIKeys []*APIKey
+
+// HasScope reports whether the key grants scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// DbInsertAPIKey stores a new API key
+func DbInsertAPIKey(ctx context.Context, db *mongo.Database, key APIKey) (interface{}, error) {
+	collection := db.Collection("api_keys")
+	ctx, op := beginOperation(ctx, "api_keys", "insert", timeouts.Insert)
+	defer op.end()
+
+	res, err := collection.InsertOne(ctx, key)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("inserting api key: %w", err))
+	}
+	return res.InsertedID, nil
+}
+
+// DbQueryAPIKeys returns the API keys matching filter, newest first
+func DbQueryAPIKeys(ctx context.Context, db *mongo.Database, filter bson.M) (APIKeys, error) {
+	collection := db.Collection("api_keys")
+	ctx, op := beginOperation(ctx, "api_keys", "query", timeouts.Query)
+	defer op.end()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("retrieving api keys: %w", err))
+	}
+	defer cur.Close(ctx)
+
+	keys := APIKeys{}
+	if err := cur.All(ctx, &keys); err != nil {
+		return nil, op.fail(fmt.Errorf("decoding api keys: %w", err))
+	}
+	return keys, nil
+}
+
+// DbQueryAPIKey returns the unexpired API key with the given hash
+func DbQueryAPIKey(ctx context.Context, db *mongo.Database, keyHash string) (*APIKey, error) {
+	collection := db.Collection("api_keys")
+	ctx, op := beginOperation(ctx, "api_keys", "query", timeouts.Query)
+	defer op.end()
+
+	filter := bson.M{
+		"key_hash": keyHash,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$exists": false}},
+			bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+		},
+	}
+
+	var key APIKey
+	err := collection.FindOne(ctx, filter).Decode(&key)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, op.fail(err)
+	}
+	return &key, nil
+}
+
+// DbTouchAPIKey sets the last use of the key with the given ID to now,
+// unless it was already used within resolution, to spare a write per
+// request
+func DbTouchAPIKey(ctx context.Context, db *mongo.Database, id primitive.ObjectID, now time.Time, resolution time.Duration) error {
+	collection := db.Collection("api_keys")
+	ctx, op := beginOperation(ctx, "api_keys", "touch", timeouts.Update)
+	defer op.end()
+
+	filter := bson.M{
+		"_id": id,
+		"$or": bson.A{
+			bson.M{"last_used_at": bson.M{"$exists": false}},
+			bson.M{"last_used_at": bson.M{"$lt": now.Add(-resolution)}},
+		},
+	}
+	if _, err := collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"last_used_at": now}}); err != nil {
+		return op.fail(err)
+	}
+	return nil
+}
+
+// DbDeleteAPIKeys deletes every API key matching filter
+func DbDeleteAPIKeys(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("api_keys")
+	ctx, op := beginOperation(ctx, "api_keys", "delete", timeouts.Delete)
+	defer op.end()
+
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, op.fail(err)
+	}
+	return res.DeletedCount, nil
+}
+
+// DbEnsureAPIKeyIndexes makes key hashes unique and indexes keys by user
+func DbEnsureAPIKeyIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEntry records a change to a user, post or tag. Entries are only
+// ever inserted. Before and After hold only the fields that changed.
+type AuditEntry struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Actor      string             `bson:"actor"`
+	IP         string             `bson:"ip,omitempty"`
+	Method     string             `bson:"method,omitempty"`
+	Route      string             `bson:"route,omitempty"`
+	RequestID  string             `bson:"request_id,omitempty"`
+	Action     string             `bson:"action"`
+	Resource   string             `bson:"resource"`
+	ResourceID string             `bson:"resource_id"`
+	Before     bson.M             `bson:"before,omitempty"`
+	After      bson.M             `bson:"after,omitempty"`
+	Details    map[string]string  `bson:"details,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+}
+
+type AuditEntries []*AuditEntry
+
+// DbInsertAuditEntries appends entries to the audit log
+func DbInsertAuditEntries(ctx context.Context, db *mongo.Database, entries []AuditEntry) error {
+	collection := db.Collection("audit")
+	ctx, op := beginOperation(ctx, "audit", "insert", timeouts.Insert)
+	defer op.end()
+
+	docs := make([]interface{}, len(entries))
+	for i := range entries {
+		entries[i].ID = primitive.NewObjectID()
+		entries[i].CreatedAt = time.Now()
+		docs[i] = entries[i]
+	}
+
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		return op.fail(fmt.Errorf("inserting audit entries: %w", err))
+	}
+	return nil
+}
+
+// DbQueryAuditEntries returns up to limit entries matching filter, newest
+// first
+func DbQueryAuditEntries(ctx context.Context, db *mongo.Database, filter bson.M, limit int64) (AuditEntries, error) {
+	collection := db.Collection("audit")
+	ctx, op := beginOperation(ctx, "audit", "query", timeouts.Query)
+	defer op.end()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).SetLimit(limit)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("retrieving audit entries: %w", err))
+	}
+	defer cur.Close(ctx)
+
+	entries := AuditEntries{}
+	for cur.Next(ctx) {
+		var entry AuditEntry
+		if err := cur.Decode(&entry); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding audit entry: %w", err))
+		}
+		entries = append(entries, &entry)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating audit entries: %w", err))
+	}
+
+	return entries, nil
+}
+
+// DbEnsureAuditIndexes creates the indexes GET /admin/audit filters on
+func DbEnsureAuditIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("audit").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "resource", Value: 1}, {Key: "resource_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
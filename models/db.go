@@ -0,0 +1,131 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gonews/metrics"
+	"gonews/tracing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Timeouts bounds how long each kind of database operation may run. The
+// deadline of the caller's context still applies if it is shorter.
+type Timeouts struct {
+	Query  time.Duration
+	Insert time.Duration
+	Update time.Duration
+	Delete time.Duration
+}
+
+// DefaultTimeouts are used until SetTimeouts is called
+var DefaultTimeouts = Timeouts{
+	Query:  10 * time.Second,
+	Insert: 10 * time.Second,
+	Update: 10 * time.Second,
+	Delete: 10 * time.Second,
+}
+
+var timeouts = DefaultTimeouts
+
+// SetTimeouts replaces the operation timeouts. It must be called before
+// the server starts handling requests.
+func SetTimeouts(t Timeouts) {
+	timeouts = t
+}
+
+// ErrDeadlineExceeded is returned when a database operation runs out of time
+var ErrDeadlineExceeded = &Error{Kind: ErrTimeout, Code: "deadline_exceeded", Message: "The database operation timed out"}
+
+// dbError converts driver timeouts into ErrDeadlineExceeded and returns
+// every other error unchanged
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) {
+		return ErrDeadlineExceeded
+	}
+	return err
+}
+
+// operation tracks a single models call: it bounds the call with its
+// timeout and records its latency and failures
+type operation struct {
+	collection string
+	name       string
+	start      time.Time
+	cancel     context.CancelFunc
+	span       trace.Span
+}
+
+func beginOperation(ctx context.Context, collection, name string, timeout time.Duration) (context.Context, *operation) {
+	ctx, span := tracing.Tracer.Start(ctx, "models."+collection+"."+name,
+		trace.WithAttributes(
+			attribute.String("db.mongodb.collection", collection),
+			attribute.String("gonews.operation", name),
+		),
+	)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, &operation{
+		collection: collection,
+		name:       name,
+		start:      time.Now(),
+		cancel:     cancel,
+		span:       span,
+	}
+}
+
+func (op *operation) end() {
+	op.cancel()
+	op.span.End()
+	metrics.DBOperationDuration.WithLabelValues(op.collection, op.name).Observe(time.Since(op.start).Seconds())
+}
+
+// fail counts and records a database failure and converts it with dbError
+func (op *operation) fail(err error) error {
+	metrics.DBOperationErrors.WithLabelValues(op.collection, op.name).Inc()
+	op.span.RecordError(err)
+	op.span.SetStatus(codes.Error, err.Error())
+	return dbError(err)
+}
+
+// excludeDeleted returns filter restricted to documents that are not in
+// the trash, unless filter already selects on deleted_at itself
+func excludeDeleted(filter bson.M) bson.M {
+	if _, ok := filter["deleted_at"]; ok {
+		return filter
+	}
+	live := bson.M{"deleted_at": bson.M{"$exists": false}}
+	for key, value := range filter {
+		live[key] = value
+	}
+	return live
+}
+
+// AnyDeletion selects documents in and out of the trash alike when used
+// as the deleted_at filter of a query, matching a missing field or a date
+var AnyDeletion = bson.M{"$not": bson.M{"$type": "array"}}
+
+// AnyVisibility selects hidden and visible posts alike when used as the
+// hidden filter of a posts query
+var AnyVisibility = bson.M{"$in": bson.A{true, false, nil}}
+
+// excludeHidden returns filter restricted to posts not hidden by a
+// moderator, unless filter already selects on hidden itself
+func excludeHidden(filter bson.M) bson.M {
+	if _, ok := filter["hidden"]; ok {
+		return filter
+	}
+	visible := bson.M{"hidden": bson.M{"$ne": true}}
+	for key, value := range filter {
+		visible[key] = value
+	}
+	return visible
+}
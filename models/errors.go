@@ -0,0 +1,106 @@
+package models
+
+import "errors"
+
+// Error kinds. Every *Error wraps exactly one of these so callers can
+// test the kind with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrValidation   = errors.New("validation failed")
+	ErrTimeout      = errors.New("timeout")
+	ErrUnavailable  = errors.New("unavailable")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrTooLarge     = errors.New("too large")
+)
+
+// Error is a domain error with a stable machine-readable code
+type Error struct {
+	Kind    error       // one of the error kinds above
+	Code    string      // e.g. "user_not_found"
+	Message string      // human-readable description
+	Details interface{} // optional structured details, e.g. field errors
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// RetryAfter returns the seconds until a rate limited or locked out
+// request may be retried, from the retry_after detail
+func (e *Error) RetryAfter() (int, bool) {
+	details, ok := e.Details.(map[string]int)
+	if !ok {
+		return 0, false
+	}
+	seconds, ok := details["retry_after"]
+	return seconds, ok
+}
+
+// FieldError describes a single invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// NewValidationError returns a validation error with the given details
+func NewValidationError(code, message string, details interface{}) *Error {
+	return &Error{Kind: ErrValidation, Code: code, Message: message, Details: details}
+}
+
+// Domain errors returned by the data layer and services
+var (
+	ErrUserNotFound  = &Error{Kind: ErrNotFound, Code: "user_not_found", Message: "User does not exist"}
+	ErrPostNotFound  = &Error{Kind: ErrNotFound, Code: "post_not_found", Message: "Post does not exist"}
+	ErrUsernameTaken = &Error{Kind: ErrConflict, Code: "username_taken", Message: "User with the same username already exists"}
+	ErrTagExists     = &Error{Kind: ErrConflict, Code: "tag_exists", Message: "Tag already exists"}
+	ErrInvalidID     = &Error{Kind: ErrValidation, Code: "invalid_id", Message: "Invalid ID format"}
+	ErrBodyTooLarge  = &Error{Kind: ErrTooLarge, Code: "body_too_large", Message: "Request body is too large"}
+	ErrJobNotFound   = &Error{Kind: ErrNotFound, Code: "job_not_found", Message: "Job does not exist"}
+
+	ErrDeletionPending  = &Error{Kind: ErrConflict, Code: "deletion_pending", Message: "User is being deleted"}
+	ErrJobExists        = &Error{Kind: ErrConflict, Code: "job_exists", Message: "Job already exists"}
+	ErrRestoreExpired   = &Error{Kind: ErrConflict, Code: "restore_window_expired", Message: "Restore window has expired"}
+	ErrAuthorDeleted    = &Error{Kind: ErrConflict, Code: "author_deleted", Message: "Post was deleted with its author, restore the author instead"}
+	ErrTagLocked        = &Error{Kind: ErrConflict, Code: "tag_locked", Message: "Tag is locked by a moderator"}
+	ErrAlreadyReported  = &Error{Kind: ErrConflict, Code: "already_reported", Message: "You already reported this post"}
+	ErrReportsNotFound  = &Error{Kind: ErrNotFound, Code: "reports_not_found", Message: "Post has no open reports"}
+	ErrTwoFactorEnabled = &Error{Kind: ErrConflict, Code: "two_factor_enabled", Message: "Two-factor authentication is already enabled"}
+	ErrTwoFactorOff     = &Error{Kind: ErrConflict, Code: "two_factor_not_enabled", Message: "Two-factor authentication is not enabled"}
+	ErrNotLocked        = &Error{Kind: ErrConflict, Code: "account_not_locked", Message: "Account has no failed logins to clear"}
+	ErrNotEnrolling     = &Error{Kind: ErrConflict, Code: "two_factor_not_enrolling", Message: "Start two-factor enrollment first"}
+	ErrAPIKeyNotFound   = &Error{Kind: ErrNotFound, Code: "api_key_not_found", Message: "API key does not exist"}
+	ErrTooManyAPIKeys   = &Error{Kind: ErrConflict, Code: "too_many_api_keys", Message: "Delete an API key before creating another"}
+	ErrNoSuchProvider   = &Error{Kind: ErrNotFound, Code: "oidc_provider_not_found", Message: "Sign-in provider does not exist"}
+	ErrOIDCEmailInUse   = &Error{Kind: ErrConflict, Code: "oidc_email_in_use", Message: "An account already uses this email, verify its email to sign in to it with this provider"}
+
+	ErrAuthRequired       = &Error{Kind: ErrUnauthorized, Code: "authentication_required", Message: "Authentication required"}
+	ErrInvalidCredentials = &Error{Kind: ErrUnauthorized, Code: "invalid_credentials", Message: "Invalid username or password"}
+	ErrSessionNotFound    = &Error{Kind: ErrUnauthorized, Code: "invalid_token", Message: "Invalid or expired token"}
+	ErrMalformedAuth      = &Error{Kind: ErrUnauthorized, Code: "malformed_authorization", Message: "Authorization must be a bearer token"}
+	ErrPermissionDenied   = &Error{Kind: ErrForbidden, Code: "forbidden", Message: "You are not allowed to do this"}
+	ErrAccountSuspended   = &Error{Kind: ErrForbidden, Code: "account_suspended", Message: "Account is suspended"}
+	ErrAccountDeleted     = &Error{Kind: ErrForbidden, Code: "account_deleted", Message: "Account is in the trash, log in with restore set to restore it"}
+	ErrAccountLocked      = &Error{Kind: ErrForbidden, Code: "account_locked", Message: "Account is locked after too many failed logins, try again later or reset your password"}
+	ErrTooManyRequests    = &Error{Kind: ErrRateLimited, Code: "rate_limited", Message: "Too many requests"}
+	ErrLoginThrottled     = &Error{Kind: ErrRateLimited, Code: "login_throttled", Message: "Too many failed logins, try again later"}
+	ErrInvalidEmailToken  = &Error{Kind: ErrValidation, Code: "invalid_email_token", Message: "Invalid, expired or already used token"}
+	ErrEmailVerified      = &Error{Kind: ErrConflict, Code: "email_already_verified", Message: "Email is already verified"}
+	ErrMailUnavailable    = &Error{Kind: ErrUnavailable, Code: "mail_unavailable", Message: "Could not send email, try again later"}
+	ErrOIDCUnavailable    = &Error{Kind: ErrUnavailable, Code: "oidc_provider_unavailable", Message: "Could not reach the sign-in provider, try again later"}
+
+	ErrInvalidTwoFactorCode = &Error{Kind: ErrUnauthorized, Code: "invalid_two_factor_code", Message: "Invalid or already used two-factor code"}
+	ErrInvalidChallenge     = &Error{Kind: ErrUnauthorized, Code: "invalid_challenge", Message: "Invalid or expired login challenge, log in again"}
+	ErrTwoFactorRequired    = &Error{Kind: ErrForbidden, Code: "two_factor_required", Message: "Your role requires two-factor authentication"}
+	ErrInsufficientScope    = &Error{Kind: ErrForbidden, Code: "insufficient_scope", Message: "API key lacks the scope for this"}
+	ErrSessionRequired      = &Error{Kind: ErrForbidden, Code: "session_required", Message: "Log in with your password to do this, API keys may not"}
+	ErrInvalidOIDCState     = &Error{Kind: ErrUnauthorized, Code: "invalid_oidc_state", Message: "Invalid or expired sign-in, start again"}
+	ErrOIDCFailed           = &Error{Kind: ErrUnauthorized, Code: "oidc_failed", Message: "Sign-in with the provider failed"}
+	ErrOIDCEmailUnverified  = &Error{Kind: ErrForbidden, Code: "oidc_email_unverified", Message: "The provider has not verified your email"}
+)
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Job states
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a unit of background work. Handlers record their progress in
+// Step so a job interrupted by a crash resumes where it stopped once its
+// lease expires.
+type Job struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Type        string             `bson:"type"`
+	State       string             `bson:"state"`
+	Step        string             `bson:"step"`
+	Params      map[string]string  `bson:"params"`
+	Attempts    int                `bson:"attempts"`
+	Error       string             `bson:"error,omitempty"`
+	LockedUntil time.Time          `bson:"locked_until"`
+	CreatedAt   time.Time          `bson:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at"`
+}
+
+// DbInsertJob enqueues a pending job. A job given an ID is only enqueued
+// once, later inserts return ErrJobExists.
+func DbInsertJob(ctx context.Context, db *mongo.Database, job Job) (interface{}, error) {
+	collection := db.Collection("jobs")
+	ctx, op := beginOperation(ctx, "jobs", "insert", timeouts.Insert)
+	defer op.end()
+
+	if job.ID.IsZero() {
+		job.ID = primitive.NewObjectID()
+	}
+	job.State = JobPending
+	job.CreatedAt, job.UpdatedAt = time.Now(), time.Now()
+
+	res, err := collection.InsertOne(ctx, job)
+	if mongo.IsDuplicateKeyError(err) {
+		return job.ID, ErrJobExists
+	} else if err != nil {
+		return nil, op.fail(fmt.Errorf("inserting job: %w", err))
+	}
+	return res.InsertedID, nil
+}
+
+// DbQueryJob returns the job with the given ID
+func DbQueryJob(ctx context.Context, db *mongo.Database, id primitive.ObjectID) (*Job, error) {
+	collection := db.Collection("jobs")
+	ctx, op := beginOperation(ctx, "jobs", "query", timeouts.Query)
+	defer op.end()
+
+	var job Job
+	err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrJobNotFound
+	} else if err != nil {
+		return nil, op.fail(err)
+	}
+	return &job, nil
+}
+
+// DbClaimJob atomically leases the oldest pending job, or running job
+// whose lease expired, of one of the given types. It returns nil when
+// there is nothing to do.
+func DbClaimJob(ctx context.Context, db *mongo.Database, types []string, lease time.Duration) (*Job, error) {
+	collection := db.Collection("jobs")
+	ctx, op := beginOperation(ctx, "jobs", "claim", timeouts.Update)
+	defer op.end()
+
+	now := time.Now()
+	filter := bson.M{
+		"type": bson.M{"$in": types},
+		"$or": bson.A{
+			bson.M{"state": JobPending},
+			bson.M{"state": JobRunning, "locked_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"state": JobRunning, "locked_until": now.Add(lease), "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job Job
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, op.fail(err)
+	}
+	return &job, nil
+}
+
+// DbUpdateJob sets the given fields on the job with the given ID
+func DbUpdateJob(ctx context.Context, db *mongo.Database, id primitive.ObjectID, changes bson.M) error {
+	collection := db.Collection("jobs")
+	ctx, op := beginOperation(ctx, "jobs", "update", timeouts.Update)
+	defer op.end()
+
+	changes["updated_at"] = time.Now()
+	res, err := collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": changes})
+	if err != nil {
+		return op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// DbEnsureJobIndexes creates the index DbClaimJob searches
+func DbEnsureJobIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("jobs").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "state", Value: 1}, {Key: "type", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	return err
+}
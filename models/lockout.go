@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IPLockout counts the recent failed logins from a client IP, across all
+// accounts
+type IPLockout struct {
+	IP      string  `bson:"_id"`
+	Lockout Lockout `bson:"lockout"`
+	// ExpiresAt is when MongoDB may remove the document, once the last
+	// failure no longer counts
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// DbQueryIPLockout returns the lockout of the given IP, nil if it has no
+// failed logins
+func DbQueryIPLockout(ctx context.Context, db *mongo.Database, ip string) (*Lockout, error) {
+	collection := db.Collection("ip_lockouts")
+	ctx, op := beginOperation(ctx, "ip_lockouts", "query", timeouts.Query)
+	defer op.end()
+
+	var lockout IPLockout
+	err := collection.FindOne(ctx, bson.M{"_id": ip}).Decode(&lockout)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, op.fail(err)
+	}
+	return &lockout.Lockout, nil
+}
+
+// DbRecordIPLoginFailure counts a failed login from the given IP and
+// returns its updated lockout. Failures before window are forgotten, and
+// the document is removed once keep has passed.
+func DbRecordIPLoginFailure(ctx context.Context, db *mongo.Database, ip string, now time.Time, window, keep time.Duration) (*Lockout, error) {
+	collection := db.Collection("ip_lockouts")
+	ctx, op := beginOperation(ctx, "ip_lockouts", "record_login_failure", timeouts.Update)
+	defer op.end()
+
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"lockout": failedLogin("$lockout", now, window), "expires_at": now.Add(keep)}}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var lockout IPLockout
+	if err := collection.FindOneAndUpdate(ctx, bson.M{"_id": ip}, update, opts).Decode(&lockout); err != nil {
+		return nil, op.fail(err)
+	}
+	return &lockout.Lockout, nil
+}
+
+// DbEnsureIPLockoutIndexes lets MongoDB remove the lockouts of IPs
+// without recent failures
+func DbEnsureIPLockoutIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("ip_lockouts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
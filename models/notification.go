@@ -0,0 +1,118 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Notification kinds
+const (
+	NotificationWarning        = "warning"
+	NotificationReportResolved = "report_resolved"
+)
+
+// Notification is a message to a user from the moderators
+type Notification struct {
+	ID        primitive.ObjectID  `bson:"_id"`
+	UserID    primitive.ObjectID  `bson:"user_id"`
+	Kind      string              `bson:"kind"`
+	Message   string              `bson:"message"`
+	PostID    *primitive.ObjectID `bson:"post_id,omitempty"`
+	CreatedAt time.Time           `bson:"created_at"`
+	ReadAt    *time.Time          `bson:"read_at,omitempty"`
+}
+
+type Notifications []*Notification
+
+// DbInsertNotifications stores new notifications
+func DbInsertNotifications(ctx context.Context, db *mongo.Database, notifications []Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	collection := db.Collection("notifications")
+	ctx, op := beginOperation(ctx, "notifications", "insert", timeouts.Insert)
+	defer op.end()
+
+	docs := make([]interface{}, len(notifications))
+	for i := range notifications {
+		notifications[i].ID = primitive.NewObjectID()
+		notifications[i].CreatedAt = time.Now()
+		docs[i] = notifications[i]
+	}
+
+	if _, err := collection.InsertMany(ctx, docs); err != nil {
+		return op.fail(fmt.Errorf("inserting notifications: %w", err))
+	}
+	return nil
+}
+
+// DbQueryNotifications returns up to limit notifications matching filter,
+// newest first
+func DbQueryNotifications(ctx context.Context, db *mongo.Database, filter bson.M, limit int64) (Notifications, error) {
+	collection := db.Collection("notifications")
+	ctx, op := beginOperation(ctx, "notifications", "query", timeouts.Query)
+	defer op.end()
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("retrieving notifications: %w", err))
+	}
+	defer cur.Close(ctx)
+
+	notifications := Notifications{}
+	for cur.Next(ctx) {
+		var notification Notification
+		if err := cur.Decode(&notification); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding notification: %w", err))
+		}
+		notifications = append(notifications, &notification)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating notifications: %w", err))
+	}
+
+	return notifications, nil
+}
+
+// DbMarkNotificationsRead marks every unread notification matching filter
+// as read
+func DbMarkNotificationsRead(ctx context.Context, db *mongo.Database, filter bson.M, readAt time.Time) (int64, error) {
+	collection := db.Collection("notifications")
+	ctx, op := beginOperation(ctx, "notifications", "mark_read", timeouts.Update)
+	defer op.end()
+
+	filter["read_at"] = bson.M{"$exists": false}
+	res, err := collection.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"read_at": readAt}})
+	if err != nil {
+		return 0, op.fail(err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// DbDeleteNotifications deletes every notification matching filter
+func DbDeleteNotifications(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("notifications")
+	ctx, op := beginOperation(ctx, "notifications", "delete", timeouts.Delete)
+	defer op.end()
+
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, op.fail(err)
+	}
+	return res.DeletedCount, nil
+}
+
+// DbEnsureNotificationIndexes indexes notifications by user
+func DbEnsureNotificationIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("notifications").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
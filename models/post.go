@@ -3,98 +3,169 @@ package models
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
 	"time"
 
+	"gonews/metrics"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Post struct {
 	ID        primitive.ObjectID `bson:"_id"`
 	Author    string             `bson:"author"`
-	Content   string             `bson:"content"`
+	Content   string             `bson:"content" binding:"required,notblank,max=10000"`
 	Tags      []string           `bson:"tags"`
 	CreatedAt time.Time          `bson:"created_at"`
 	UpdatedAt time.Time          `bson:"updated_at"`
+
+	// DeletedAt is set while the post is in the trash. DeletedWithAuthor
+	// marks posts trashed along with their author, which are restored
+	// with the author rather than on their own.
+	DeletedAt         *time.Time `bson:"deleted_at,omitempty"`
+	DeletedWithAuthor bool       `bson:"deleted_with_author,omitempty"`
+
+	// Hidden posts were hidden by a moderator, or held for review by the
+	// content filters, and are left out like deleted ones
+	Hidden bool `bson:"hidden,omitempty"`
+
+	// ContentHash identifies repeated content, see filters.ContentHash
+	ContentHash string `bson:"content_hash,omitempty"`
 }
 
 type Posts []*Post
 
-// Given a list of postIds, returns a list of post objects
-func DbDereferencePosts(dbClient *mongo.Client, postIds []primitive.ObjectID) ([]Post, error) {
-	postCollection := dbClient.Database(os.Getenv("MONGODB_DATABASE")).Collection("posts")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// DbQueryPostPage returns up to limit posts matching filter, newest
+// first, leaving out posts like DbQueryPosts does
+func DbQueryPostPage(ctx context.Context, db *mongo.Database, filter bson.M, limit int64) (Posts, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "query_page", timeouts.Query)
+	defer op.end()
 
-	postsCursor, err := postCollection.Find(ctx, bson.M{"_id": bson.M{"$in": postIds}})
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(limit)
+	cur, err := collection.Find(ctx, excludeHidden(excludeDeleted(filter)), opts)
 	if err != nil {
-		return nil, err
+		return nil, op.fail(fmt.Errorf("retrieving posts: %w", err))
 	}
+	defer cur.Close(ctx)
 
-	var posts []Post
-	for postsCursor.Next(ctx) {
+	posts := Posts{}
+	for cur.Next(ctx) {
 		var post Post
-		if err := postsCursor.Decode(&post); err != nil {
-			return nil, err
+		if err := cur.Decode(&post); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding post: %w", err))
 		}
-		posts = append(posts, post)
+		posts = append(posts, &post)
 	}
-	if err := postsCursor.Err(); err != nil {
-		return nil, err
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating posts: %w", err))
 	}
 
 	return posts, nil
 }
 
-// Returns all posts in the database with the matching filter
-func DbQueryPosts(client *mongo.Client, filter bson.M) (Posts, error, int) {
+// Returns all posts in the database with the matching filter. Posts in
+// the trash and hidden posts are left out unless filter selects on
+// deleted_at or hidden respectively.
+func DbQueryPosts(ctx context.Context, db *mongo.Database, filter bson.M) (Posts, error, int) {
 	var posts Posts
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("posts")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "query", timeouts.Query)
+	defer op.end()
 
-	cur, err := collection.Find(ctx, filter)
+	cur, err := collection.Find(ctx, excludeHidden(excludeDeleted(filter)))
 	if err != nil {
-		log.Fatal("Error retrieving documents", err)
-		return nil, err, 0
+		return nil, op.fail(fmt.Errorf("retrieving posts: %w", err)), 0
 	}
+	defer cur.Close(ctx)
 
-	var outerror error
 	count := 0
-
 	for cur.Next(ctx) {
 		var post Post
-		count += 1
-		err = cur.Decode(&post)
-		if err != nil {
-			log.Fatal("Error decoding document", err)
-			outerror = err
+		if err := cur.Decode(&post); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding post: %w", err)), 0
 		}
 		posts = append(posts, &post)
+		count += 1
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating posts: %w", err)), 0
+	}
+
+	return posts, nil, count
+}
+
+// DbCountPosts returns the number of posts matching filter, leaving out
+// posts like DbQueryPosts does
+func DbCountPosts(ctx context.Context, db *mongo.Database, filter bson.M) (int64, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "count", timeouts.Query)
+	defer op.end()
+
+	count, err := collection.CountDocuments(ctx, excludeHidden(excludeDeleted(filter)))
+	if err != nil {
+		return 0, op.fail(err)
+	}
+	return count, nil
+}
+
+// DbCountPostTags returns how many of the posts with the given IDs have
+// each tag
+func DbCountPostTags(ctx context.Context, db *mongo.Database, postIds []primitive.ObjectID) (map[string]int64, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "count_tags", timeouts.Query)
+	defer op.end()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"_id": bson.M{"$in": postIds}}}},
+		// A post naming a tag twice is counted once, as when it was created
+		{{Key: "$project", Value: bson.M{"tags": bson.M{"$setUnion": bson.A{"$tags", bson.A{}}}}}},
+		{{Key: "$unwind", Value: "$tags"}},
+		{{Key: "$group", Value: bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}}},
+	}
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("counting post tags: %w", err))
+	}
+	defer cur.Close(ctx)
+
+	counts := map[string]int64{}
+	for cur.Next(ctx) {
+		var doc struct {
+			Tag   string `bson:"_id"`
+			Count int64  `bson:"count"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, op.fail(err)
+		}
+		counts[doc.Tag] = doc.Count
 	}
-	return posts, outerror, count
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(err)
+	}
+
+	return counts, nil
 }
 
 // Creates a post in the database with the given post data
-func DbInsertPost(client *mongo.Client, post Post) (interface{}, error) {
-	postCollection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("posts")
-	userCollection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Check if the post's author exists
-	filter := bson.M{"username": post.Author}
+func DbInsertPost(ctx context.Context, db *mongo.Database, post Post) (interface{}, error) {
+	postCollection := db.Collection("posts")
+	userCollection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "posts", "insert", timeouts.Insert)
+	defer op.end()
+
+	// Check if the post's author exists and is not deleted
+	filter := excludeDeleted(bson.M{"username": post.Author})
 	count, err := userCollection.CountDocuments(ctx, filter)
 	if err != nil {
-		return nil, err
+		return nil, op.fail(err)
 	}
 
 	// Return an error if author does not exist
 	if count == 0 {
-		return nil, fmt.Errorf("Invalid author")
+		return nil, ErrUserNotFound
 	}
 
 	// Initialize post id
@@ -104,23 +175,144 @@ func DbInsertPost(client *mongo.Client, post Post) (interface{}, error) {
 
 	res, err := postCollection.InsertOne(ctx, post)
 	if err != nil {
-		log.Fatalln("Error inserting new post", err)
+		return nil, op.fail(fmt.Errorf("inserting post: %w", err))
 	}
+	metrics.PostsCreated.Inc()
+
 	return res.InsertedID, nil
 }
 
-// DbDeletePost deletes a user from the database with the given filter
-func DbDeletePost(client *mongo.Client, filter bson.M) (interface{}, error) {
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("posts")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// DbDeletePost moves the post matching filter to the trash
+func DbDeletePost(ctx context.Context, db *mongo.Database, filter bson.M, deletedAt time.Time) (interface{}, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "delete", timeouts.Delete)
+	defer op.end()
+
+	res, err := collection.UpdateOne(ctx, excludeDeleted(filter), bson.M{"$set": bson.M{"deleted_at": deletedAt}})
+	if err != nil {
+		return nil, op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return nil, ErrPostNotFound
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// DbDeleteAuthorPosts moves every post of author that is not already in
+// the trash to the trash along with its author
+func DbDeleteAuthorPosts(ctx context.Context, db *mongo.Database, author string, deletedAt time.Time) (interface{}, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "delete_author", timeouts.Delete)
+	defer op.end()
+
+	update := bson.M{"$set": bson.M{"deleted_at": deletedAt, "deleted_with_author": true}}
+	res, err := collection.UpdateMany(ctx, excludeDeleted(bson.M{"author": author}), update)
+	if err != nil {
+		return nil, op.fail(err)
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// DbRestorePosts takes every post matching filter out of the trash
+func DbRestorePosts(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "restore", timeouts.Update)
+	defer op.end()
+
+	update := bson.M{"$unset": bson.M{"deleted_at": "", "deleted_with_author": ""}}
+	res, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, op.fail(err)
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// DbRenamePostAuthor moves every post of the author from to the author to
+func DbRenamePostAuthor(ctx context.Context, db *mongo.Database, from, to string) (interface{}, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "rename_author", timeouts.Update)
+	defer op.end()
+
+	res, err := collection.UpdateMany(ctx, bson.M{"author": from}, bson.M{"$set": bson.M{"author": to}})
+	if err != nil {
+		return nil, op.fail(err)
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// DbQueryPostIDs returns the IDs of up to limit posts matching filter,
+// including posts in the trash
+func DbQueryPostIDs(ctx context.Context, db *mongo.Database, filter bson.M, limit int64) ([]primitive.ObjectID, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "query_ids", timeouts.Query)
+	defer op.end()
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(limit)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, op.fail(err)
+	}
+	defer cur.Close(ctx)
+
+	var ids []primitive.ObjectID
+	for cur.Next(ctx) {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, op.fail(err)
+		}
+		ids = append(ids, doc.ID)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(err)
+	}
+
+	return ids, nil
+}
+
+// DbPurgePosts permanently deletes every post matching filter
+func DbPurgePosts(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "purge", timeouts.Delete)
+	defer op.end()
 
-	res, err := collection.DeleteOne(ctx, filter)
+	res, err := collection.DeleteMany(ctx, filter)
 	if err != nil {
-		return nil, err
-	} else if res.DeletedCount == 0 {
-		return nil, fmt.Errorf("Post does not exist")
+		return nil, op.fail(err)
 	}
 
 	return res.DeletedCount, nil
 }
+
+// DbSetPostHidden hides or unhides the post matching filter
+func DbSetPostHidden(ctx context.Context, db *mongo.Database, filter bson.M, hidden bool) (interface{}, error) {
+	collection := db.Collection("posts")
+	ctx, op := beginOperation(ctx, "posts", "set_hidden", timeouts.Update)
+	defer op.end()
+
+	update := bson.M{"$set": bson.M{"hidden": true}}
+	if !hidden {
+		update = bson.M{"$unset": bson.M{"hidden": ""}}
+	}
+	res, err := collection.UpdateOne(ctx, excludeDeleted(filter), update)
+	if err != nil {
+		return nil, op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return nil, ErrPostNotFound
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// DbEnsurePostIndexes indexes posts by tag, newest first, for
+// DbQueryPostPage
+func DbEnsurePostIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("posts").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "tags", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	return err
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report statuses
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Report reasons
+const (
+	ReasonSpam           = "spam"
+	ReasonHarassment     = "harassment"
+	ReasonHate           = "hate"
+	ReasonViolence       = "violence"
+	ReasonSexual         = "sexual"
+	ReasonMisinformation = "misinformation"
+	ReasonOther          = "other"
+)
+
+// ReasonAutomated marks posts flagged by the content filters. Users
+// cannot report with it.
+const ReasonAutomated = "automated"
+
+// ReportReasons lists every reason users can report with
+var ReportReasons = []string{
+	ReasonSpam, ReasonHarassment, ReasonHate, ReasonViolence, ReasonSexual, ReasonMisinformation, ReasonOther,
+}
+
+// Report flags a post for review by moderators. Each user has at most one
+// open report per post. Reports by the content filters have no
+// ReporterID.
+type Report struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	PostID     primitive.ObjectID `bson:"post_id"`
+	ReporterID primitive.ObjectID `bson:"reporter_id"`
+	Reason     string             `bson:"reason"`
+	Comment    string             `bson:"comment,omitempty"`
+	Status     string             `bson:"status"`
+	Resolution string             `bson:"resolution,omitempty"`
+	ResolvedBy string             `bson:"resolved_by,omitempty"`
+	ResolvedAt *time.Time         `bson:"resolved_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+}
+
+type Reports []*Report
+
+// ReasonCount is the number of reports of a post with one reason
+type ReasonCount struct {
+	Reason string `bson:"reason"`
+	Count  int    `bson:"count"`
+}
+
+// ReportGroup sums up the reports of one post
+type ReportGroup struct {
+	PostID          primitive.ObjectID `bson:"_id"`
+	Count           int                `bson:"count"`
+	Reasons         []ReasonCount      `bson:"reasons"`
+	FirstReportedAt time.Time          `bson:"first_reported_at"`
+	LastReportedAt  time.Time          `bson:"last_reported_at"`
+
+	// Post is the reported post, nil once it is deleted
+	Post *Post `bson:"-"`
+}
+
+// DbInsertReport stores a new report. It returns ErrAlreadyReported if
+// the reporter already has an open report of the post.
+func DbInsertReport(ctx context.Context, db *mongo.Database, report Report) (interface{}, error) {
+	collection := db.Collection("reports")
+	ctx, op := beginOperation(ctx, "reports", "insert", timeouts.Insert)
+	defer op.end()
+
+	report.ID = primitive.NewObjectID()
+
+	res, err := collection.InsertOne(ctx, report)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyReported
+	} else if err != nil {
+		return nil, op.fail(fmt.Errorf("inserting report: %w", err))
+	}
+	return res.InsertedID, nil
+}
+
+// DbCountReports returns the number of reports matching filter
+func DbCountReports(ctx context.Context, db *mongo.Database, filter bson.M) (int64, error) {
+	collection := db.Collection("reports")
+	ctx, op := beginOperation(ctx, "reports", "count", timeouts.Query)
+	defer op.end()
+
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, op.fail(err)
+	}
+	return count, nil
+}
+
+// DbQueryReports returns every report matching filter
+func DbQueryReports(ctx context.Context, db *mongo.Database, filter bson.M) (Reports, error) {
+	collection := db.Collection("reports")
+	ctx, op := beginOperation(ctx, "reports", "query", timeouts.Query)
+	defer op.end()
+
+	cur, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("retrieving reports: %w", err))
+	}
+	defer cur.Close(ctx)
+
+	reports := Reports{}
+	for cur.Next(ctx) {
+		var report Report
+		if err := cur.Decode(&report); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding report: %w", err))
+		}
+		reports = append(reports, &report)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating reports: %w", err))
+	}
+
+	return reports, nil
+}
+
+// DbQueryReportGroups groups the reports matching filter by post and
+// returns up to limit groups, most reported first
+func DbQueryReportGroups(ctx context.Context, db *mongo.Database, filter bson.M, limit int64) ([]ReportGroup, error) {
+	collection := db.Collection("reports")
+	ctx, op := beginOperation(ctx, "reports", "group", timeouts.Query)
+	defer op.end()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"post_id": "$post_id", "reason": "$reason"},
+			"count": bson.M{"$sum": 1},
+			"first": bson.M{"$min": "$created_at"},
+			"last":  bson.M{"$max": "$created_at"},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               "$_id.post_id",
+			"count":             bson.M{"$sum": "$count"},
+			"reasons":           bson.M{"$push": bson.M{"reason": "$_id.reason", "count": "$count"}},
+			"first_reported_at": bson.M{"$min": "$first"},
+			"last_reported_at":  bson.M{"$max": "$last"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "last_reported_at", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("grouping reports: %w", err))
+	}
+	defer cur.Close(ctx)
+
+	groups := []ReportGroup{}
+	if err := cur.All(ctx, &groups); err != nil {
+		return nil, op.fail(fmt.Errorf("decoding report groups: %w", err))
+	}
+	return groups, nil
+}
+
+// DbResolveReports resolves every open report matching filter
+func DbResolveReports(ctx context.Context, db *mongo.Database, filter bson.M, resolution, resolvedBy string, resolvedAt time.Time) (int64, error) {
+	collection := db.Collection("reports")
+	ctx, op := beginOperation(ctx, "reports", "resolve", timeouts.Update)
+	defer op.end()
+
+	filter["status"] = ReportOpen
+	update := bson.M{"$set": bson.M{
+		"status":      ReportResolved,
+		"resolution":  resolution,
+		"resolved_by": resolvedBy,
+		"resolved_at": resolvedAt,
+	}}
+	res, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, op.fail(err)
+	}
+	return res.ModifiedCount, nil
+}
+
+// DbEnsureReportIndexes allows one open report per user and post and
+// indexes the moderation queue
+func DbEnsureReportIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("reports").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "post_id", Value: 1}, {Key: "reporter_id", Value: 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"status": ReportOpen}),
+		},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "post_id", Value: 1}}},
+	})
+	return err
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Session is a login. Only the SHA-256 hash of its bearer token is
+// stored.
+type Session struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	TokenHash string             `bson:"token_hash"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// DbInsertSession stores a new session
+func DbInsertSession(ctx context.Context, db *mongo.Database, session Session) (interface{}, error) {
+	collection := db.Collection("sessions")
+	ctx, op := beginOperation(ctx, "sessions", "insert", timeouts.Insert)
+	defer op.end()
+
+	session.ID = primitive.NewObjectID()
+
+	res, err := collection.InsertOne(ctx, session)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("inserting session: %w", err))
+	}
+	return res.InsertedID, nil
+}
+
+// DbQuerySession returns the unexpired session with the given token hash
+func DbQuerySession(ctx context.Context, db *mongo.Database, tokenHash string) (*Session, error) {
+	collection := db.Collection("sessions")
+	ctx, op := beginOperation(ctx, "sessions", "query", timeouts.Query)
+	defer op.end()
+
+	filter := bson.M{"token_hash": tokenHash, "expires_at": bson.M{"$gt": time.Now()}}
+
+	var session Session
+	err := collection.FindOne(ctx, filter).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, op.fail(err)
+	}
+	return &session, nil
+}
+
+// DbDeleteSessions deletes every session matching filter
+func DbDeleteSessions(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("sessions")
+	ctx, op := beginOperation(ctx, "sessions", "delete", timeouts.Delete)
+	defer op.end()
+
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, op.fail(err)
+	}
+	return res.DeletedCount, nil
+}
+
+// DbEnsureSessionIndexes creates the token lookup index and the TTL index
+// that removes expired sessions
+func DbEnsureSessionIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("sessions").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SpamToken counts the spam and ham posts a token appeared in
+type SpamToken struct {
+	Token string `bson:"_id"`
+	Spam  int64  `bson:"spam"`
+	Ham   int64  `bson:"ham"`
+}
+
+// SpamTotals counts the spam and ham posts the classifier was trained on
+type SpamTotals struct {
+	Spam int64 `bson:"spam"`
+	Ham  int64 `bson:"ham"`
+}
+
+// SpamLabel records how a post was trained, so a later decision on the
+// same post replaces the earlier one instead of counting twice
+type SpamLabel struct {
+	PostID primitive.ObjectID `bson:"_id"`
+	Spam   bool               `bson:"spam"`
+	Tokens []string           `bson:"tokens"`
+}
+
+// spamTotalsID is the ID of the single SpamTotals document
+const spamTotalsID = "totals"
+
+// DbQuerySpamTokens returns the counts of the given tokens. Tokens never
+// trained on are missing from the result.
+func DbQuerySpamTokens(ctx context.Context, db *mongo.Database, tokens []string) (map[string]SpamToken, error) {
+	collection := db.Collection("spam_tokens")
+	ctx, op := beginOperation(ctx, "spam_tokens", "query", timeouts.Query)
+	defer op.end()
+
+	cur, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": tokens}})
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("retrieving spam tokens: %w", err))
+	}
+	defer cur.Close(ctx)
+
+	counts := map[string]SpamToken{}
+	for cur.Next(ctx) {
+		var token SpamToken
+		if err := cur.Decode(&token); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding spam token: %w", err))
+		}
+		counts[token.Token] = token
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating spam tokens: %w", err))
+	}
+
+	return counts, nil
+}
+
+// DbQuerySpamTotals returns the number of posts trained as spam and ham
+func DbQuerySpamTotals(ctx context.Context, db *mongo.Database) (SpamTotals, error) {
+	collection := db.Collection("spam_stats")
+	ctx, op := beginOperation(ctx, "spam_stats", "query", timeouts.Query)
+	defer op.end()
+
+	var totals SpamTotals
+	err := collection.FindOne(ctx, bson.M{"_id": spamTotalsID}).Decode(&totals)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return SpamTotals{}, op.fail(err)
+	}
+	return totals, nil
+}
+
+// DbIncSpamCounts adds the counts in tokens, which may be negative, to
+// the stored counts of each token in one bulk write, then adds totals to
+// the totals
+func DbIncSpamCounts(ctx context.Context, db *mongo.Database, tokens []SpamToken, totals SpamTotals) error {
+	ctx, op := beginOperation(ctx, "spam_tokens", "inc", timeouts.Update)
+	defer op.end()
+
+	if len(tokens) > 0 {
+		updates := make([]mongo.WriteModel, len(tokens))
+		for i, token := range tokens {
+			inc := bson.M{"$inc": bson.M{"spam": token.Spam, "ham": token.Ham}}
+			updates[i] = mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": token.Token}).SetUpdate(inc).SetUpsert(true)
+		}
+		if _, err := db.Collection("spam_tokens").BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false)); err != nil {
+			return op.fail(fmt.Errorf("updating spam tokens: %w", err))
+		}
+	}
+
+	inc := bson.M{"$inc": bson.M{"spam": totals.Spam, "ham": totals.Ham}}
+	opts := options.Update().SetUpsert(true)
+	if _, err := db.Collection("spam_stats").UpdateOne(ctx, bson.M{"_id": spamTotalsID}, inc, opts); err != nil {
+		return op.fail(fmt.Errorf("updating spam totals: %w", err))
+	}
+	return nil
+}
+
+// DbSwapSpamLabel stores how a post was trained and returns how it was
+// trained before, or nil if it never was. Concurrent swaps of one post
+// each see the label the other stored.
+func DbSwapSpamLabel(ctx context.Context, db *mongo.Database, label SpamLabel) (*SpamLabel, error) {
+	collection := db.Collection("spam_labels")
+	ctx, op := beginOperation(ctx, "spam_labels", "swap", timeouts.Update)
+	defer op.end()
+
+	var previous SpamLabel
+	opts := options.FindOneAndReplace().SetUpsert(true).SetReturnDocument(options.Before)
+	err := collection.FindOneAndReplace(ctx, bson.M{"_id": label.PostID}, label, opts).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, op.fail(fmt.Errorf("storing spam label: %w", err))
+	}
+	return &previous, nil
+}
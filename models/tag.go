@@ -3,99 +3,180 @@ package models
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
-	"time"
+
+	"gonews/metrics"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Tag struct {
-	ID    primitive.ObjectID   `bson:"_id"`
-	Name  string               `bson:"name"`
-	Posts []primitive.ObjectID `bson:"posts"`
+	ID   primitive.ObjectID `bson:"_id"`
+	Name string             `bson:"name"`
+
+	// PostCount is the number of stored posts with the tag, including
+	// posts in the trash and hidden ones. The posts themselves are found
+	// through their Tags.
+	PostCount int64 `bson:"post_count"`
+
+	// Locked tags cannot be used by new posts
+	Locked bool `bson:"locked,omitempty"`
 }
 
 type Tags []*Tag
 
 // Returns all tags in the database with the matching filter
-func DbQueryTags(client *mongo.Client, filter bson.M) (Tags, error, int) {
+func DbQueryTags(ctx context.Context, db *mongo.Database, filter bson.M) (Tags, error, int) {
 	var tags Tags
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("tags")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	collection := db.Collection("tags")
+	ctx, op := beginOperation(ctx, "tags", "query", timeouts.Query)
+	defer op.end()
 
 	cur, err := collection.Find(ctx, filter)
 	if err != nil {
-		log.Fatal("Error retrieving documents", err)
-		return nil, err, 0
+		return nil, op.fail(fmt.Errorf("retrieving tags: %w", err)), 0
 	}
+	defer cur.Close(ctx)
 
-	var outerror error
 	count := 0
-
 	for cur.Next(ctx) {
 		var tag Tag
-		count += 1
-		err = cur.Decode(&tag)
-		if err != nil {
-			log.Fatal("Error decoding document", err)
-			outerror = err
+		if err := cur.Decode(&tag); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding tag: %w", err)), 0
 		}
 		tags = append(tags, &tag)
+		count += 1
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating tags: %w", err)), 0
 	}
-	return tags, outerror, count
+
+	return tags, nil, count
 }
 
 // DbInsertTag creates a tag in the database with the given tagname
-func DbInsertTag(client *mongo.Client, tagname string) (interface{}, error) {
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("tags")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func DbInsertTag(ctx context.Context, db *mongo.Database, tagname string) (interface{}, error) {
+	collection := db.Collection("tags")
+	ctx, op := beginOperation(ctx, "tags", "insert", timeouts.Insert)
+	defer op.end()
 
 	// Check if a tag with the same name already exists
 	filter := bson.M{"name": tagname}
 	count, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
-		return nil, err
+		return nil, op.fail(err)
 	}
 
 	// Return an error if a the tag already exists
 	if count > 0 {
-		return nil, fmt.Errorf("Tag already exists")
+		return nil, ErrTagExists
 	}
 
 	// Initialize tag object
 	tag := Tag{
-		ID:    primitive.NewObjectID(),
-		Name:  tagname,
-		Posts: []primitive.ObjectID{},
+		ID:   primitive.NewObjectID(),
+		Name: tagname,
 	}
 
 	res, err := collection.InsertOne(ctx, tag)
 	if err != nil {
-		log.Fatalln("Error inserting new tag", err)
+		return nil, op.fail(fmt.Errorf("inserting tag: %w", err))
 	}
+	metrics.TagsCreated.Inc()
+
 	return res.InsertedID, nil
 }
 
-// DbCreateUser creates a user in the database with the given user data
-func DbAddPostToTag(client *mongo.Client, tagname string, postId primitive.ObjectID) error {
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("tags")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// DbIncTagPostCounts adds to the post count of each named tag the
+// number, which may be negative, it maps to
+func DbIncTagPostCounts(ctx context.Context, db *mongo.Database, counts map[string]int64) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	ctx, op := beginOperation(ctx, "tags", "inc_post_count", timeouts.Update)
+	defer op.end()
 
-	// Return tag with specified tagname
-	filter := bson.M{"name": tagname}
-	update := bson.M{"$push": bson.M{"posts": postId}}
+	updates := make([]mongo.WriteModel, 0, len(counts))
+	for tagname, count := range counts {
+		update := bson.M{"$inc": bson.M{"post_count": count}}
+		updates = append(updates, mongo.NewUpdateOneModel().SetFilter(bson.M{"name": tagname}).SetUpdate(update))
+	}
+	if _, err := db.Collection("tags").BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false)); err != nil {
+		return op.fail(fmt.Errorf("updating tag post counts: %w", err))
+	}
+	return nil
+}
 
-	// Save tag to database
-	_, err := collection.UpdateOne(ctx, filter, update)
+// DbQueryLegacyTags returns up to limit tags still listing their posts
+// in a posts array, as tags did before they had a post count
+func DbQueryLegacyTags(ctx context.Context, db *mongo.Database, limit int64) ([]string, error) {
+	collection := db.Collection("tags")
+	ctx, op := beginOperation(ctx, "tags", "query_legacy", timeouts.Query)
+	defer op.end()
+
+	opts := options.Find().SetProjection(bson.M{"name": 1}).SetLimit(limit)
+	cur, err := collection.Find(ctx, bson.M{"posts": bson.M{"$exists": true}}, opts)
 	if err != nil {
-		return err
+		return nil, op.fail(err)
 	}
+	defer cur.Close(ctx)
 
+	var names []string
+	for cur.Next(ctx) {
+		var doc struct {
+			Name string `bson:"name"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, op.fail(err)
+		}
+		names = append(names, doc.Name)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(err)
+	}
+
+	return names, nil
+}
+
+// DbMigrateTag adds the posts listed in the legacy posts array of the tag
+// with the given name to its post count and drops the array, in one
+// update. The old layout listed every stored post and new posts are
+// only counted by DbIncTagPostCounts, so the sum is exact and concurrent
+// increments are kept. Migrated tags no longer match.
+func DbMigrateTag(ctx context.Context, db *mongo.Database, tagname string) error {
+	collection := db.Collection("tags")
+	ctx, op := beginOperation(ctx, "tags", "migrate", timeouts.Update)
+	defer op.end()
+
+	filter := bson.M{"name": tagname, "posts": bson.M{"$exists": true}}
+	postCount := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$post_count", 0}}, bson.M{"$size": "$posts"}}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"post_count": postCount}}},
+		{{Key: "$unset", Value: "posts"}},
+	}
+	if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+		return op.fail(err)
+	}
 	return nil
 }
+
+// DbSetTagLocked locks or unlocks the tag with the given name, creating
+// it if it does not exist yet
+func DbSetTagLocked(ctx context.Context, db *mongo.Database, tagname string, locked bool) (interface{}, error) {
+	collection := db.Collection("tags")
+	ctx, op := beginOperation(ctx, "tags", "set_locked", timeouts.Update)
+	defer op.end()
+
+	update := bson.M{
+		"$set":         bson.M{"locked": locked},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "post_count": 0},
+	}
+	res, err := collection.UpdateOne(ctx, bson.M{"name": tagname}, update, options.Update().SetUpsert(true))
+	if err != nil {
+		return nil, op.fail(err)
+	}
+
+	return res.ModifiedCount + res.UpsertedCount, nil
+}
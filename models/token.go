@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Token purposes
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	// TokenLoginChallenge is handed out instead of a session to users
+	// with two-factor authentication, to be exchanged with a code
+	TokenLoginChallenge = "login_challenge"
+	// TokenOIDCState is the state of a sign-in at an OIDC provider
+	TokenOIDCState = "oidc_state"
+)
+
+// Token is a single-use secret sent by email or handed out by a login. Only the SHA-256 hash of
+// the secret is stored.
+type Token struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	TokenHash string             `bson:"token_hash"`
+	Purpose   string             `bson:"purpose"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	// Email is the address the token was sent to
+	Email string `bson:"email"`
+	// Data holds values of the purpose, such as the PKCE verifier of an
+	// OIDC sign-in
+	Data      map[string]string `bson:"data,omitempty"`
+	CreatedAt time.Time         `bson:"created_at"`
+	ExpiresAt time.Time         `bson:"expires_at"`
+}
+
+// DbInsertToken stores a new token
+func DbInsertToken(ctx context.Context, db *mongo.Database, token Token) (interface{}, error) {
+	collection := db.Collection("tokens")
+	ctx, op := beginOperation(ctx, "tokens", "insert", timeouts.Insert)
+	defer op.end()
+
+	token.ID = primitive.NewObjectID()
+
+	res, err := collection.InsertOne(ctx, token)
+	if err != nil {
+		return nil, op.fail(fmt.Errorf("inserting token: %w", err))
+	}
+	return res.InsertedID, nil
+}
+
+// DbConsumeToken deletes and returns the unexpired token with the given
+// hash and purpose, so it can only be used once
+func DbConsumeToken(ctx context.Context, db *mongo.Database, tokenHash, purpose string) (*Token, error) {
+	collection := db.Collection("tokens")
+	ctx, op := beginOperation(ctx, "tokens", "consume", timeouts.Delete)
+	defer op.end()
+
+	filter := bson.M{"token_hash": tokenHash, "purpose": purpose, "expires_at": bson.M{"$gt": time.Now()}}
+
+	var token Token
+	err := collection.FindOneAndDelete(ctx, filter).Decode(&token)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrInvalidEmailToken
+	} else if err != nil {
+		return nil, op.fail(err)
+	}
+	return &token, nil
+}
+
+// DbDeleteTokens deletes every token matching filter
+func DbDeleteTokens(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("tokens")
+	ctx, op := beginOperation(ctx, "tokens", "delete", timeouts.Delete)
+	defer op.end()
+
+	res, err := collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, op.fail(err)
+	}
+	return res.DeletedCount, nil
+}
+
+// DbEnsureTokenIndexes makes token hashes unique and lets MongoDB remove
+// expired tokens
+func DbEnsureTokenIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
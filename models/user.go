@@ -3,112 +3,367 @@ package models
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
 	"time"
 
+	"gonews/metrics"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type User struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	Username  string             `bson:"username"`
-	Email     string             `bson:"email"`
-	Password  string             `bson:"password"`
-	CreatedAt time.Time          `bson:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at"`
+	ID       primitive.ObjectID `bson:"_id"`
+	Username string             `bson:"username" binding:"required,min=3,max=30,username,notreserved"`
+	Email    string             `bson:"email" binding:"required,max=254,mailaddr"`
+	// Password is hashed with bcrypt, which only uses the first 72 bytes
+	Password  string    `bson:"password" binding:"required,min=8,maxbytes=72,notcommon"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+
+	// EmailVerifiedAt is set once the user proved they own Email
+	EmailVerifiedAt *time.Time `bson:"email_verified_at,omitempty"`
+
+	// Identities link the user to accounts at OIDC providers
+	Identities []Identity `bson:"identities,omitempty"`
+
+	// TwoFactor is set once the user started enrolling in two-factor
+	// authentication
+	TwoFactor *TwoFactor `bson:"two_factor,omitempty"`
+
+	// Lockout is set after a failed login and cleared by the next
+	// successful one
+	Lockout *Lockout `bson:"lockout,omitempty"`
+
+	// Role is one of the Role constants, empty for RoleUser
+	Role string `bson:"role,omitempty"`
+
+	// SuspendedUntil is set while the account is suspended, far in the
+	// future for indefinite suspensions
+	SuspendedUntil *time.Time `bson:"suspended_until,omitempty"`
+
+	// DeletedAt is set while the user is in the trash, DeletionPolicy is
+	// then applied to their posts when the trash is purged
+	DeletedAt      *time.Time `bson:"deleted_at,omitempty"`
+	DeletionPolicy string     `bson:"deletion_policy,omitempty"`
+
+	// PendingDeletion is set while a deletion job removes the user's data
+	PendingDeletion bool `bson:"pending_deletion,omitempty"`
+
+	// RenamedFrom is set while the user's posts are moved from their
+	// previous username
+	RenamedFrom string `bson:"renamed_from,omitempty"`
 }
 
 type Users []*User
 
-// Returns all users in the database with the matching filter
-func DbQueryUsers(client *mongo.Client, filter bson.M) (Users, error, int) {
+// Identity is the account of a user at an OIDC provider
+type Identity struct {
+	Provider string    `bson:"provider"`
+	Subject  string    `bson:"subject"`
+	LinkedAt time.Time `bson:"linked_at"`
+}
+
+// TwoFactor holds the TOTP secret of a user
+type TwoFactor struct {
+	Secret string `bson:"secret"`
+	// EnabledAt is set once the user confirmed the secret with a code,
+	// until then logins do not ask for one
+	EnabledAt *time.Time `bson:"enabled_at,omitempty"`
+	// LastStep is the time step of the last accepted code, codes of
+	// earlier steps are refused so none can be replayed
+	LastStep int64 `bson:"last_step"`
+	// RecoveryCodes are the SHA-256 hashes of the unused recovery codes
+	RecoveryCodes []string `bson:"recovery_codes"`
+}
+
+// Lockout counts the recent failed logins of a user or client IP
+type Lockout struct {
+	// Failures counts the failed logins since the last success, starting
+	// over once the previous failure is older than the lockout window
+	Failures     int       `bson:"failures"`
+	LastFailedAt time.Time `bson:"last_failed_at"`
+}
+
+// Roles, in increasing order of privilege
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// EffectiveRole returns the user's role, RoleUser if none is set
+func (u *User) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// TwoFactorEnabled reports whether logins ask the user for a second
+// factor
+func (u *User) TwoFactorEnabled() bool {
+	return u.TwoFactor != nil && u.TwoFactor.EnabledAt != nil
+}
+
+// Suspended reports whether the account is suspended at the given time
+func (u *User) Suspended(now time.Time) bool {
+	return u.SuspendedUntil != nil && u.SuspendedUntil.After(now)
+}
+
+// Returns all users in the database with the matching filter. Users in
+// the trash are left out unless filter selects on deleted_at.
+func DbQueryUsers(ctx context.Context, db *mongo.Database, filter bson.M) (Users, error, int) {
 	var users Users
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "query", timeouts.Query)
+	defer op.end()
 
-	cur, err := collection.Find(ctx, filter)
+	cur, err := collection.Find(ctx, excludeDeleted(filter))
 	if err != nil {
-		log.Fatal("Error retrieving documents", err)
-		return nil, err, 0
+		return nil, op.fail(fmt.Errorf("retrieving users: %w", err)), 0
 	}
+	defer cur.Close(ctx)
 
-	var outerror error
 	count := 0
-
 	for cur.Next(ctx) {
 		var user User
-		count += 1
-		err = cur.Decode(&user)
-		if err != nil {
-			log.Fatal("Error decoding document", err)
-			outerror = err
+		if err := cur.Decode(&user); err != nil {
+			return nil, op.fail(fmt.Errorf("decoding user: %w", err)), 0
 		}
 		users = append(users, &user)
+		count += 1
+	}
+	if err := cur.Err(); err != nil {
+		return nil, op.fail(fmt.Errorf("iterating users: %w", err)), 0
 	}
-	return users, outerror, count
+
+	return users, nil, count
 }
 
 // DbCreateUser creates a user in the database with the given user data
-func DbInsertUser(client *mongo.Client, user User) (interface{}, error) {
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Check if a user with the same username already exists
-	filter := bson.M{"username": user.Username}
-	count, err := collection.CountDocuments(ctx, filter)
+func DbInsertUser(ctx context.Context, db *mongo.Database, user User) (interface{}, error) {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "insert", timeouts.Insert)
+	defer op.end()
+
+	// Check if a user with the same username already exists, users in the
+	// trash keep their username so they can be restored
+	count, err := collection.CountDocuments(ctx, UsernameInUse(user.Username))
 	if err != nil {
-		return nil, err
+		return nil, op.fail(err)
 	}
 
 	// Return an error if a user with the same username already exists
 	if count > 0 {
-		return nil, fmt.Errorf("User with the same username already exists")
+		return nil, ErrUsernameTaken
 	}
 
 	// Initialize user id
 	user.ID = primitive.NewObjectID()
 
 	res, err := collection.InsertOne(ctx, user)
-	if err != nil {
-		log.Fatalln("Error inserting new user", err)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrUsernameTaken
+	} else if err != nil {
+		return nil, op.fail(fmt.Errorf("inserting user: %w", err))
 	}
+	metrics.UsersCreated.Inc()
+
 	return res.InsertedID, nil
 }
 
-// DbUpdateUser updates a user from the database with the given filter and new user data
-func DbUpdateUser(client *mongo.Client, filter bson.M, newUser User) (interface{}, error) {
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// UsernameInUse matches the user holding username, or the user renamed
+// from it whose posts are not moved yet. Those posts would otherwise be
+// moved to them as well.
+func UsernameInUse(username string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"username": username},
+		bson.M{"renamed_from": username},
+	}}
+}
+
+// DbUpdateUser sets the given fields on the user matching filter. It
+// returns the number of modified documents, which is 0 if the fields
+// already had these values, and ErrUserNotFound if nothing matched.
+func DbUpdateUser(ctx context.Context, db *mongo.Database, filter bson.M, changes bson.M) (interface{}, error) {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "update", timeouts.Update)
+	defer op.end()
+
+	update := bson.M{"$set": changes}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrUsernameTaken
+	} else if err != nil {
+		return nil, op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	return res.ModifiedCount, nil
+}
+
+// DbDeleteUser moves the user matching filter to the trash, to be
+// purged with the given deletion policy
+func DbDeleteUser(ctx context.Context, db *mongo.Database, filter bson.M, deletedAt time.Time, policy string) (interface{}, error) {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "delete", timeouts.Delete)
+	defer op.end()
+
+	update := bson.M{"$set": bson.M{"deleted_at": deletedAt, "deletion_policy": policy}}
+	res, err := collection.UpdateOne(ctx, excludeDeleted(filter), update)
+	if err != nil {
+		return nil, op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return nil, ErrUserNotFound
+	}
 
-	update := bson.M{"$set": newUser}
+	return res.ModifiedCount, nil
+}
+
+// DbRestoreUser takes the user matching filter out of the trash
+func DbRestoreUser(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "restore", timeouts.Update)
+	defer op.end()
+
+	update := bson.M{"$unset": bson.M{"deleted_at": "", "deletion_policy": ""}}
 	res, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return nil, err
-	} else if res.ModifiedCount == 0 {
-		return nil, fmt.Errorf("User does not exist")
+		return nil, op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return nil, ErrUserNotFound
 	}
 
 	return res.ModifiedCount, nil
 }
 
-// DbDeleteUser deletes a user from the database with the given filter
-func DbDeleteUser(client *mongo.Client, filter bson.M) (interface{}, error) {
-	collection := client.Database(os.Getenv("MONGODB_DATABASE")).Collection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// DbFinishUserRename clears the previous username of the user with the
+// given ID once their posts were moved from it. It does nothing if the
+// user was renamed from another username since.
+func DbFinishUserRename(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, from string) error {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "finish_rename", timeouts.Update)
+	defer op.end()
+
+	filter := bson.M{"_id": userID, "renamed_from": from}
+	if _, err := collection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{"renamed_from": ""}}); err != nil {
+		return op.fail(err)
+	}
+	return nil
+}
+
+// DbPurgeUser permanently deletes the user matching filter
+func DbPurgeUser(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "purge", timeouts.Delete)
+	defer op.end()
 
 	res, err := collection.DeleteOne(ctx, filter)
 	if err != nil {
-		return nil, err
+		return nil, op.fail(err)
 	} else if res.DeletedCount == 0 {
-		return nil, fmt.Errorf("User does not exist")
+		return nil, ErrUserNotFound
 	}
 
 	return res.DeletedCount, nil
 }
+
+// DbUseTwoFactorStep records step as the last accepted TOTP step of the
+// user with the given ID. It returns ErrInvalidTwoFactorCode if a code of
+// this or a later step was already accepted.
+func DbUseTwoFactorStep(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, step int64) error {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "use_two_factor_step", timeouts.Update)
+	defer op.end()
+
+	filter := bson.M{"_id": userID, "two_factor.last_step": bson.M{"$lt": step}}
+	update := bson.M{"$set": bson.M{"two_factor.last_step": step}}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// DbUseRecoveryCode removes the recovery code with the given hash from
+// the user with the given ID. It returns ErrInvalidTwoFactorCode if the
+// user has no such code.
+func DbUseRecoveryCode(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, codeHash string) error {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "use_recovery_code", timeouts.Update)
+	defer op.end()
+
+	filter := bson.M{"_id": userID, "two_factor.recovery_codes": codeHash}
+	update := bson.M{"$pull": bson.M{"two_factor.recovery_codes": codeHash}}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// DbUnsetTwoFactor removes the two-factor secret and recovery codes of
+// the user matching filter
+func DbUnsetTwoFactor(ctx context.Context, db *mongo.Database, filter bson.M) (interface{}, error) {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "unset_two_factor", timeouts.Update)
+	defer op.end()
+
+	update := bson.M{"$unset": bson.M{"two_factor": ""}}
+	res, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, op.fail(err)
+	} else if res.MatchedCount == 0 {
+		return nil, ErrUserNotFound
+	}
+	return res.ModifiedCount, nil
+}
+
+// DbRecordUserLoginFailure counts a failed login of the user with the
+// given ID and returns their updated lockout. Failures before window are
+// forgotten.
+func DbRecordUserLoginFailure(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, now time.Time, window time.Duration) (*Lockout, error) {
+	collection := db.Collection("users")
+	ctx, op := beginOperation(ctx, "users", "record_login_failure", timeouts.Update)
+	defer op.end()
+
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{"lockout": failedLogin("$lockout", now, window)}}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var user User
+	err := collection.FindOneAndUpdate(ctx, bson.M{"_id": userID}, update, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, op.fail(err)
+	}
+	return user.Lockout, nil
+}
+
+// failedLogin returns the pipeline expression of the Lockout at path
+// after another failure
+func failedLogin(path string, now time.Time, window time.Duration) bson.M {
+	recent := bson.M{"$gte": bson.A{path + ".last_failed_at", now.Add(-window)}}
+	return bson.M{
+		"failures":       bson.M{"$cond": bson.A{recent, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{path + ".failures", 0}}, 1}}, 1}},
+		"last_failed_at": now,
+	}
+}
+
+// DbEnsureUserIndexes keeps usernames unique, including in the trash, and
+// indexes users by their OIDC identities and pending renames
+func DbEnsureUserIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "username", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}}},
+		{Keys: bson.D{{Key: "renamed_from", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+}
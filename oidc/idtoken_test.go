@@ -0,0 +1,175 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa")
+	ecKey := newECKey(t, "ec")
+	m := newMockProvider(t, rsaKey, ecKey)
+	p := m.provider()
+
+	with := func(changes map[string]interface{}) map[string]interface{} {
+		claims := m.validClaims("nonce")
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+	tamper := func(token string) string {
+		parts := strings.Split(token, ".")
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		signature[0] ^= 0xff
+		return parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	unsigned := func(alg string, claims map[string]interface{}, secret []byte) string {
+		header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "rsa"})
+		payload, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		if secret == nil {
+			return signed + "."
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	}
+	// An HS256 token keyed with the public key, which anyone can make
+	publicKey, _ := json.Marshal(publicJWK(rsaKey))
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"RS256", sign(t, rsaKey, with(nil)), ""},
+		{"ES256", sign(t, ecKey, with(nil)), ""},
+		{"email_verified as a string", sign(t, rsaKey, with(map[string]interface{}{"email_verified": "true"})), ""},
+		{"tampered signature", tamper(sign(t, rsaKey, with(nil))), "invalid id token signature"},
+		{"tampered ES256 signature", tamper(sign(t, ecKey, with(nil))), "invalid id token signature"},
+		{"tampered claims", strings.Replace(sign(t, rsaKey, with(nil)), ".", ".e30", 1), "invalid id token signature"},
+		{"alg none", unsigned("none", with(nil), nil), "unsupported id token algorithm"},
+		{"alg HS256", unsigned("HS256", with(nil), publicKey), "does not match its key"},
+		{"RS256 claimed for an EC key", sign(t, testKey{kid: "ec", alg: "RS256", key: rsaKey.key}, with(nil)), "does not match its key"},
+		{"wrong issuer", sign(t, rsaKey, with(map[string]interface{}{"iss": "https://evil.example.com"})), "issued by"},
+		{"wrong audience", sign(t, rsaKey, with(map[string]interface{}{"aud": "other"})), "not for this client"},
+		{"several audiences without azp", sign(t, rsaKey, with(map[string]interface{}{"aud": []string{"client", "other"}})), "authorized for another client"},
+		{"several audiences, azp of another client", sign(t, rsaKey, with(map[string]interface{}{"aud": []string{"client", "other"}, "azp": "other"})), "authorized for another client"},
+		{"several audiences with azp", sign(t, rsaKey, with(map[string]interface{}{"aud": []string{"client", "other"}, "azp": "client"})), ""},
+		{"expired", sign(t, rsaKey, with(map[string]interface{}{"exp": time.Now().Add(-2 * clockSkew).Unix()})), "expired"},
+		{"expired within the clock skew", sign(t, rsaKey, with(map[string]interface{}{"exp": time.Now().Add(-clockSkew / 2).Unix()})), ""},
+		{"nonce mismatch", sign(t, rsaKey, with(map[string]interface{}{"nonce": "other"})), "nonce does not match"},
+		{"no nonce", sign(t, rsaKey, with(map[string]interface{}{"nonce": nil})), "nonce does not match"},
+		{"no subject", sign(t, rsaKey, with(map[string]interface{}{"sub": nil})), "no subject"},
+		{"not a JWS", "abc.def", "not a JWS"},
+	}
+	for _, tt := range tests {
+		claims, err := p.Verify(context.Background(), tt.token, "nonce")
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err == "" && (claims.Subject != "user-1" || !bool(claims.EmailVerified)):
+			t.Errorf("%s: claims %+v", tt.name, claims)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: %v, want an error containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestVerifyRefetchesKeysForUnknownKeyID(t *testing.T) {
+	oldKey := newRSAKey(t, "old")
+	m := newMockProvider(t, oldKey)
+	p := m.provider()
+	ctx := context.Background()
+
+	if _, err := p.Verify(ctx, sign(t, oldKey, m.validClaims("nonce")), "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if m.fetches() != 1 {
+		t.Fatalf("%d key fetches, want 1", m.fetches())
+	}
+
+	// The provider rotates its keys
+	newKey := newECKey(t, "new")
+	m.addKey(newKey)
+	token := sign(t, newKey, m.validClaims("nonce"))
+
+	// Unknown key IDs are not looked up again right after a fetch, so
+	// made up ones cannot hammer the provider
+	if _, err := p.Verify(ctx, token, "nonce"); err == nil || !strings.Contains(err.Error(), "unknown id token key") {
+		t.Errorf("new key right after a fetch: %v, want unknown key", err)
+	}
+	if m.fetches() != 1 {
+		t.Errorf("%d key fetches right after a fetch, want 1", m.fetches())
+	}
+
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-keyRefreshInterval)
+	p.mu.Unlock()
+	if _, err := p.Verify(ctx, token, "nonce"); err != nil {
+		t.Errorf("new key after the refresh interval: %v", err)
+	}
+	if m.fetches() != 2 {
+		t.Errorf("%d key fetches, want 2", m.fetches())
+	}
+	// Known keys are served from the cache
+	if _, err := p.Verify(ctx, sign(t, oldKey, m.validClaims("nonce")), "nonce"); err != nil {
+		t.Errorf("old key: %v", err)
+	}
+	if m.fetches() != 2 {
+		t.Errorf("%d key fetches after a known key, want 2", m.fetches())
+	}
+
+	// Keys still unknown after fetching are refused
+	p.mu.Lock()
+	p.keysFetchedAt = time.Now().Add(-keyRefreshInterval)
+	p.mu.Unlock()
+	if _, err := p.Verify(ctx, sign(t, newRSAKey(t, "unpublished"), m.validClaims("nonce")), "nonce"); err == nil {
+		t.Error("unpublished key accepted")
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	rsaKey, ecKey := newRSAKey(t, "rsa"), newECKey(t, "ec")
+	jwkOf := func(k testKey) jwk {
+		fields := publicJWK(k)
+		return jwk{Kty: fields["kty"], Kid: fields["kid"], N: fields["n"], E: fields["e"], Crv: fields["crv"], X: fields["x"], Y: fields["y"]}
+	}
+
+	for _, k := range []testKey{rsaKey, ecKey} {
+		key, err := jwkOf(k).publicKey()
+		if err != nil {
+			t.Errorf("%s: %v", k.kid, err)
+			continue
+		}
+		if !k.key.Public().(interface{ Equal(x crypto.PublicKey) bool }).Equal(key) {
+			t.Errorf("%s: decoded key differs", k.kid)
+		}
+	}
+
+	badCurve := jwkOf(ecKey)
+	badCurve.Crv = "secp256k1"
+	badEncoding := jwkOf(rsaKey)
+	badEncoding.N = "not base64!"
+	for name, k := range map[string]jwk{
+		"unsupported type":  {Kty: "oct"},
+		"unsupported curve": badCurve,
+		"bad encoding":      badEncoding,
+	} {
+		if _, err := k.publicKey(); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
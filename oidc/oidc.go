@@ -0,0 +1,198 @@
+// Package oidc signs users in with OpenID Connect providers, using the
+// authorization code flow with PKCE
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gonews/config"
+)
+
+// keyRefreshInterval bounds how often the signing keys of a provider are
+// fetched again for an unknown key ID
+const keyRefreshInterval = time.Minute
+
+// Provider is an OpenID Connect provider. Its discovery document and
+// signing keys are fetched on first use and cached.
+type Provider struct {
+	Name        string
+	cfg         config.OIDCProvider
+	redirectURL string
+	client      *http.Client
+
+	mu            sync.Mutex
+	discovery     *discovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// discovery holds the fields of /.well-known/openid-configuration used
+// here
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Providers are the configured providers by name
+type Providers map[string]*Provider
+
+// FromConfig returns the providers of cfg. Each redirects back to
+// <public_url>/auth/oidc/<name>/callback.
+func FromConfig(cfg config.OIDCConfig) Providers {
+	providers := Providers{}
+	for name, provider := range cfg.Providers {
+		providers[name] = &Provider{
+			Name:        name,
+			cfg:         provider,
+			redirectURL: strings.TrimSuffix(cfg.PublicURL, "/") + "/auth/oidc/" + name + "/callback",
+			client:      &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return providers
+}
+
+// Names returns the names of the providers in order
+func (p Providers) Names() []string {
+	names := make([]string, 0, len(p))
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Challenge returns the S256 PKCE code challenge of verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL to send the user to for signing in. The
+// provider hands state back to the callback, and puts nonce in the ID
+// token.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.redirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid", "email", "profile"}, p.cfg.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", Challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades the code handed to the callback for the ID token of the
+// user
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("building token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var res struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.do(req, &res)
+	if err != nil {
+		return "", fmt.Errorf("exchanging code: %w", err)
+	} else if res.Error != "" {
+		return "", fmt.Errorf("exchanging code: %s: %s", res.Error, res.ErrorDescription)
+	} else if status != http.StatusOK {
+		return "", fmt.Errorf("exchanging code: status %d", status)
+	} else if res.IDToken == "" {
+		return "", fmt.Errorf("exchanging code: no id_token in response")
+	}
+	return res.IDToken, nil
+}
+
+// getDiscovery returns the discovery document of the provider
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(p.cfg.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("building discovery request: %w", err)
+	}
+	var d discovery
+	if status, err := p.do(req, &d); err != nil {
+		return nil, fmt.Errorf("fetching discovery document: %w", err)
+	} else if status != http.StatusOK {
+		return nil, fmt.Errorf("fetching discovery document: status %d", status)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", d.Issuer, p.cfg.Issuer)
+	} else if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document lacks endpoints")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// do sends req and decodes the JSON response into v, returning the
+// status code
+func (p *Provider) do(req *http.Request, v interface{}) (int, error) {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return res.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && res.StatusCode == http.StatusOK {
+		return res.StatusCode, fmt.Errorf("decoding response: %w", err)
+	}
+	return res.StatusCode, nil
+}
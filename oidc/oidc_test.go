@@ -0,0 +1,210 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gonews/config"
+)
+
+// testKey is a signing key of the mock provider
+type testKey struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+// mockProvider serves the discovery document, signing keys and token
+// endpoint of an OpenID Connect provider
+type mockProvider struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	keys       []testKey
+	keyFetches int
+	// challenge is the PKCE challenge the code was issued for, and
+	// idToken what the token endpoint hands out for it
+	challenge string
+	idToken   string
+}
+
+func newMockProvider(t *testing.T, keys ...testKey) *mockProvider {
+	m := &mockProvider{keys: keys}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.URL,
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.keyFetches++
+		var set []map[string]string
+		for _, k := range m.keys {
+			set = append(set, publicJWK(k))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": set})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("code") != "code" ||
+			Challenge(r.PostFormValue("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "bad code or verifier"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// provider returns a Provider for the mock with the client ID "client"
+func (m *mockProvider) provider() *Provider {
+	providers := FromConfig(config.OIDCConfig{
+		PublicURL: "https://news.example.com",
+		Providers: map[string]config.OIDCProvider{"mock": {Issuer: m.URL, ClientID: "client"}},
+	})
+	return providers["mock"]
+}
+
+func (m *mockProvider) addKey(k testKey) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, k)
+}
+
+func (m *mockProvider) fetches() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.keyFetches
+}
+
+func newRSAKey(t *testing.T, kid string) testKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, alg: "RS256", key: key}
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, alg: "ES256", key: key}
+}
+
+func publicJWK(k testKey) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch key := k.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": k.kid, "use": "sig", "n": enc(key.N.Bytes()), "e": enc(big.NewInt(int64(key.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": k.kid, "crv": "P-256", "x": enc(key.X.FillBytes(make([]byte, 32))), "y": enc(key.Y.FillBytes(make([]byte, 32)))}
+	}
+	return nil
+}
+
+// sign returns an ID token with claims signed by k
+func sign(t *testing.T, k testKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": k.alg, "kid": k.kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// validClaims returns the claims of a token the mock issued for the
+// client with the given nonce
+func (m *mockProvider) validClaims(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":            m.URL,
+		"sub":            "user-1",
+		"aud":            "client",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          nonce,
+		"email":          "jane@example.com",
+		"email_verified": true,
+	}
+}
+
+func TestExchangeSendsPKCEVerifier(t *testing.T) {
+	key := newRSAKey(t, "rsa")
+	m := newMockProvider(t, key)
+	p := m.provider()
+	ctx := context.Background()
+
+	authURL, err := p.AuthCodeURL(ctx, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") != Challenge("verifier") {
+		t.Fatalf("authorization URL %s lacks the S256 challenge of the verifier", authURL)
+	}
+	if query.Get("redirect_uri") != "https://news.example.com/auth/oidc/mock/callback" {
+		t.Errorf("redirect_uri %q", query.Get("redirect_uri"))
+	}
+
+	m.mu.Lock()
+	m.challenge = query.Get("code_challenge")
+	m.idToken = sign(t, key, m.validClaims("nonce"))
+	m.mu.Unlock()
+
+	idToken, err := p.Exchange(ctx, "code", "verifier")
+	if err != nil {
+		t.Fatalf("exchange with the verifier: %v", err)
+	}
+	if _, err := p.Verify(ctx, idToken, "nonce"); err != nil {
+		t.Errorf("verifying the exchanged token: %v", err)
+	}
+
+	if _, err := p.Exchange(ctx, "code", "another verifier"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("exchange with another verifier: %v, want invalid_grant", err)
+	}
+}
@@ -0,0 +1,138 @@
+package openapi
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Schema is the subset of the OpenAPI schema object used by GoNews
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+
+	// pattern is Pattern compiled once, when the schema is built
+	pattern *regexp.Regexp
+}
+
+// objectIDPattern matches the hex form of an ObjectID
+var objectIDPattern = regexp.MustCompile("^[0-9a-fA-F]{24}$")
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	fieldsType   = reflect.TypeOf(Fields{})
+)
+
+// schemaOf returns the schema for t, registering named structs as
+// components. sample is only inspected for Fields values.
+func (doc *Document) schemaOf(t reflect.Type, sample interface{}) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case objectIDType:
+		return &Schema{Type: "string", Pattern: objectIDPattern.String(), pattern: objectIDPattern}
+	case fieldsType:
+		return doc.fieldsSchema(sample.(Fields))
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := doc.schemaOf(t.Elem(), nil)
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: doc.schemaOf(t.Elem(), nil), Nullable: t.Kind() == reflect.Slice}
+	case reflect.Map:
+		return &Schema{Type: "object"}
+	case reflect.Struct:
+		return doc.structSchema(t)
+	}
+
+	// interface{} and anything else accepts any value
+	return &Schema{}
+}
+
+func (doc *Document) fieldsSchema(fields Fields) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for name, value := range fields {
+		schema.Properties[name] = doc.schemaOf(reflect.TypeOf(value), value)
+	}
+	return schema
+}
+
+func (doc *Document) structSchema(t reflect.Type) *Schema {
+	name := t.Name()
+	if name != "" {
+		if _, ok := doc.Components.Schemas[name]; ok {
+			return &Schema{Ref: "#/components/schemas/" + name}
+		}
+		// Reserve the name before recursing so self references terminate
+		doc.Components.Schemas[name] = nil
+	}
+
+	closed := false
+	schema := &Schema{
+		Type:                 "object",
+		Properties:           map[string]*Schema{},
+		AdditionalProperties: &closed,
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		jsonName, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+		schema.Properties[jsonName] = doc.schemaOf(field.Type, nil)
+		if strings.Contains(field.Tag.Get("binding"), "required") {
+			schema.Required = append(schema.Required, jsonName)
+		}
+	}
+
+	if name == "" {
+		return schema
+	}
+	doc.Components.Schemas[name] = schema
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// jsonFieldName mirrors encoding/json's naming rules
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name, true
+}
@@ -0,0 +1,213 @@
+package openapi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gonews/middleware"
+)
+
+// Document is an OpenAPI 3 document
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	routes     map[string]*Operation // keyed by method and gin path
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem maps a lowercase HTTP method to its operation
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Fields describes an ad-hoc JSON object, such as a gin.H response, by
+// mapping each key to a sample value of the Go type stored under it
+type Fields map[string]interface{}
+
+// Route documents a single gin route
+type Route struct {
+	Method   string
+	Path     string // gin path, e.g. /users/:username
+	Summary  string
+	Query    []string    // optional query parameters
+	Request  interface{} // sample request body, nil if the route takes none
+	Response interface{} // sample success response body
+	Status   int         // success status, defaulting to 200
+
+	// ResponseType is the media type of the success response, defaulting
+	// to application/json. Other types are documented as plain strings.
+	ResponseType string
+}
+
+// Build generates a document from the given routes, deriving every
+// schema from the Go types of the sample request and response values
+func Build(info Info, routes []Route) *Document {
+	doc := &Document{
+		OpenAPI:    "3.0.3",
+		Info:       info,
+		Paths:      map[string]PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}},
+		routes:     map[string]*Operation{},
+	}
+
+	for _, route := range routes {
+		op := &Operation{
+			Summary:   route.Summary,
+			Responses: map[string]*Response{},
+		}
+
+		path, params := convertPath(route.Path)
+		for _, name := range params {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:     name,
+				In:       "path",
+				Required: true,
+				Schema:   &Schema{Type: "string"},
+			})
+		}
+		for _, name := range route.Query {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:   name,
+				In:     "query",
+				Schema: &Schema{Type: "string"},
+			})
+		}
+
+		if route.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  jsonContent(doc.schemaOf(reflect.TypeOf(route.Request), route.Request)),
+			}
+		}
+
+		status := "200"
+		if route.Status != 0 {
+			status = strconv.Itoa(route.Status)
+		}
+		op.Responses[status] = &Response{
+			Description: "Success",
+			Content:     jsonContent(doc.schemaOf(reflect.TypeOf(route.Response), route.Response)),
+		}
+		if route.ResponseType != "" && route.ResponseType != "application/json" {
+			op.Responses[status].Content = map[string]MediaType{
+				route.ResponseType: {Schema: &Schema{Type: "string"}},
+			}
+		}
+		op.Responses["default"] = &Response{
+			Description: "Error",
+			Content:     jsonContent(doc.schemaOf(reflect.TypeOf(ErrorResponse{}), nil)),
+		}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = PathItem{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = op
+		doc.routes[routeKey(route.Method, route.Path)] = op
+	}
+
+	return doc
+}
+
+// Operation returns the operation documented for a gin method and path
+func (doc *Document) Operation(method, ginPath string) *Operation {
+	return doc.routes[routeKey(method, ginPath)]
+}
+
+// RouteInfo is the subset of gin.RouteInfo needed to check a document
+type RouteInfo struct {
+	Method string
+	Path   string
+}
+
+// Check reports routes that are registered but not documented, and
+// routes that are documented but not registered
+func (doc *Document) Check(registered []RouteInfo) error {
+	seen := map[string]bool{}
+	var problems []string
+
+	for _, route := range registered {
+		key := routeKey(route.Method, route.Path)
+		seen[key] = true
+		if doc.routes[key] == nil {
+			problems = append(problems, "undocumented route "+key)
+		}
+	}
+	for key := range doc.routes {
+		if !seen[key] {
+			problems = append(problems, "documented route is not registered "+key)
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ErrorResponse is the shape of every error response
+type ErrorResponse struct {
+	Status string               `json:"status"`
+	Error  middleware.ErrorBody `json:"error"`
+}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
+
+func routeKey(method, ginPath string) string {
+	return strings.ToUpper(method) + " " + ginPath
+}
+
+// convertPath turns /users/:username into /users/{username}
+func convertPath(ginPath string) (string, []string) {
+	var params []string
+	segments := strings.Split(ginPath, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			name := segment[1:]
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
@@ -0,0 +1,183 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gonews/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ValidateRequests returns a middleware that rejects request bodies not
+// matching the schema documented for the matched route, or longer than
+// maxBytes for any route. The field errors are attached as the details of
+// a models validation error.
+func ValidateRequests(doc *Document, maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Handlers reading bodies themselves are bounded too
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+
+		op := doc.Operation(c.Request.Method, c.FullPath())
+		if op == nil || op.RequestBody == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Error(models.ErrBodyTooLarge)
+			c.Abort()
+			return
+		} else if err != nil {
+			c.Error(models.NewValidationError("invalid_body", err.Error(), nil))
+			c.Abort()
+			return
+		}
+		// Restore the body for the handler
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			c.Error(models.NewValidationError("invalid_body", "Invalid JSON body: "+err.Error(), nil))
+			c.Abort()
+			return
+		}
+
+		schema := op.RequestBody.Content["application/json"].Schema
+		if errs := doc.validate(schema, value, ""); len(errs) > 0 {
+			c.Error(models.NewValidationError("schema_mismatch", "Request body does not match schema", errs))
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (doc *Document) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+func (doc *Document) validate(schema *Schema, value interface{}, path string) []models.FieldError {
+	schema = doc.resolve(schema)
+	if schema == nil || schema.Type == "" {
+		return nil
+	}
+
+	fail := func(format string, args ...interface{}) []models.FieldError {
+		field := path
+		if field == "" {
+			field = "(body)"
+		}
+		return []models.FieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return fail("must not be null")
+	}
+
+	switch schema.Type {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fail("expected string")
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fail("expected RFC 3339 date-time")
+			}
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(s) {
+			return fail("must match pattern %s", schema.Pattern)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fail("expected boolean")
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return fail("expected integer")
+		}
+		if _, err := n.Int64(); err != nil {
+			return fail("expected integer")
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return fail("expected number")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fail("expected array")
+		}
+		var errs []models.FieldError
+		for i, item := range items {
+			errs = append(errs, doc.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return errs
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fail("expected object")
+		}
+		var errs []models.FieldError
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				errs = append(errs, models.FieldError{Field: joinPath(path, name), Message: "is required"})
+			}
+		}
+		for name, fieldValue := range object {
+			fieldSchema, ok := schema.property(name)
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					errs = append(errs, models.FieldError{Field: joinPath(path, name), Message: "is not a known field"})
+				}
+				continue
+			}
+			errs = append(errs, doc.validate(fieldSchema, fieldValue, joinPath(path, name))...)
+		}
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return errs
+	}
+
+	return nil
+}
+
+// property looks up a property the way encoding/json matches keys to
+// struct fields: exactly first, then case-insensitively
+func (schema *Schema) property(name string) (*Schema, bool) {
+	if property, ok := schema.Properties[name]; ok {
+		return property, true
+	}
+	for key, property := range schema.Properties {
+		if strings.EqualFold(key, name) {
+			return property, true
+		}
+	}
+	return nil, false
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"gonews/config"
+)
+
+// Route groups, each limited by its own bucket per client
+const (
+	GroupRead   = "read"
+	GroupSignup = "signup"
+	GroupPost   = "post"
+	GroupWrite  = "write"
+
+	// GroupIP limits every request of a client IP before authentication
+	GroupIP = "ip"
+)
+
+// Limit is a token bucket holding up to Burst tokens and refilling at
+// Rate tokens per second. Every request takes one token.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled reports whether the limit restricts anything
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// FromConfig returns the limit of each route group
+func FromConfig(cfg config.RateLimitConfig) map[string]Limit {
+	return map[string]Limit{
+		GroupRead:   {Rate: cfg.ReadRate, Burst: cfg.ReadBurst},
+		GroupSignup: {Rate: cfg.SignupRate, Burst: cfg.SignupBurst},
+		GroupPost:   {Rate: cfg.PostRate, Burst: cfg.PostBurst},
+		GroupWrite:  {Rate: cfg.WriteRate, Burst: cfg.WriteBurst},
+		GroupIP:     {Rate: cfg.IPRate, Burst: cfg.IPBurst},
+	}
+}
+
+// Key returns the bucket key of a route group for the authenticated
+// user, or for the client IP when user is empty
+func Key(group, user, ip string) string {
+	if user != "" {
+		return group + ":user:" + user
+	}
+	return group + ":ip:" + ip
+}
+
+// Store keeps the token buckets
+type Store interface {
+	// Take removes a token from the bucket for key. If the bucket is
+	// empty it returns false and how long until a token is available.
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// refill returns the tokens in a bucket that held tokens at last, now
+func refill(tokens float64, last, now time.Time, limit Limit) float64 {
+	elapsed := now.Sub(last).Seconds()
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+}
+
+// wait returns how long until a bucket holding tokens has a whole token
+func wait(tokens float64, limit Limit) time.Duration {
+	return time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRefill(t *testing.T) {
+	limit := Limit{Rate: 2, Burst: 10}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		tokens  float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{"no time passed", 3, 0, 3},
+		{"half a second adds one token", 3, 500 * time.Millisecond, 4},
+		{"capped at the burst", 9, 5 * time.Second, 10},
+		{"clock going backwards adds nothing", 3, -time.Second, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := refill(tt.tokens, start, start.Add(tt.elapsed), limit); got != tt.want {
+				t.Errorf("refill(%v, %v) = %v, want %v", tt.tokens, tt.elapsed, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWait(t *testing.T) {
+	limit := Limit{Rate: 4, Burst: 10}
+	tests := []struct {
+		tokens float64
+		want   time.Duration
+	}{
+		{0, 250 * time.Millisecond},
+		{0.5, 125 * time.Millisecond},
+		{0.75, 62500 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		if got := wait(tt.tokens, limit); got != tt.want {
+			t.Errorf("wait(%v) = %v, want %v", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Burst: 3}
+	ctx := context.Background()
+
+	// A new bucket starts full
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := store.Take(ctx, "k", limit); !allowed {
+			t.Fatalf("take %d refused, want the burst allowed", i+1)
+		}
+	}
+	allowed, retryAfter, _ := store.Take(ctx, "k", limit)
+	if allowed {
+		t.Fatal("take past the burst allowed")
+	} else if retryAfter != time.Second {
+		t.Errorf("retry after %v, want 1s", retryAfter)
+	}
+
+	// Other keys have their own bucket
+	if allowed, _, _ := store.Take(ctx, "other", limit); !allowed {
+		t.Error("take of another key refused")
+	}
+
+	// One token comes back per second
+	now = now.Add(time.Second)
+	if allowed, _, _ := store.Take(ctx, "k", limit); !allowed {
+		t.Error("take after a refill refused")
+	}
+	if allowed, _, _ := store.Take(ctx, "k", limit); allowed {
+		t.Error("second take after refilling one token allowed")
+	}
+}
+
+func TestKey(t *testing.T) {
+	if got := Key(GroupPost, "alice", "10.0.0.1"); got != "post:user:alice" {
+		t.Errorf("Key with a user = %q", got)
+	}
+	if got := Key(GroupPost, "", "10.0.0.1"); got != "post:ip:10.0.0.1" {
+		t.Errorf("Key without a user = %q", got)
+	}
+}
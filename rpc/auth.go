@@ -0,0 +1,136 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"gonews/auth"
+	"gonews/config"
+	"gonews/gonewspb"
+	"gonews/mail"
+	"gonews/models"
+	"gonews/services"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type authServer struct {
+	gonewspb.UnimplementedAuthServer
+	db        *mongo.Database
+	cfg       config.AuthConfig
+	lockout   config.LockoutConfig
+	retention time.Duration
+	sender    mail.Sender
+}
+
+func (s *authServer) Login(ctx context.Context, req *gonewspb.LoginRequest) (*gonewspb.LoginResponse, error) {
+	result, err := services.Login(ctx, s.db, s.sender, req.GetUsername(), req.GetPassword(), req.GetRestore(), s.cfg.SessionTTL, s.retention, s.cfg.Admins, s.cfg.TwoFactorRoles, s.lockout)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoLogin(result), nil
+}
+
+func (s *authServer) CompleteLogin(ctx context.Context, req *gonewspb.CompleteLoginRequest) (*gonewspb.LoginResponse, error) {
+	result, err := services.CompleteLogin(ctx, s.db, s.sender, req.GetChallenge(), req.GetCode(), s.cfg.SessionTTL, s.retention, s.lockout)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoLogin(result), nil
+}
+
+func (s *authServer) Logout(ctx context.Context, req *gonewspb.LogoutRequest) (*gonewspb.LogoutResponse, error) {
+	if auth.UserFromContext(ctx) == nil {
+		return nil, toStatus(ctx, models.ErrAuthRequired)
+	}
+	token, _ := bearerToken(ctx)
+	if err := services.Logout(ctx, s.db, token); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.LogoutResponse{}, nil
+}
+
+func (s *authServer) VerifyEmail(ctx context.Context, req *gonewspb.VerifyEmailRequest) (*gonewspb.User, error) {
+	user, err := services.VerifyEmail(ctx, s.db, req.GetToken())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoUser(user), nil
+}
+
+func (s *authServer) ResendVerification(ctx context.Context, req *gonewspb.ResendVerificationRequest) (*gonewspb.ResendVerificationResponse, error) {
+	if auth.UserFromContext(ctx) == nil {
+		return nil, toStatus(ctx, models.ErrAuthRequired)
+	}
+	if err := services.ResendVerification(ctx, s.db, s.sender, auth.Actor(ctx), s.cfg.VerificationTTL); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.ResendVerificationResponse{}, nil
+}
+
+func (s *authServer) RequestPasswordReset(ctx context.Context, req *gonewspb.RequestPasswordResetRequest) (*gonewspb.RequestPasswordResetResponse, error) {
+	if err := services.RequestPasswordReset(ctx, s.db, s.sender, req.GetEmail(), s.cfg.ResetTTL); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.RequestPasswordResetResponse{}, nil
+}
+
+func (s *authServer) ConfirmPasswordReset(ctx context.Context, req *gonewspb.ConfirmPasswordResetRequest) (*gonewspb.ConfirmPasswordResetResponse, error) {
+	if err := services.ResetPassword(ctx, s.db, req.GetToken(), req.GetPassword()); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.ConfirmPasswordResetResponse{}, nil
+}
+
+func (s *authServer) EnrollTwoFactor(ctx context.Context, req *gonewspb.EnrollTwoFactorRequest) (*gonewspb.EnrollTwoFactorResponse, error) {
+	if auth.UserFromContext(ctx) == nil {
+		return nil, toStatus(ctx, models.ErrAuthRequired)
+	}
+	enrollment, err := services.EnrollTwoFactor(ctx, s.db, auth.Actor(ctx))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.EnrollTwoFactorResponse{Secret: enrollment.Secret, Uri: enrollment.URI}, nil
+}
+
+func (s *authServer) ConfirmTwoFactor(ctx context.Context, req *gonewspb.TwoFactorCodeRequest) (*gonewspb.RecoveryCodesResponse, error) {
+	if auth.UserFromContext(ctx) == nil {
+		return nil, toStatus(ctx, models.ErrAuthRequired)
+	}
+	codes, err := services.ConfirmTwoFactor(ctx, s.db, auth.Actor(ctx), req.GetCode())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *authServer) RegenerateRecoveryCodes(ctx context.Context, req *gonewspb.TwoFactorCodeRequest) (*gonewspb.RecoveryCodesResponse, error) {
+	if auth.UserFromContext(ctx) == nil {
+		return nil, toStatus(ctx, models.ErrAuthRequired)
+	}
+	codes, err := services.RegenerateRecoveryCodes(ctx, s.db, auth.Actor(ctx), req.GetCode())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+func (s *authServer) DisableTwoFactor(ctx context.Context, req *gonewspb.TwoFactorCodeRequest) (*gonewspb.DisableTwoFactorResponse, error) {
+	if auth.UserFromContext(ctx) == nil {
+		return nil, toStatus(ctx, models.ErrAuthRequired)
+	}
+	if err := services.DisableTwoFactor(ctx, s.db, auth.Actor(ctx), req.GetCode(), s.cfg.TwoFactorRoles); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.DisableTwoFactorResponse{}, nil
+}
+
+func toProtoLogin(result *services.LoginResult) *gonewspb.LoginResponse {
+	return &gonewspb.LoginResponse{
+		Token:                  result.Token,
+		ExpiresAt:              timestamppb.New(result.ExpiresAt),
+		TwoFactorRequired:      result.TwoFactorRequired,
+		TwoFactorSetupRequired: result.TwoFactorSetupRequired,
+	}
+}
@@ -0,0 +1,27 @@
+package rpc
+
+import (
+	"context"
+
+	"gonews/auth"
+	"gonews/gonewspb"
+	"gonews/services"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type jobsServer struct {
+	gonewspb.UnimplementedJobsServer
+	db *mongo.Database
+}
+
+func (s *jobsServer) GetJob(ctx context.Context, req *gonewspb.GetJobRequest) (*gonewspb.Job, error) {
+	if err := auth.Require(ctx, auth.ViewJobs); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	job, err := services.GetJob(ctx, s.db, req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoJob(job), nil
+}
@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"gonews/auth"
+	"gonews/filters"
+	"gonews/gonewspb"
+	"gonews/models"
+	"gonews/services"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type postsServer struct {
+	gonewspb.UnimplementedPostsServer
+	db *mongo.Database
+
+	retention       time.Duration
+	reportThreshold int
+	contentFilters  *filters.Pipeline
+}
+
+func (s *postsServer) ListPosts(ctx context.Context, req *gonewspb.ListPostsRequest) (*gonewspb.ListPostsResponse, error) {
+	posts, count, err := services.ListPosts(ctx, s.db)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	res := &gonewspb.ListPostsResponse{Count: int32(count)}
+	for _, post := range posts {
+		res.Posts = append(res.Posts, toProtoPost(post))
+	}
+	return res, nil
+}
+
+func (s *postsServer) ListUserPosts(ctx context.Context, req *gonewspb.ListUserPostsRequest) (*gonewspb.ListPostsResponse, error) {
+	posts, count, err := services.ListUserPosts(ctx, s.db, req.GetUsername())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	res := &gonewspb.ListPostsResponse{Count: int32(count)}
+	for _, post := range posts {
+		res.Posts = append(res.Posts, toProtoPost(post))
+	}
+	return res, nil
+}
+
+func (s *postsServer) GetPost(ctx context.Context, req *gonewspb.GetPostRequest) (*gonewspb.Post, error) {
+	post, err := services.GetPost(ctx, s.db, req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoPost(post), nil
+}
+
+func (s *postsServer) CreatePost(ctx context.Context, req *gonewspb.CreatePostRequest) (*gonewspb.Post, error) {
+	if err := auth.RequireSelf(ctx, req.GetUsername()); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	post := models.Post{Content: req.GetContent()}
+
+	dbPost, err := services.CreatePost(ctx, s.db, req.GetUsername(), post, s.contentFilters)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoPost(dbPost), nil
+}
+
+func (s *postsServer) DeletePost(ctx context.Context, req *gonewspb.DeletePostRequest) (*gonewspb.DeletePostResponse, error) {
+	if err := auth.RequireSelfOr(ctx, req.GetUsername(), auth.ModeratePosts); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	res, err := services.DeletePost(ctx, s.db, req.GetUsername(), req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.DeletePostResponse{DeletedCount: deletedCount(res)}, nil
+}
+
+func (s *postsServer) RestorePost(ctx context.Context, req *gonewspb.RestorePostRequest) (*gonewspb.Post, error) {
+	if err := auth.RequireSelfOr(ctx, req.GetUsername(), auth.ModeratePosts); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	post, err := services.RestorePost(ctx, s.db, req.GetUsername(), req.GetId(), s.retention)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoPost(post), nil
+}
+
+func (s *postsServer) ReportPost(ctx context.Context, req *gonewspb.ReportPostRequest) (*gonewspb.Report, error) {
+	report, err := services.ReportPost(ctx, s.db, req.GetId(), req.GetReason(), req.GetComment(), s.reportThreshold)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoReport(report), nil
+}
@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"gonews/auth"
+	"gonews/gonewspb"
+	"gonews/logging"
+	"gonews/models"
+	"gonews/ratelimit"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// methodGroups names the rate limit group of each method, the group of
+// its REST route
+var methodGroups = map[string]string{
+	gonewspb.Users_ListUsers_FullMethodName:              ratelimit.GroupRead,
+	gonewspb.Users_GetUser_FullMethodName:                ratelimit.GroupRead,
+	gonewspb.Users_CreateUser_FullMethodName:             ratelimit.GroupSignup,
+	gonewspb.Users_UpdateUser_FullMethodName:             ratelimit.GroupWrite,
+	gonewspb.Users_DeleteUser_FullMethodName:             ratelimit.GroupWrite,
+	gonewspb.Users_RestoreUser_FullMethodName:            ratelimit.GroupWrite,
+	gonewspb.Posts_ListPosts_FullMethodName:              ratelimit.GroupRead,
+	gonewspb.Posts_ListUserPosts_FullMethodName:          ratelimit.GroupRead,
+	gonewspb.Posts_GetPost_FullMethodName:                ratelimit.GroupRead,
+	gonewspb.Posts_CreatePost_FullMethodName:             ratelimit.GroupPost,
+	gonewspb.Posts_DeletePost_FullMethodName:             ratelimit.GroupWrite,
+	gonewspb.Posts_RestorePost_FullMethodName:            ratelimit.GroupWrite,
+	gonewspb.Posts_ReportPost_FullMethodName:             ratelimit.GroupWrite,
+	gonewspb.Tags_ListPostsByTag_FullMethodName:          ratelimit.GroupRead,
+	gonewspb.Jobs_GetJob_FullMethodName:                  ratelimit.GroupRead,
+	gonewspb.Auth_Login_FullMethodName:                   ratelimit.GroupWrite,
+	gonewspb.Auth_CompleteLogin_FullMethodName:           ratelimit.GroupWrite,
+	gonewspb.Auth_Logout_FullMethodName:                  ratelimit.GroupWrite,
+	gonewspb.Auth_VerifyEmail_FullMethodName:             ratelimit.GroupWrite,
+	gonewspb.Auth_ResendVerification_FullMethodName:      ratelimit.GroupSignup,
+	gonewspb.Auth_RequestPasswordReset_FullMethodName:    ratelimit.GroupSignup,
+	gonewspb.Auth_ConfirmPasswordReset_FullMethodName:    ratelimit.GroupWrite,
+	gonewspb.Auth_EnrollTwoFactor_FullMethodName:         ratelimit.GroupWrite,
+	gonewspb.Auth_ConfirmTwoFactor_FullMethodName:        ratelimit.GroupWrite,
+	gonewspb.Auth_RegenerateRecoveryCodes_FullMethodName: ratelimit.GroupWrite,
+	gonewspb.Auth_DisableTwoFactor_FullMethodName:        ratelimit.GroupWrite,
+}
+
+// rateLimitInterceptor takes a token from the bucket of the method's
+// group for the authenticated user, or the peer IP for anonymous calls,
+// and fails the call with ResourceExhausted and a retry-after header
+// when the bucket is empty. It runs after authInterceptor so the user is
+// known. A failing store lets calls through, as RateLimit does.
+func rateLimitInterceptor(store ratelimit.Store, limits map[string]ratelimit.Limit) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		group, ok := methodGroups[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		user := ""
+		if u := auth.UserFromContext(ctx); u != nil {
+			user = u.Username
+		}
+		if err := take(ctx, store, ratelimit.Key(group, user, auth.RequestFromContext(ctx).IP), limits[group]); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ipRateLimitInterceptor takes a token from the peer IP's bucket for
+// every call. It runs before authInterceptor, as RateLimitIP runs before
+// Authenticate.
+func ipRateLimitInterceptor(store ratelimit.Store, limit ratelimit.Limit) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := take(ctx, store, ratelimit.Key(ratelimit.GroupIP, "", auth.RequestFromContext(ctx).IP), limit); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// take takes a token from the bucket for key and returns the
+// ResourceExhausted error when it is empty
+func take(ctx context.Context, store ratelimit.Store, key string, limit ratelimit.Limit) error {
+	if !limit.Enabled() {
+		return nil
+	}
+
+	allowed, retryAfter, err := store.Take(ctx, key, limit)
+	if err != nil {
+		logging.FromContext(ctx).Error("Rate limit store failed", slog.Any("error", err))
+		return nil
+	}
+	if !allowed {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))))
+		return toStatus(ctx, models.ErrTooManyRequests)
+	}
+	return nil
+}
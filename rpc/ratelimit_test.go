@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"gonews/auth"
+	"gonews/gonewspb"
+	"gonews/ratelimit"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestEveryMethodHasRateLimitGroup(t *testing.T) {
+	for _, desc := range []grpc.ServiceDesc{
+		gonewspb.Users_ServiceDesc,
+		gonewspb.Posts_ServiceDesc,
+		gonewspb.Tags_ServiceDesc,
+		gonewspb.Jobs_ServiceDesc,
+		gonewspb.Auth_ServiceDesc,
+	} {
+		for _, method := range desc.Methods {
+			name := "/" + desc.ServiceName + "/" + method.MethodName
+			if _, ok := methodGroups[name]; !ok {
+				t.Errorf("%s has no rate limit group", name)
+			}
+		}
+	}
+}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	limits := map[string]ratelimit.Limit{ratelimit.GroupSignup: {Rate: 0.001, Burst: 1}}
+	interceptor := rateLimitInterceptor(ratelimit.NewMemoryStore(), limits)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(method, ip string) error {
+		ctx := auth.WithRequest(context.Background(), auth.Request{IP: ip})
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	if err := call(gonewspb.Users_CreateUser_FullMethodName, "10.0.0.1"); err != nil {
+		t.Fatalf("first signup: %v", err)
+	}
+	if err := call(gonewspb.Users_CreateUser_FullMethodName, "10.0.0.1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("second signup from the same IP: %v, want ResourceExhausted", err)
+	}
+	if err := call(gonewspb.Users_CreateUser_FullMethodName, "10.0.0.2"); err != nil {
+		t.Errorf("signup from another IP: %v", err)
+	}
+	// Groups without a limit are not limited
+	for i := 0; i < 3; i++ {
+		if err := call(gonewspb.Posts_ListPosts_FullMethodName, "10.0.0.1"); err != nil {
+			t.Errorf("read %d: %v", i, err)
+		}
+	}
+}
+
+func TestIPRateLimitInterceptor(t *testing.T) {
+	interceptor := ipRateLimitInterceptor(ratelimit.NewMemoryStore(), ratelimit.Limit{Rate: 0.001, Burst: 2})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	call := func(method, ip string) error {
+		ctx := auth.WithRequest(context.Background(), auth.Request{IP: ip})
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	// Every method shares the IP's bucket
+	if err := call(gonewspb.Posts_ListPosts_FullMethodName, "10.0.0.1"); err != nil {
+		t.Fatalf("first call: %v", err)
+	}
+	if err := call(gonewspb.Auth_Login_FullMethodName, "10.0.0.1"); err != nil {
+		t.Fatalf("second call: %v", err)
+	}
+	if err := call(gonewspb.Posts_GetPost_FullMethodName, "10.0.0.1"); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("third call from the same IP: %v, want ResourceExhausted", err)
+	}
+	if err := call(gonewspb.Posts_GetPost_FullMethodName, "10.0.0.2"); err != nil {
+		t.Errorf("call from another IP: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gonews/auth"
+	"gonews/config"
+	"gonews/filters"
+	"gonews/gonewspb"
+	"gonews/logging"
+	"gonews/mail"
+	"gonews/models"
+	"gonews/ratelimit"
+	"gonews/services"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// NewServer registers the Users, Posts, Tags, Jobs and Auth services on a
+// new gRPC server, along with the standard health service reporting
+// healthServer. Calls take from the same rate limit buckets in limiter as
+// their REST routes.
+func NewServer(cfg config.Config, db *mongo.Database, logger *slog.Logger, sender mail.Sender, limiter ratelimit.Store, healthServer *health.Server) *grpc.Server {
+	limits := ratelimit.FromConfig(cfg.RateLimit)
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		loggingInterceptor(logger),
+		ipRateLimitInterceptor(limiter, limits[ratelimit.GroupIP]),
+		authInterceptor(db, cfg.Auth.TwoFactorRoles),
+		rateLimitInterceptor(limiter, limits),
+	))
+	gonewspb.RegisterUsersServer(server, &usersServer{
+		db:              db,
+		deletionPolicy:  cfg.Accounts.DeletionPolicy,
+		retention:       cfg.Trash.Retention,
+		sender:          sender,
+		verificationTTL: cfg.Auth.VerificationTTL,
+	})
+	gonewspb.RegisterPostsServer(server, &postsServer{
+		db:              db,
+		retention:       cfg.Trash.Retention,
+		reportThreshold: cfg.Reports.HideThreshold,
+		contentFilters:  filters.FromConfig(db, cfg.Filters),
+	})
+	gonewspb.RegisterTagsServer(server, &tagsServer{db: db})
+	gonewspb.RegisterJobsServer(server, &jobsServer{db: db})
+	gonewspb.RegisterAuthServer(server, &authServer{db: db, cfg: cfg.Auth, lockout: cfg.Lockout, retention: cfg.Trash.Retention, sender: sender})
+	healthpb.RegisterHealthServer(server, healthServer)
+	return server
+}
+
+// loggingInterceptor attaches a request-scoped logger and the origin of
+// the call to the context and logs every completed call
+func loggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		requestID := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if ids := md.Get("x-request-id"); len(ids) > 0 {
+				requestID = ids[0]
+			}
+		}
+		requestLogger := logger.With(
+			slog.String("request_id", requestID),
+			slog.String("route", info.FullMethod),
+		)
+		ctx = logging.WithLogger(ctx, requestLogger)
+		request := auth.Request{Method: "gRPC", Route: info.FullMethod, RequestID: requestID}
+		if p, ok := peer.FromContext(ctx); ok {
+			request.IP, _, _ = net.SplitHostPort(p.Addr.String())
+		}
+		ctx = auth.WithRequest(ctx, request)
+
+		res, err := handler(ctx, req)
+
+		code := status.Code(err)
+		level := slog.LevelInfo
+		if code == codes.Internal || code == codes.Unknown {
+			level = slog.LevelError
+		}
+		requestLogger.LogAttrs(ctx, level, "call completed",
+			slog.String("code", code.String()),
+			slog.Duration("latency", time.Since(start)),
+		)
+		return res, err
+	}
+}
+
+// methodScopes lists the scope an API key needs for each method that
+// acts on private data. Methods mapped to "" manage credentials and
+// need a session.
+var methodScopes = map[string]auth.Scope{
+	gonewspb.Users_UpdateUser_FullMethodName:             auth.ScopeUsersWrite,
+	gonewspb.Users_DeleteUser_FullMethodName:             auth.ScopeUsersWrite,
+	gonewspb.Users_RestoreUser_FullMethodName:            auth.ScopeUsersWrite,
+	gonewspb.Posts_CreatePost_FullMethodName:             auth.ScopePostsWrite,
+	gonewspb.Posts_DeletePost_FullMethodName:             auth.ScopePostsWrite,
+	gonewspb.Posts_RestorePost_FullMethodName:            auth.ScopePostsWrite,
+	gonewspb.Posts_ReportPost_FullMethodName:             auth.ScopePostsWrite,
+	gonewspb.Jobs_GetJob_FullMethodName:                  auth.ScopeAdmin,
+	gonewspb.Auth_ResendVerification_FullMethodName:      auth.ScopeUsersWrite,
+	gonewspb.Auth_Logout_FullMethodName:                  "",
+	gonewspb.Auth_EnrollTwoFactor_FullMethodName:         "",
+	gonewspb.Auth_ConfirmTwoFactor_FullMethodName:        "",
+	gonewspb.Auth_RegenerateRecoveryCodes_FullMethodName: "",
+	gonewspb.Auth_DisableTwoFactor_FullMethodName:        "",
+}
+
+// authInterceptor resolves the bearer token in the authorization
+// metadata to the user of the session or API key, stored with
+// auth.WithUser along with auth.WithSession or auth.WithAPIKey. Calls without one stay anonymous; each handler checks
+// the permissions it needs, and API keys are checked against
+// methodScopes. Users whose role is listed in twoFactorRoles act as
+// models.RoleUser until they enable two-factor authentication.
+func authInterceptor(db *mongo.Database, twoFactorRoles []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		token, err := bearerToken(ctx)
+		if err != nil {
+			return nil, toStatus(ctx, err)
+		} else if token == "" {
+			return handler(ctx, req)
+		}
+
+		if !services.IsAPIKey(token) {
+			user, session, err := services.Authenticate(ctx, db, token, twoFactorRoles)
+			if err != nil {
+				return nil, toStatus(ctx, err)
+			}
+			return handler(auth.WithSession(auth.WithUser(ctx, user), session), req)
+		}
+
+		user, key, err := services.AuthenticateAPIKey(ctx, db, token, twoFactorRoles)
+		if err != nil {
+			return nil, toStatus(ctx, err)
+		}
+		ctx = auth.WithAPIKey(auth.WithUser(ctx, user), key)
+		if scope, ok := methodScopes[info.FullMethod]; ok {
+			if scope == "" {
+				err = auth.RequireSession(ctx)
+			} else {
+				err = auth.RequireScope(ctx, scope)
+			}
+			if err != nil {
+				return nil, toStatus(ctx, err)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// bearerToken returns the token of the authorization metadata, or "" if
+// there is none
+func bearerToken(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	scheme, token, _ := strings.Cut(values[0], " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", models.ErrMalformedAuth
+	}
+	return token, nil
+}
+
+// toStatus maps model error kinds to gRPC status codes, matching the
+// HTTP statuses used by the REST error middleware, and sends the
+// retry-after header of errors telling when to retry
+func toStatus(ctx context.Context, err error) error {
+	var domainErr *models.Error
+	if errors.As(err, &domainErr) {
+		if seconds, ok := domainErr.RetryAfter(); ok {
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(seconds)))
+		}
+		switch {
+		case errors.Is(err, models.ErrNotFound):
+			return status.Error(codes.NotFound, domainErr.Message)
+		case errors.Is(err, models.ErrConflict):
+			return status.Error(codes.AlreadyExists, domainErr.Message)
+		case errors.Is(err, models.ErrValidation):
+			return status.Error(codes.InvalidArgument, domainErr.Message)
+		case errors.Is(err, models.ErrTimeout):
+			return status.Error(codes.DeadlineExceeded, domainErr.Message)
+		case errors.Is(err, models.ErrUnavailable):
+			return status.Error(codes.Unavailable, domainErr.Message)
+		case errors.Is(err, models.ErrRateLimited):
+			return status.Error(codes.ResourceExhausted, domainErr.Message)
+		case errors.Is(err, models.ErrUnauthorized):
+			return status.Error(codes.Unauthenticated, domainErr.Message)
+		case errors.Is(err, models.ErrForbidden):
+			return status.Error(codes.PermissionDenied, domainErr.Message)
+		case errors.Is(err, models.ErrTooLarge):
+			return status.Error(codes.ResourceExhausted, domainErr.Message)
+		}
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, err.Error())
+	}
+	logging.FromContext(ctx).Error("Call failed", slog.Any("error", err))
+	return status.Error(codes.Internal, "Internal server error")
+}
+
+func toProtoUser(user *models.User) *gonewspb.User {
+	return &gonewspb.User{
+		Id:               user.ID.Hex(),
+		Username:         user.Username,
+		Email:            user.Email,
+		CreatedAt:        timestamppb.New(user.CreatedAt),
+		UpdatedAt:        timestamppb.New(user.UpdatedAt),
+		EmailVerified:    user.EmailVerifiedAt != nil,
+		TwoFactorEnabled: user.TwoFactorEnabled(),
+	}
+}
+
+// toVisibleProtoUser leaves out the account details of user unless the
+// caller is the user or a user admin
+func toVisibleProtoUser(ctx context.Context, user *models.User) *gonewspb.User {
+	if auth.CanSeeAccount(ctx, user.Username) {
+		return toProtoUser(user)
+	}
+	return &gonewspb.User{
+		Id:        user.ID.Hex(),
+		Username:  user.Username,
+		CreatedAt: timestamppb.New(user.CreatedAt),
+	}
+}
+
+func toProtoPost(post *models.Post) *gonewspb.Post {
+	return &gonewspb.Post{
+		Id:        post.ID.Hex(),
+		Author:    post.Author,
+		Content:   post.Content,
+		Tags:      post.Tags,
+		CreatedAt: timestamppb.New(post.CreatedAt),
+		UpdatedAt: timestamppb.New(post.UpdatedAt),
+	}
+}
+
+func toProtoReport(report *models.Report) *gonewspb.Report {
+	return &gonewspb.Report{
+		Id:        report.ID.Hex(),
+		PostId:    report.PostID.Hex(),
+		Reason:    report.Reason,
+		Comment:   report.Comment,
+		Status:    report.Status,
+		CreatedAt: timestamppb.New(report.CreatedAt),
+	}
+}
+
+func toProtoJob(job *models.Job) *gonewspb.Job {
+	return &gonewspb.Job{
+		Id:        job.ID.Hex(),
+		Type:      job.Type,
+		State:     job.State,
+		Step:      job.Step,
+		Params:    job.Params,
+		Attempts:  int32(job.Attempts),
+		Error:     job.Error,
+		CreatedAt: timestamppb.New(job.CreatedAt),
+		UpdatedAt: timestamppb.New(job.UpdatedAt),
+	}
+}
+
+func deletedCount(res interface{}) int64 {
+	count, _ := res.(int64)
+	return count
+}
@@ -0,0 +1,28 @@
+package rpc
+
+import (
+	"context"
+
+	"gonews/gonewspb"
+	"gonews/services"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type tagsServer struct {
+	gonewspb.UnimplementedTagsServer
+	db *mongo.Database
+}
+
+func (s *tagsServer) ListPostsByTag(ctx context.Context, req *gonewspb.ListPostsByTagRequest) (*gonewspb.ListPostsByTagResponse, error) {
+	page, err := services.ListPostsByTag(ctx, s.db, req.GetTag(), req.GetBefore(), int(req.GetLimit()))
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	res := &gonewspb.ListPostsByTagResponse{PostCount: page.PostCount, Next: page.Next}
+	for _, post := range page.Posts {
+		res.Posts = append(res.Posts, toProtoPost(post))
+	}
+	return res, nil
+}
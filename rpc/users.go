@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	"gonews/auth"
+	"gonews/gonewspb"
+	"gonews/mail"
+	"gonews/models"
+	"gonews/services"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type usersServer struct {
+	gonewspb.UnimplementedUsersServer
+	db *mongo.Database
+
+	deletionPolicy  string
+	retention       time.Duration
+	sender          mail.Sender
+	verificationTTL time.Duration
+}
+
+func (s *usersServer) ListUsers(ctx context.Context, req *gonewspb.ListUsersRequest) (*gonewspb.ListUsersResponse, error) {
+	users, count, err := services.ListUsers(ctx, s.db)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	res := &gonewspb.ListUsersResponse{Count: int32(count)}
+	for _, user := range users {
+		res.Users = append(res.Users, toVisibleProtoUser(ctx, user))
+	}
+	return res, nil
+}
+
+func (s *usersServer) GetUser(ctx context.Context, req *gonewspb.GetUserRequest) (*gonewspb.User, error) {
+	user, err := services.GetUser(ctx, s.db, req.GetUsername())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toVisibleProtoUser(ctx, user), nil
+}
+
+func (s *usersServer) CreateUser(ctx context.Context, req *gonewspb.CreateUserRequest) (*gonewspb.User, error) {
+	user := models.User{
+		Username: req.GetUsername(),
+		Email:    req.GetEmail(),
+		Password: req.GetPassword(),
+	}
+
+	dbUser, err := services.CreateUser(ctx, s.db, user, s.sender, s.verificationTTL)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoUser(dbUser), nil
+}
+
+func (s *usersServer) UpdateUser(ctx context.Context, req *gonewspb.UpdateUserRequest) (*gonewspb.User, error) {
+	if err := auth.RequireSelfOr(ctx, req.GetUsername(), auth.ManageUsers); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	// Empty fields are left unchanged
+	patch := map[string]interface{}{}
+	for key, value := range map[string]string{
+		"username": req.GetNewUsername(),
+		"email":    req.GetEmail(),
+		"password": req.GetPassword(),
+	} {
+		if value != "" {
+			patch[key] = value
+		}
+	}
+
+	updatedUser, _, err := services.PatchUser(ctx, s.db, req.GetUsername(), patch, s.sender, s.verificationTTL)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoUser(updatedUser), nil
+}
+
+func (s *usersServer) DeleteUser(ctx context.Context, req *gonewspb.DeleteUserRequest) (*gonewspb.DeleteUserResponse, error) {
+	if err := auth.RequireSelfOr(ctx, req.GetUsername(), auth.ManageUsers); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	policy := req.GetPolicy()
+	if policy == "" {
+		policy = s.deletionPolicy
+	}
+	user, err := services.DeleteUser(ctx, s.db, req.GetUsername(), policy)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &gonewspb.DeleteUserResponse{
+		User:         toProtoUser(user),
+		RestoreUntil: timestamppb.New(user.DeletedAt.Add(s.retention)),
+	}, nil
+}
+
+func (s *usersServer) RestoreUser(ctx context.Context, req *gonewspb.RestoreUserRequest) (*gonewspb.User, error) {
+	if err := auth.RequireSelfOr(ctx, req.GetUsername(), auth.ManageUsers); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	user, err := services.RestoreUser(ctx, s.db, req.GetUsername(), s.retention)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return toProtoUser(user), nil
+}
@@ -1,119 +1,592 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 
 	// "go.mongodb.org/mongo-driver/mongo/readpref"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc/health"
 
-	db "gonews/config"
+	"gonews/auth"
+	"gonews/config"
 	"gonews/controllers"
+	"gonews/database"
+	"gonews/filters"
+	"gonews/jobs"
+	"gonews/logging"
+	"gonews/mail"
+	"gonews/middleware"
+	"gonews/models"
+	"gonews/oidc"
+	"gonews/openapi"
+	"gonews/ratelimit"
+	"gonews/rpc"
+	"gonews/services"
+	"gonews/tracing"
 )
 
-var mongoConn *mongo.Client
+// NewRouter registers every REST route. draining is set once the server
+// starts shutting down so the readiness probe fails.
+func NewRouter(cfg config.Config, db *mongo.Database, logger *slog.Logger, limiter ratelimit.Store, sender mail.Sender, draining *atomic.Bool) (*gin.Engine, error) {
+	router := gin.New()
 
-// StartService function
-func StartService(dbClient *mongo.Client) {
-	router := gin.Default()
+	// Only trust X-Forwarded-For from known proxies, the client IP keys rate limits
+	if err := router.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("http.trusted_proxies: %w", err)
+	}
+
+	// Rate limits per route group
+	limits := ratelimit.FromConfig(cfg.RateLimit)
+	readLimit := middleware.RateLimit(ratelimit.GroupRead, limiter, limits[ratelimit.GroupRead])
+	signupLimit := middleware.RateLimit(ratelimit.GroupSignup, limiter, limits[ratelimit.GroupSignup])
+	postLimit := middleware.RateLimit(ratelimit.GroupPost, limiter, limits[ratelimit.GroupPost])
+	writeLimit := middleware.RateLimit(ratelimit.GroupWrite, limiter, limits[ratelimit.GroupWrite])
+
+	// Tag every request with an ID, trace, log and measure it, and render errors as the standard envelope
+	router.Use(gin.Recovery(), middleware.RequestID(), middleware.Tracing(), middleware.Logger(logger), middleware.Metrics(), middleware.Errors())
+
+	// Validate request bodies against the OpenAPI document
+	router.Use(openapi.ValidateRequests(apiSpec, cfg.HTTP.MaxBodyBytes))
+
+	// Limit each client IP before its bearer token is looked up
+	router.Use(middleware.RateLimitIP(limiter, limits[ratelimit.GroupIP]))
+
+	// Resolve bearer tokens to users, routes below check their permissions
+	router.Use(middleware.Authenticate(db, cfg.Auth.TwoFactorRoles))
+
+	// Permission checks per route, every user may act on their own account and posts
+	self := middleware.RequireSelf()
+	selfOrUserAdmin := middleware.RequireSelfOr(auth.ManageUsers)
+	selfOrPostModerator := middleware.RequireSelfOr(auth.ModeratePosts)
+
+	// Scopes an API key needs per group of routes, sessions have them all.
+	// Credentials can only be managed with a session.
+	usersRead := middleware.RequireScope(auth.ScopeUsersRead)
+	usersWrite := middleware.RequireScope(auth.ScopeUsersWrite)
+	postsWrite := middleware.RequireScope(auth.ScopePostsWrite)
+	admin := middleware.RequireScope(auth.ScopeAdmin)
+	session := middleware.RequireSession()
+
+	// Checks on the content of new posts
+	contentFilters := filters.FromConfig(db, cfg.Filters)
+
+	// OpenID Connect providers users can sign in with
+	providers := oidc.FromConfig(cfg.OIDC)
+
+	// OpenAPI document
+	router.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, apiSpec)
+	})
+
+	// Liveness probe
+	router.GET("/healthz", controllers.Healthz)
+
+	// Readiness probe
+	router.GET("/readyz", func(c *gin.Context) {
+		controllers.Readyz(c, db, cfg.HTTP.ReadinessTimeout, draining)
+	})
+
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Home
-	router.GET("/", func(c *gin.Context) {
+	router.GET("/", readLimit, func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Welcome to GoNews!",
 		})
 	})
 
 	// Users List
-	router.GET("/users", func(c *gin.Context) {
-		controllers.ReadUsers(c, dbClient)
+	router.GET("/users", readLimit, func(c *gin.Context) {
+		controllers.ReadUsers(c, db)
 	})
 
 	// Get Single User
-	router.GET("/users/:username", func(c *gin.Context) {
+	router.GET("/users/:username", readLimit, func(c *gin.Context) {
 		username := c.Param("username")
-		controllers.ReadSingleUser(c, dbClient, username)
+		controllers.ReadSingleUser(c, db, username)
 	})
 
 	// User Create
-	router.POST("/users", func(c *gin.Context) {
-		controllers.CreateUser(c, dbClient)
+	router.POST("/users", signupLimit, func(c *gin.Context) {
+		controllers.CreateUser(c, db, sender, cfg.Auth.VerificationTTL)
+	})
+
+	// Login
+	router.POST("/auth/login", writeLimit, func(c *gin.Context) {
+		controllers.Login(c, db, sender, cfg.Auth.SessionTTL, cfg.Trash.Retention, cfg.Auth.Admins, cfg.Auth.TwoFactorRoles, cfg.Lockout)
+	})
+
+	// Login Two-Factor Step
+	router.POST("/auth/login/2fa", writeLimit, func(c *gin.Context) {
+		controllers.CompleteLogin(c, db, sender, cfg.Auth.SessionTTL, cfg.Trash.Retention, cfg.Lockout)
+	})
+
+	// Sign-in Providers
+	router.GET("/auth/oidc", readLimit, func(c *gin.Context) {
+		controllers.ReadOIDCProviders(c, providers)
+	})
+
+	// Sign-in With Provider
+	router.GET("/auth/oidc/:provider/login", writeLimit, func(c *gin.Context) {
+		controllers.StartOIDCLogin(c, db, providers, c.Param("provider"), cfg.OIDC.StateTTL)
+	})
+
+	// Sign-in Provider Callback
+	router.GET("/auth/oidc/:provider/callback", writeLimit, func(c *gin.Context) {
+		controllers.OIDCCallback(c, db, providers, c.Param("provider"), cfg.Auth.SessionTTL, cfg.Auth.TwoFactorRoles)
+	})
+
+	// Logout
+	router.POST("/auth/logout", writeLimit, session, func(c *gin.Context) {
+		controllers.Logout(c, db)
+	})
+
+	// Two-Factor Enrollment
+	router.POST("/auth/2fa/enroll", writeLimit, session, func(c *gin.Context) {
+		controllers.EnrollTwoFactor(c, db)
+	})
+
+	// Two-Factor Confirmation
+	router.POST("/auth/2fa/confirm", writeLimit, session, func(c *gin.Context) {
+		controllers.ConfirmTwoFactor(c, db)
+	})
+
+	// Two-Factor Recovery Codes
+	router.POST("/auth/2fa/recovery-codes", writeLimit, session, func(c *gin.Context) {
+		controllers.RegenerateRecoveryCodes(c, db)
+	})
+
+	// Two-Factor Disable
+	router.POST("/auth/2fa/disable", writeLimit, session, func(c *gin.Context) {
+		controllers.DisableTwoFactor(c, db, cfg.Auth.TwoFactorRoles)
+	})
+
+	// Email Verification
+	router.POST("/auth/verify-email", writeLimit, func(c *gin.Context) {
+		controllers.VerifyEmail(c, db)
+	})
+
+	// Resend Email Verification
+	router.POST("/auth/verify-email/resend", signupLimit, middleware.RequireUser(), usersWrite, func(c *gin.Context) {
+		controllers.ResendVerification(c, db, sender, cfg.Auth.VerificationTTL)
+	})
+
+	// Password Reset Request
+	router.POST("/auth/password-reset", signupLimit, func(c *gin.Context) {
+		controllers.RequestPasswordReset(c, db, sender, cfg.Auth.ResetTTL)
+	})
+
+	// Password Reset Confirm
+	router.POST("/auth/password-reset/confirm", writeLimit, func(c *gin.Context) {
+		controllers.ConfirmPasswordReset(c, db)
 	})
 
 	// User Update
-	router.PUT("/users/:username", func(c *gin.Context) {
+	router.PUT("/users/:username", writeLimit, selfOrUserAdmin, usersWrite, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.UpdateUser(c, db, username, sender, cfg.Auth.VerificationTTL)
+	})
+
+	// User Patch
+	router.PATCH("/users/:username", writeLimit, selfOrUserAdmin, usersWrite, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.PatchUser(c, db, username, sender, cfg.Auth.VerificationTTL)
+	})
+
+	// User Delete, moves the user to the trash
+	router.DELETE("/users/:username", writeLimit, selfOrUserAdmin, usersWrite, func(c *gin.Context) {
 		username := c.Param("username")
-		controllers.UpdateUser(c, dbClient, username)
+		controllers.DeleteUser(c, db, username, cfg.Accounts.DeletionPolicy, cfg.Trash.Retention)
 	})
 
-	// User Delete
-	router.DELETE("/users/:username", func(c *gin.Context) {
+	// User Restore
+	router.POST("/users/:username/restore", writeLimit, selfOrUserAdmin, usersWrite, func(c *gin.Context) {
 		username := c.Param("username")
-		controllers.DeleteUser(c, dbClient, username)
+		controllers.RestoreUser(c, db, username, cfg.Trash.Retention)
+	})
+
+	// User Data Export
+	router.GET("/users/:username/export", readLimit, selfOrUserAdmin, usersRead, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.ExportUser(c, db, username)
+	})
+
+	// User Notifications
+	router.GET("/users/:username/notifications", readLimit, self, usersRead, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.ReadNotifications(c, db, username)
+	})
+
+	// Mark User Notifications Read
+	router.POST("/users/:username/notifications/read", writeLimit, self, usersWrite, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.MarkNotificationsRead(c, db, username)
+	})
+
+	// User API Keys
+	router.GET("/users/:username/api-keys", readLimit, selfOrUserAdmin, session, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.ReadAPIKeys(c, db, username)
+	})
+
+	// API Key Create
+	router.POST("/users/:username/api-keys", writeLimit, self, session, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.CreateAPIKey(c, db, username)
+	})
+
+	// API Key Delete
+	router.DELETE("/users/:username/api-keys/:id", writeLimit, selfOrUserAdmin, session, func(c *gin.Context) {
+		username := c.Param("username")
+		id := c.Param("id")
+		controllers.DeleteAPIKey(c, db, username, id)
+	})
+
+	// Background Job Status
+	router.GET("/jobs/:id", readLimit, middleware.Require(auth.ViewJobs), admin, func(c *gin.Context) {
+		id := c.Param("id")
+		controllers.ReadJob(c, db, id)
 	})
 
 	// Read all posts
-	router.GET("/posts", func(c *gin.Context) {
-		controllers.ReadPosts(c, dbClient)
+	router.GET("/posts", readLimit, func(c *gin.Context) {
+		controllers.ReadPosts(c, db)
 	})
 
 	// Read all posts with given hashtag
-	router.GET("/tags/:tag", func(c *gin.Context) {
+	router.GET("/tags/:tag", readLimit, func(c *gin.Context) {
 		tag := c.Param("tag")
-		controllers.ReadPostsByTag(c, dbClient, tag)
+		controllers.ReadPostsByTag(c, db, tag)
 	})
 
 	// Read all user posts
-	router.GET("/users/:username/posts", func(c *gin.Context) {
+	router.GET("/users/:username/posts", readLimit, func(c *gin.Context) {
 		username := c.Param("username")
-		controllers.ReadUserPosts(c, dbClient, username)
+		controllers.ReadUserPosts(c, db, username)
 	})
 
 	// Read specific post
-	router.GET("/posts/:id", func(c *gin.Context) {
+	router.GET("/posts/:id", readLimit, func(c *gin.Context) {
+		id := c.Param("id")
+		controllers.ReadSinglePost(c, db, id)
+	})
+
+	// Report Post
+	router.POST("/posts/:id/report", writeLimit, middleware.RequireUser(), postsWrite, func(c *gin.Context) {
 		id := c.Param("id")
-		controllers.ReadSinglePost(c, dbClient, id)
+		controllers.ReportPost(c, db, id, cfg.Reports.HideThreshold)
 	})
 
 	// Post Create
-	router.POST("/users/:username/posts", func(c *gin.Context) {
+	router.POST("/users/:username/posts", postLimit, self, postsWrite, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.CreatePost(c, db, username, contentFilters)
+	})
+
+	// Post Delete, moves the post to the trash
+	router.DELETE("/users/:username/posts/:id", writeLimit, selfOrPostModerator, postsWrite, func(c *gin.Context) {
+		username, id := c.Param("username"), c.Param("id")
+		controllers.DeletePost(c, db, username, id)
+	})
+
+	// Post Restore
+	router.POST("/users/:username/posts/:id/restore", writeLimit, selfOrPostModerator, postsWrite, func(c *gin.Context) {
+		username, id := c.Param("username"), c.Param("id")
+		controllers.RestorePost(c, db, username, id, cfg.Trash.Retention)
+	})
+
+	// Admin: Assign Role
+	router.PUT("/admin/users/:username/role", writeLimit, middleware.Require(auth.AssignRoles), admin, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.SetRole(c, db, username)
+	})
+
+	// Admin: Reset Two-Factor Authentication
+	router.DELETE("/admin/users/:username/2fa", writeLimit, middleware.Require(auth.ManageUsers), admin, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.ResetTwoFactor(c, db, username)
+	})
+
+	// Admin: Unlock Account
+	router.POST("/admin/users/:username/unlock", writeLimit, middleware.Require(auth.ManageUsers), admin, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.UnlockUser(c, db, username)
+	})
+
+	// Admin: Audit Log
+	router.GET("/admin/audit", readLimit, middleware.Require(auth.ViewAudit), admin, func(c *gin.Context) {
+		controllers.ReadAuditEntries(c, db)
+	})
+
+	// Moderation: Report Queue
+	router.GET("/admin/reports", readLimit, middleware.Require(auth.ModeratePosts), admin, func(c *gin.Context) {
+		controllers.ReadReports(c, db)
+	})
+
+	// Moderation: Resolve Reports
+	router.POST("/admin/reports/:id/resolve", writeLimit, middleware.Require(auth.ModeratePosts), admin, func(c *gin.Context) {
+		id := c.Param("id")
+		controllers.ResolveReports(c, db, id)
+	})
+
+	// Moderation: Suspend User
+	router.POST("/admin/users/:username/suspend", writeLimit, middleware.Require(auth.SuspendUsers), admin, func(c *gin.Context) {
+		username := c.Param("username")
+		controllers.SuspendUser(c, db, username)
+	})
+
+	// Moderation: Unsuspend User
+	router.POST("/admin/users/:username/unsuspend", writeLimit, middleware.Require(auth.SuspendUsers), admin, func(c *gin.Context) {
 		username := c.Param("username")
-		controllers.CreatePost(c, dbClient, username)
+		controllers.UnsuspendUser(c, db, username)
+	})
+
+	// Moderation: Hide Post
+	router.POST("/admin/posts/:id/hide", writeLimit, middleware.Require(auth.ModeratePosts), admin, func(c *gin.Context) {
+		id := c.Param("id")
+		controllers.SetPostHidden(c, db, id, true)
 	})
 
-	// Post Delete
-	router.DELETE("/users/:username/posts/:id", func(c *gin.Context) {
+	// Moderation: Unhide Post
+	router.POST("/admin/posts/:id/unhide", writeLimit, middleware.Require(auth.ModeratePosts), admin, func(c *gin.Context) {
 		id := c.Param("id")
-		controllers.DeletePost(c, dbClient, id)
+		controllers.SetPostHidden(c, db, id, false)
+	})
+
+	// Moderation: Lock Tag
+	router.POST("/admin/tags/:tag/lock", writeLimit, middleware.Require(auth.LockTags), admin, func(c *gin.Context) {
+		tag := c.Param("tag")
+		controllers.SetTagLocked(c, db, tag, true)
+	})
+
+	// Moderation: Unlock Tag
+	router.POST("/admin/tags/:tag/unlock", writeLimit, middleware.Require(auth.LockTags), admin, func(c *gin.Context) {
+		tag := c.Param("tag")
+		controllers.SetTagLocked(c, db, tag, false)
 	})
 
 	// 404 Not found
 	router.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"message": "Page not found:/",
-		})
+		c.Error(&models.Error{Kind: models.ErrNotFound, Code: "route_not_found", Message: "Page not found"})
 	})
 
-	router.Run(":8000")
+	// Make sure every route is documented
+	var routes []openapi.RouteInfo
+	for _, route := range router.Routes() {
+		routes = append(routes, openapi.RouteInfo{Method: route.Method, Path: route.Path})
+	}
+	if err := apiSpec.Check(routes); err != nil {
+		return nil, err
+	}
+
+	return router, nil
 }
 
 func main() {
-	enverr := godotenv.Load()
-	if enverr != nil {
-		log.Fatal("Error loading .env file")
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
 
-	fmt.Println("Connecting to database...")
-	var err error
-	mongoConn, err = db.CreateConnection()
+	logger := logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	slog.SetDefault(logger)
+
+	if err := run(cfg, logger); err != nil {
+		logger.Error("Server stopped with an error", slog.Any("error", err))
+		os.Exit(1)
+	}
+}
+
+// run serves REST and gRPC until SIGINT or SIGTERM, then drains in-flight
+// requests and disconnects from the database
+func run(cfg config.Config, logger *slog.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	models.SetTimeouts(models.Timeouts{
+		Query:  cfg.Database.QueryTimeout,
+		Insert: cfg.Database.InsertTimeout,
+		Update: cfg.Database.UpdateTimeout,
+		Delete: cfg.Database.DeleteTimeout,
+	})
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Error("Error flushing traces", slog.Any("error", err))
+		}
+	}()
+
+	logger.Info("Connecting to database...")
+	mongoConn, err := database.CreateConnection(cfg.Mongo)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+	defer func() {
+		disconnectCtx, cancel := context.WithTimeout(context.Background(), cfg.Mongo.ConnectTimeout)
+		defer cancel()
+		if err := mongoConn.Disconnect(disconnectCtx); err != nil {
+			logger.Error("Error disconnecting from database", slog.Any("error", err))
+			return
+		}
+		logger.Info("Disconnected from database")
+	}()
+	db := mongoConn.Database(cfg.Mongo.Database)
+
+	var limiter ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Backend == "mongo" {
+		mongoLimiter := ratelimit.NewMongoStore(db)
+		if err := mongoLimiter.EnsureIndexes(ctx); err != nil {
+			return fmt.Errorf("creating rate limit indexes: %w", err)
+		}
+		limiter = mongoLimiter
+	}
+
+	if err := models.DbEnsureJobIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating job indexes: %w", err)
+	}
+	if err := models.DbEnsureAuditIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating audit indexes: %w", err)
+	}
+	if err := models.DbEnsureReportIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating report indexes: %w", err)
+	}
+	if err := models.DbEnsureNotificationIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating notification indexes: %w", err)
+	}
+	if err := models.DbEnsureTokenIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating token indexes: %w", err)
+	}
+	if err := models.DbEnsureSessionIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating session indexes: %w", err)
+	}
+	if err := models.DbEnsureAPIKeyIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating api key indexes: %w", err)
+	}
+	if err := models.DbEnsureUserIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating user indexes: %w", err)
+	}
+	if err := models.DbEnsureIPLockoutIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating ip lockout indexes: %w", err)
+	}
+	if err := models.DbEnsurePostIndexes(ctx, db); err != nil {
+		return fmt.Errorf("creating post indexes: %w", err)
+	}
+	if err := services.EnqueueTagMigration(ctx, db); err != nil {
+		return fmt.Errorf("enqueuing tag migration: %w", err)
+	}
+	runner := jobs.NewRunner(db, logger, cfg.Jobs)
+	runner.Handle(services.JobDeleteUser, services.RunUserDeletion)
+	runner.Handle(services.JobMigrateTags, services.RunTagMigration)
+	runner.Handle(services.JobRenameAuthor, services.RunAuthorRename)
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		runner.Run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		jobs.Every(ctx, logger, "purge_trash", cfg.Trash.PurgeInterval, func(ctx context.Context) error {
+			return services.PurgeTrash(ctx, db, cfg.Trash.Retention)
+		})
+	}()
+	// Interrupted jobs are resumed by another instance once their lease expires
+	defer func() {
+		stop()
+		background.Wait()
+	}()
+
+	sender, err := mail.FromConfig(cfg.Mail, logger)
+	if err != nil {
+		return err
+	}
+
+	var draining atomic.Bool
+	router, err := NewRouter(cfg, db, logger, limiter, sender, &draining)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	healthServer := health.NewServer()
+	grpcServer := rpc.NewServer(cfg, db, logger, sender, limiter, healthServer)
+	grpcListener, err := net.Listen("tcp", cfg.GRPC.Addr)
+	if err != nil {
+		return fmt.Errorf("listening for gRPC: %w", err)
+	}
+
+	serveErrs := make(chan error, 2)
+	go func() {
+		logger.Info("Starting gRPC Server...", slog.String("addr", cfg.GRPC.Addr))
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			serveErrs <- fmt.Errorf("serving gRPC: %w", err)
+		}
+	}()
+	go func() {
+		logger.Info("Starting Server...", slog.String("addr", cfg.HTTP.Addr))
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErrs <- fmt.Errorf("serving HTTP: %w", err)
+		}
+	}()
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutting down...")
+	case serveErr = <-serveErrs:
+	}
+
+	// Fail readiness first so load balancers stop routing new traffic
+	draining.Store(true)
+	healthServer.Shutdown()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Error draining HTTP requests", slog.Any("error", err))
+	}
+
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		logger.Error("Timed out draining gRPC calls")
+		grpcServer.Stop()
 	}
 
-	fmt.Println("Starting Server...")
-	StartService(mongoConn)
+	return serveErr
 }
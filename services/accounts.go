@@ -0,0 +1,404 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"gonews/jobs"
+	"gonews/logging"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Account deletion policies
+const (
+	// DeletionHard deletes the user's posts and removes them from their tags
+	DeletionHard = "hard"
+	// DeletionAnonymize keeps the user's posts under DeletedAuthor
+	DeletionAnonymize = "anonymize"
+)
+
+// DeletedAuthor is the author of posts kept by DeletionAnonymize. It can
+// never be registered.
+const DeletedAuthor = "deleted"
+
+// JobDeleteUser is the job type handled by RunUserDeletion
+const JobDeleteUser = "delete_user"
+
+// deletionBatchSize bounds how many posts each step of a hard deletion
+// touches at once
+const deletionBatchSize = 500
+
+// Steps of a user deletion job, in order
+const (
+	stepPosts = ""
+	stepUser  = "user"
+)
+
+// DeleteUser moves the user with the given username and their posts to
+// the trash. policy decides what happens to the posts once the trash is
+// purged.
+func DeleteUser(ctx context.Context, db *mongo.Database, username, policy string) (*models.User, error) {
+	if policy != DeletionHard && policy != DeletionAnonymize {
+		return nil, models.NewValidationError("invalid_policy", "Deletion policy must be hard or anonymize",
+			[]models.FieldError{{Field: "policy", Message: "must be hard or anonymize"}})
+	}
+
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Trash the posts first, a retry after a failure picks up the rest
+	deletedAt := time.Now()
+	trashed, err := models.DbDeleteAuthorPosts(ctx, db, user.Username, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := models.DbDeleteUser(ctx, db, bson.M{"_id": user.ID}, deletedAt, policy); err != nil {
+		return nil, err
+	}
+
+	before := *user
+	user.DeletedAt, user.DeletionPolicy = &deletedAt, policy
+	recordAudit(ctx, db, "user.delete", "user", user.ID.Hex(), &before, user, map[string]string{
+		"posts": fmt.Sprint(trashed),
+	})
+
+	return user, nil
+}
+
+// enqueueUserDeletion starts the job that permanently deletes user. The
+// job has the user's ID so it is only started once.
+func enqueueUserDeletion(ctx context.Context, db *mongo.Database, user *models.User) error {
+	// Block restores from here on
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"pending_deletion": true}); err != nil {
+		return err
+	}
+
+	job := models.Job{
+		ID:   user.ID,
+		Type: JobDeleteUser,
+		Params: map[string]string{
+			"user_id":  user.ID.Hex(),
+			"username": user.Username,
+			"policy":   user.DeletionPolicy,
+		},
+	}
+	if _, err := models.DbInsertJob(ctx, db, job); err != nil && !errors.Is(err, models.ErrJobExists) {
+		return err
+	}
+
+	return nil
+}
+
+// RunUserDeletion removes the posts of the user in job, then their API
+// keys, sessions, tokens, notifications and document. Each step can be
+// repeated safely.
+func RunUserDeletion(ctx context.Context, db *mongo.Database, job *models.Job) error {
+	userID, err := primitive.ObjectIDFromHex(job.Params["user_id"])
+	if err != nil {
+		return fmt.Errorf("invalid user_id: %w", err)
+	}
+	username := job.Params["username"]
+
+	if job.Step == stepPosts {
+		switch job.Params["policy"] {
+		case DeletionHard:
+			if err := purgePosts(ctx, db, bson.M{"author": username}); err != nil {
+				return err
+			}
+		case DeletionAnonymize:
+			if _, err := models.DbRenamePostAuthor(ctx, db, username, DeletedAuthor); err != nil {
+				return err
+			}
+			// Bring back the posts trashed with the user, posts the user
+			// deleted themselves stay in the trash
+			filter := bson.M{"author": DeletedAuthor, "deleted_with_author": true}
+			if _, err := models.DbRestorePosts(ctx, db, filter); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown deletion policy %q", job.Params["policy"])
+		}
+
+		if err := jobs.SetStep(ctx, db, job, stepUser); err != nil {
+			return err
+		}
+	}
+
+	if _, err := models.DbDeleteAPIKeys(ctx, db, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	if _, err := models.DbDeleteSessions(ctx, db, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	if _, err := models.DbDeleteTokens(ctx, db, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	if _, err := models.DbDeleteNotifications(ctx, db, bson.M{"user_id": userID}); err != nil {
+		return err
+	}
+	if _, err := models.DbPurgeUser(ctx, db, bson.M{"_id": userID}); errors.Is(err, models.ErrUserNotFound) {
+		// Purged by an earlier attempt, which recorded it
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	recordAudit(ctx, db, "user.purge", "user", job.Params["user_id"], nil, nil, map[string]string{
+		"username": username,
+		"policy":   job.Params["policy"],
+	})
+	return nil
+}
+
+// purgePosts permanently deletes every post matching filter in batches,
+// lowering the post counts of their tags
+func purgePosts(ctx context.Context, db *mongo.Database, filter bson.M) error {
+	for {
+		ids, err := models.DbQueryPostIDs(ctx, db, filter, deletionBatchSize)
+		if err != nil {
+			return err
+		} else if len(ids) == 0 {
+			return nil
+		}
+		counts, err := models.DbCountPostTags(ctx, db, ids)
+		if err != nil {
+			return err
+		}
+		if _, err := models.DbPurgePosts(ctx, db, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+		// Lowered after deleting so a retry cannot lower them twice
+		for tag := range counts {
+			counts[tag] = -counts[tag]
+		}
+		if err := models.DbIncTagPostCounts(ctx, db, counts); err != nil {
+			return err
+		}
+		entries := make([]models.AuditEntry, len(ids))
+		for i, id := range ids {
+			entries[i] = auditEntry(ctx, "post.purge", "post", id.Hex(), nil, nil, nil)
+		}
+		writeAudit(ctx, db, entries...)
+		logging.FromContext(ctx).Info("Purged posts", slog.Int("count", len(ids)))
+	}
+}
+
+// GetJob returns the job with the given ID
+func GetJob(ctx context.Context, db *mongo.Database, id string) (*models.Job, error) {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, models.ErrInvalidID
+	}
+	return models.DbQueryJob(ctx, db, objectId)
+}
+
+// exportedUser is the user.json entry of an export. It leaves out the
+// password and two-factor secrets.
+type exportedUser struct {
+	ID               string             `json:"id"`
+	Username         string             `json:"username"`
+	Email            string             `json:"email"`
+	EmailVerifiedAt  *time.Time         `json:"email_verified_at"`
+	Role             string             `json:"role"`
+	TwoFactorEnabled bool               `json:"two_factor_enabled"`
+	Identities       []exportedIdentity `json:"identities"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+}
+
+type exportedIdentity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+type exportedPost struct {
+	ID        string     `json:"id"`
+	Content   string     `json:"content"`
+	Tags      []string   `json:"tags"`
+	Hidden    bool       `json:"hidden"`
+	DeletedAt *time.Time `json:"deleted_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+type exportedNotification struct {
+	ID        string     `json:"id"`
+	Kind      string     `json:"kind"`
+	Message   string     `json:"message"`
+	PostID    string     `json:"post_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at"`
+}
+
+// exportedReport is a report filed by the user. It leaves out the
+// moderator who resolved it.
+type exportedReport struct {
+	ID         string     `json:"id"`
+	PostID     string     `json:"post_id"`
+	Reason     string     `json:"reason"`
+	Comment    string     `json:"comment"`
+	Status     string     `json:"status"`
+	Resolution string     `json:"resolution"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// exportedAPIKey is the metadata of an API key, without its hash
+type exportedAPIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// UserExport holds everything stored about a user
+type UserExport struct {
+	user          exportedUser
+	posts         []exportedPost
+	notifications []exportedNotification
+	reports       []exportedReport
+	apiKeys       []exportedAPIKey
+}
+
+// ExportUser collects the data of the user with the given username,
+// including their posts in the trash or hidden by moderators
+func ExportUser(ctx context.Context, db *mongo.Database, username string) (*UserExport, error) {
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"author": user.Username, "deleted_at": models.AnyDeletion, "hidden": models.AnyVisibility}
+	posts, err, _ := models.DbQueryPosts(ctx, db, filter)
+	if err != nil {
+		return nil, err
+	}
+	notifications, err := models.DbQueryNotifications(ctx, db, bson.M{"user_id": user.ID}, 0)
+	if err != nil {
+		return nil, err
+	}
+	reports, err := models.DbQueryReports(ctx, db, bson.M{"reporter_id": user.ID})
+	if err != nil {
+		return nil, err
+	}
+	keys, err := models.DbQueryAPIKeys(ctx, db, bson.M{"user_id": user.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	export := &UserExport{
+		user: exportedUser{
+			ID:               user.ID.Hex(),
+			Username:         user.Username,
+			Email:            user.Email,
+			EmailVerifiedAt:  user.EmailVerifiedAt,
+			Role:             user.EffectiveRole(),
+			TwoFactorEnabled: user.TwoFactorEnabled(),
+			Identities:       []exportedIdentity{},
+			CreatedAt:        user.CreatedAt,
+			UpdatedAt:        user.UpdatedAt,
+		},
+		posts:         []exportedPost{},
+		notifications: []exportedNotification{},
+		reports:       []exportedReport{},
+		apiKeys:       []exportedAPIKey{},
+	}
+	for _, identity := range user.Identities {
+		export.user.Identities = append(export.user.Identities, exportedIdentity{
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			LinkedAt: identity.LinkedAt,
+		})
+	}
+	for _, post := range posts {
+		export.posts = append(export.posts, exportedPost{
+			ID:        post.ID.Hex(),
+			Content:   post.Content,
+			Tags:      post.Tags,
+			Hidden:    post.Hidden,
+			DeletedAt: post.DeletedAt,
+			CreatedAt: post.CreatedAt,
+			UpdatedAt: post.UpdatedAt,
+		})
+	}
+	for _, notification := range notifications {
+		exported := exportedNotification{
+			ID:        notification.ID.Hex(),
+			Kind:      notification.Kind,
+			Message:   notification.Message,
+			CreatedAt: notification.CreatedAt,
+			ReadAt:    notification.ReadAt,
+		}
+		if notification.PostID != nil {
+			exported.PostID = notification.PostID.Hex()
+		}
+		export.notifications = append(export.notifications, exported)
+	}
+	for _, report := range reports {
+		export.reports = append(export.reports, exportedReport{
+			ID:         report.ID.Hex(),
+			PostID:     report.PostID.Hex(),
+			Reason:     report.Reason,
+			Comment:    report.Comment,
+			Status:     report.Status,
+			Resolution: report.Resolution,
+			ResolvedAt: report.ResolvedAt,
+			CreatedAt:  report.CreatedAt,
+		})
+	}
+	for _, key := range keys {
+		export.apiKeys = append(export.apiKeys, exportedAPIKey{
+			ID:         key.ID.Hex(),
+			Name:       key.Name,
+			Prefix:     key.Prefix,
+			Scopes:     key.Scopes,
+			CreatedAt:  key.CreatedAt,
+			ExpiresAt:  key.ExpiresAt,
+			LastUsedAt: key.LastUsedAt,
+		})
+	}
+	return export, nil
+}
+
+// WriteZip writes the export as a zip archive with one JSON file per
+// kind of data
+func (e *UserExport) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"user.json", e.user},
+		{"posts.json", e.posts},
+		{"notifications.json", e.notifications},
+		{"reports.json", e.reports},
+		{"api_keys.json", e.apiKeys},
+	}
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return fmt.Errorf("writing %s: %w", file.name, err)
+		}
+	}
+	return archive.Close()
+}
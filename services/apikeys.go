@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gonews/auth"
+	"gonews/logging"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from session
+// tokens
+const APIKeyPrefix = "gnk_"
+
+// MaxAPIKeys is how many API keys a user may have at once
+const MaxAPIKeys = 20
+
+// apiKeyUseResolution is how precisely the last use of API keys is
+// tracked
+const apiKeyUseResolution = time.Minute
+
+// IsAPIKey reports whether a bearer token is an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// CreateAPIKey gives the user with the given username a new API key
+// with the given scopes, expiring after ttl or never if ttl is 0. The key
+// is returned only here.
+func CreateAPIKey(ctx context.Context, db *mongo.Database, username, name string, scopes []string, ttl time.Duration) (string, *models.APIKey, error) {
+	var problems []models.FieldError
+	if name == "" || len(name) > 100 {
+		problems = append(problems, models.FieldError{Field: "name", Message: "must be between 1 and 100 characters"})
+	}
+	if len(scopes) == 0 {
+		problems = append(problems, models.FieldError{Field: "scopes", Message: "must list at least one scope"})
+	}
+	for _, scope := range scopes {
+		if !auth.ValidScope(scope) {
+			problems = append(problems, models.FieldError{Field: "scopes", Message: "unknown scope " + scope})
+		}
+	}
+	if ttl < 0 {
+		problems = append(problems, models.FieldError{Field: "expires_in", Message: "must not be negative"})
+	}
+	if len(problems) > 0 {
+		return "", nil, models.NewValidationError("invalid_api_key", "Invalid API key", problems)
+	}
+
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return "", nil, err
+	}
+	keys, err := models.DbQueryAPIKeys(ctx, db, bson.M{"user_id": user.ID})
+	if err != nil {
+		return "", nil, err
+	} else if len(keys) >= MaxAPIKeys {
+		return "", nil, models.ErrTooManyAPIKeys
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	secret = APIKeyPrefix + secret
+	key := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    secret[:len(APIKeyPrefix)+6],
+		KeyHash:   hashToken(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expiresAt := key.CreatedAt.Add(ttl)
+		key.ExpiresAt = &expiresAt
+	}
+	if _, err := models.DbInsertAPIKey(ctx, db, key); err != nil {
+		return "", nil, err
+	}
+
+	recordAudit(ctx, db, "api_key.create", "api_key", key.ID.Hex(), nil, &key, map[string]string{"username": username})
+	return secret, &key, nil
+}
+
+// ListAPIKeys returns the API keys of the user with the given username
+func ListAPIKeys(ctx context.Context, db *mongo.Database, username string) (models.APIKeys, error) {
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+	return models.DbQueryAPIKeys(ctx, db, bson.M{"user_id": user.ID})
+}
+
+// DeleteAPIKey revokes the API key with the given ID of the user with the
+// given username
+func DeleteAPIKey(ctx context.Context, db *mongo.Database, username, id string) error {
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return models.ErrInvalidID
+	}
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"_id": objectId, "user_id": user.ID}
+	keys, err := models.DbQueryAPIKeys(ctx, db, filter)
+	if err != nil {
+		return err
+	} else if len(keys) == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+	if _, err := models.DbDeleteAPIKeys(ctx, db, filter); err != nil {
+		return err
+	}
+
+	recordAudit(ctx, db, "api_key.delete", "api_key", id, keys[0], nil, map[string]string{"username": username})
+	return nil
+}
+
+// AuthenticateAPIKey returns the user owning the given API key along with
+// the key, and records its use. Like sessions, users whose role is listed
+// in twoFactorRoles act as RoleUser until they enable two-factor
+// authentication.
+func AuthenticateAPIKey(ctx context.Context, db *mongo.Database, secret string, twoFactorRoles []string) (*models.User, *models.APIKey, error) {
+	key, err := models.DbQueryAPIKey(ctx, db, hashToken(secret))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := authenticatedUser(ctx, db, key.UserID, twoFactorRoles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := models.DbTouchAPIKey(ctx, db, key.ID, time.Now(), apiKeyUseResolution); err != nil {
+		logging.FromContext(ctx).Error("Error recording API key use", slog.String("api_key", key.ID.Hex()), slog.Any("error", err))
+	}
+	return user, key, nil
+}
+
+// authenticatedUser returns the user with the given ID for a session or
+// API key, refusing suspended users and users in the trash and dropping
+// privileges that need two-factor authentication the user has not
+// enabled
+func authenticatedUser(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, twoFactorRoles []string) (*models.User, error) {
+	user, err := findAccount(ctx, db, bson.M{"_id": userID})
+	if errors.Is(err, models.ErrUserNotFound) {
+		return nil, models.ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if user.Suspended(time.Now()) {
+		return nil, models.ErrAccountSuspended
+	} else if user.DeletedAt != nil {
+		return nil, models.ErrAccountDeleted
+	}
+	authenticated := withoutPassword(user)
+	if requiresTwoFactor(user, twoFactorRoles) && !user.TwoFactorEnabled() {
+		authenticated.Role = models.RoleUser
+	}
+	return authenticated, nil
+}
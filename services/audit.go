@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"gonews/auth"
+	"gonews/logging"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Limits of ListAuditEntries
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// redacted replaces secrets in audit diffs
+const redacted = "[redacted]"
+
+// auditEntry describes a change to resource by the caller in ctx. before
+// and after are the stored documents around the change, nil for creates
+// and permanent deletes; only the fields that differ are kept.
+func auditEntry(ctx context.Context, action, resource, resourceID string, before, after interface{}, details map[string]string) models.AuditEntry {
+	request := auth.RequestFromContext(ctx)
+	entry := models.AuditEntry{
+		Actor:      auth.Actor(ctx),
+		IP:         request.IP,
+		Method:     request.Method,
+		Route:      request.Route,
+		RequestID:  request.RequestID,
+		Action:     action,
+		Resource:   resource,
+		ResourceID: resourceID,
+		Details:    details,
+	}
+	entry.Before, entry.After = auditDiff(ctx, before, after)
+	return entry
+}
+
+// recordAudit appends a change by the caller in ctx to the audit log. A
+// failure is logged rather than undoing the change.
+func recordAudit(ctx context.Context, db *mongo.Database, action, resource, resourceID string, before, after interface{}, details map[string]string) {
+	writeAudit(ctx, db, auditEntry(ctx, action, resource, resourceID, before, after, details))
+}
+
+// writeAudit appends entries to the audit log, logging a failure
+func writeAudit(ctx context.Context, db *mongo.Database, entries ...models.AuditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	if err := models.DbInsertAuditEntries(ctx, db, entries); err != nil {
+		logging.FromContext(ctx).Error("Error recording audit entries",
+			slog.String("action", entries[0].Action), slog.Int("count", len(entries)), slog.Any("error", err))
+	}
+}
+
+// auditDiff returns the fields of before and after that differ, with
+// passwords, two-factor secrets and API key hashes redacted
+func auditDiff(ctx context.Context, before, after interface{}) (bson.M, bson.M) {
+	from, to := auditDocument(ctx, before), auditDocument(ctx, after)
+	changedFrom, changedTo := bson.M{}, bson.M{}
+	for key, value := range from {
+		if other, ok := to[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedFrom[key] = value
+		}
+	}
+	for key, value := range to {
+		if other, ok := from[key]; !ok || !reflect.DeepEqual(value, other) {
+			changedTo[key] = value
+		}
+	}
+	for _, diff := range []bson.M{changedFrom, changedTo} {
+		for _, secret := range []string{"password", "two_factor", "key_hash"} {
+			if _, ok := diff[secret]; ok {
+				diff[secret] = redacted
+			}
+		}
+	}
+	return changedFrom, changedTo
+}
+
+// auditDocument returns the stored form of v, or nil if v is nil
+func auditDocument(ctx context.Context, v interface{}) bson.M {
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil() {
+		return nil
+	}
+	data, err := bson.Marshal(v)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error encoding audit document", slog.Any("error", err))
+		return nil
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		logging.FromContext(ctx).Error("Error decoding audit document", slog.Any("error", err))
+		return nil
+	}
+	return doc
+}
+
+// AuditFilter selects audit entries. Empty fields match everything.
+type AuditFilter struct {
+	Actor      string
+	Resource   string
+	ResourceID string
+	Action     string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+// ListAuditEntries returns the audit entries matching filter, newest
+// first
+func ListAuditEntries(ctx context.Context, db *mongo.Database, filter AuditFilter) (models.AuditEntries, error) {
+	if filter.Limit == 0 {
+		filter.Limit = DefaultAuditLimit
+	} else if filter.Limit < 0 || filter.Limit > MaxAuditLimit {
+		return nil, models.NewValidationError("invalid_limit", "Limit must be between 1 and 1000",
+			[]models.FieldError{{Field: "limit", Message: "must be between 1 and 1000"}})
+	}
+	if !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Until.Before(filter.Since) {
+		return nil, models.NewValidationError("invalid_range", "until must not be before since",
+			[]models.FieldError{{Field: "until", Message: "must not be before since"}})
+	}
+
+	query := bson.M{}
+	for key, value := range map[string]string{
+		"actor":       filter.Actor,
+		"resource":    filter.Resource,
+		"resource_id": filter.ResourceID,
+		"action":      filter.Action,
+	} {
+		if value != "" {
+			query[key] = value
+		}
+	}
+	createdAt := bson.M{}
+	if !filter.Since.IsZero() {
+		createdAt["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		createdAt["$lt"] = filter.Until
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	return models.DbQueryAuditEntries(ctx, db, query, int64(filter.Limit))
+}
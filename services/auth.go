@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gonews/auth"
+	"gonews/config"
+	"gonews/logging"
+	"gonews/mail"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// hashPassword returns the bcrypt hash of password
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("hashing password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// checkPassword reports whether password matches the stored one. Users
+// created before passwords were hashed still have them in plain text,
+// needsRehash reports those.
+func checkPassword(stored, password string) (ok, needsRehash bool) {
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+}
+
+// hashToken returns the hex SHA-256 of a bearer token, as stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newToken returns a random URL-safe token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ChallengeTTL is how long a user has to enter their two-factor code
+// after their password
+const ChallengeTTL = 5 * time.Minute
+
+// LoginResult is the outcome of a correct password
+type LoginResult struct {
+	// Token is the bearer token of the new session or, when
+	// TwoFactorRequired is set, the challenge to pass to CompleteLogin
+	// along with a two-factor code
+	Token     string
+	ExpiresAt time.Time
+
+	TwoFactorRequired bool
+	// TwoFactorSetupRequired is set when the user's role requires
+	// two-factor authentication they have not enabled yet
+	TwoFactorSetupRequired bool
+}
+
+// Login checks the credentials of a user and starts a session lasting
+// ttl, or hands out a challenge if the user enabled two-factor
+// authentication. Users in the trash are refused unless restore is set,
+// which takes them out of the trash if they were deleted less than
+// retention ago. Usernames listed in admins are promoted to RoleAdmin.
+// Failed logins are throttled per account and client IP as set by
+// lockout, and the user is emailed when their account gets locked.
+func Login(ctx context.Context, db *mongo.Database, sender mail.Sender, username, password string, restore bool, ttl, retention time.Duration, admins, twoFactorRoles []string, lockout config.LockoutConfig) (*LoginResult, error) {
+	user, err := findAccount(ctx, db, bson.M{"username": username})
+	if errors.Is(err, models.ErrUserNotFound) {
+		if err := checkLockout(ctx, db, nil, lockout); err != nil {
+			return nil, err
+		}
+		// Spend as long as a real check so usernames cannot be probed by timing
+		checkPassword(dummyHash, password)
+		if err := recordLoginFailure(ctx, db, sender, nil, lockout); err != nil {
+			return nil, err
+		}
+		return nil, models.ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := checkLockout(ctx, db, user, lockout); err != nil {
+		return nil, err
+	}
+	ok, needsRehash := checkPassword(user.Password, password)
+	if !ok {
+		if err := recordLoginFailure(ctx, db, sender, user, lockout); err != nil {
+			return nil, err
+		}
+		return nil, models.ErrInvalidCredentials
+	} else if user.Suspended(time.Now()) {
+		return nil, models.ErrAccountSuspended
+	} else if user.DeletedAt != nil && !restore {
+		return nil, models.ErrAccountDeleted
+	}
+	// Users with two-factor authentication are only cleared by a correct
+	// code, so knowing the password does not allow guessing codes forever
+	if !user.TwoFactorEnabled() {
+		if err := clearLockout(ctx, db, user); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := bson.M{}
+	if needsRehash {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		changes["password"] = hash
+	}
+	for _, admin := range admins {
+		if admin == user.Username && user.Role != models.RoleAdmin {
+			changes["role"] = models.RoleAdmin
+			logging.FromContext(ctx).Info("Promoting configured admin", slog.String("username", user.Username))
+		}
+	}
+	if len(changes) > 0 {
+		if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, changes); err != nil {
+			return nil, err
+		}
+		before := *user
+		if hash, ok := changes["password"].(string); ok {
+			user.Password = hash
+		}
+		if role, ok := changes["role"].(string); ok {
+			user.Role = role
+		}
+		// Done by the server on the user's behalf
+		recordAudit(auth.WithSystem(ctx), db, "user.update", "user", user.ID.Hex(), &before, user, map[string]string{"reason": "login"})
+	}
+
+	return finishLogin(ctx, db, user, ttl, retention, twoFactorRoles)
+}
+
+// finishLogin starts a session lasting ttl for a user who proved who they
+// are, or hands out a challenge if the user enabled two-factor
+// authentication. Users in the trash are restored first, or once they
+// passed the challenge.
+func finishLogin(ctx context.Context, db *mongo.Database, user *models.User, ttl, retention time.Duration, twoFactorRoles []string) (*LoginResult, error) {
+	if user.TwoFactorEnabled() {
+		challenge, err := newToken()
+		if err != nil {
+			return nil, err
+		}
+		token := models.Token{
+			TokenHash: hashToken(challenge),
+			Purpose:   models.TokenLoginChallenge,
+			UserID:    user.ID,
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(ChallengeTTL),
+		}
+		if user.DeletedAt != nil {
+			token.Data = map[string]string{"restore": "true"}
+		}
+		if _, err := models.DbInsertToken(ctx, db, token); err != nil {
+			return nil, err
+		}
+		return &LoginResult{Token: challenge, ExpiresAt: token.ExpiresAt, TwoFactorRequired: true}, nil
+	}
+
+	if err := restoreOnLogin(ctx, db, user, retention); err != nil {
+		return nil, err
+	}
+	token, session, err := startSession(ctx, db, user.ID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{
+		Token:                  token,
+		ExpiresAt:              session.ExpiresAt,
+		TwoFactorSetupRequired: requiresTwoFactor(user, twoFactorRoles),
+	}, nil
+}
+
+// CompleteLogin exchanges the challenge of a login and a TOTP or
+// recovery code for a session lasting ttl, restoring the user if the
+// login asked to. Wrong codes count as failed logins like wrong
+// passwords do.
+func CompleteLogin(ctx context.Context, db *mongo.Database, sender mail.Sender, challenge, code string, ttl, retention time.Duration, lockout config.LockoutConfig) (*LoginResult, error) {
+	// A challenge is good for one attempt, so codes cannot be guessed
+	// without the password
+	token, err := models.DbConsumeToken(ctx, db, hashToken(challenge), models.TokenLoginChallenge)
+	if errors.Is(err, models.ErrInvalidEmailToken) {
+		return nil, models.ErrInvalidChallenge
+	} else if err != nil {
+		return nil, err
+	}
+
+	user, err := findAccount(ctx, db, bson.M{"_id": token.UserID})
+	if errors.Is(err, models.ErrUserNotFound) {
+		return nil, models.ErrInvalidChallenge
+	} else if err != nil {
+		return nil, err
+	} else if user.Suspended(time.Now()) {
+		return nil, models.ErrAccountSuspended
+	} else if user.DeletedAt != nil && token.Data["restore"] != "true" {
+		return nil, models.ErrAccountDeleted
+	}
+
+	if err := checkLockout(ctx, db, user, lockout); err != nil {
+		return nil, err
+	}
+	if err := checkTwoFactor(ctx, db, user, code); errors.Is(err, models.ErrInvalidTwoFactorCode) {
+		if err := recordLoginFailure(ctx, db, sender, user, lockout); err != nil {
+			return nil, err
+		}
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	} else if err := clearLockout(ctx, db, user); err != nil {
+		return nil, err
+	}
+
+	if err := restoreOnLogin(ctx, db, user, retention); err != nil {
+		return nil, err
+	}
+	sessionToken, session, err := startSession(ctx, db, user.ID, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Token: sessionToken, ExpiresAt: session.ExpiresAt}, nil
+}
+
+// startSession stores a new session of the user lasting ttl and returns
+// its bearer token
+func startSession(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, ttl time.Duration) (string, *models.Session, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	session := models.Session{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if _, err := models.DbInsertSession(ctx, db, session); err != nil {
+		return "", nil, err
+	}
+	return token, &session, nil
+}
+
+// dummyHash is compared against when the user does not exist
+var dummyHash, _ = hashPassword("gonews")
+
+// restoreOnLogin takes user out of the trash, if they are in it, before
+// they get a session
+func restoreOnLogin(ctx context.Context, db *mongo.Database, user *models.User, retention time.Duration) error {
+	if user.DeletedAt == nil {
+		return nil
+	}
+	if _, err := RestoreUser(ctx, db, user.Username, retention); err != nil {
+		return err
+	}
+	user.DeletedAt, user.DeletionPolicy = nil, ""
+	return nil
+}
+
+// findAccount returns the user matching filter, including users in the
+// trash. Callers refuse them unless they are restoring the account.
+func findAccount(ctx context.Context, db *mongo.Database, filter bson.M) (*models.User, error) {
+	users, err, count := models.DbQueryUsers(ctx, db, filter)
+	if err == nil && count == 0 {
+		filter["deleted_at"] = inTrash
+		users, err, count = models.DbQueryUsers(ctx, db, filter)
+	}
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, models.ErrUserNotFound
+	}
+	return users[0], nil
+}
+
+// Logout ends the session of the given token
+func Logout(ctx context.Context, db *mongo.Database, token string) error {
+	_, err := models.DbDeleteSessions(ctx, db, bson.M{"token_hash": hashToken(token)})
+	return err
+}
+
+// Authenticate returns the session with the given token and its user.
+// Users whose role is listed in twoFactorRoles act as RoleUser until they
+// enable two-factor authentication.
+func Authenticate(ctx context.Context, db *mongo.Database, token string, twoFactorRoles []string) (*models.User, *models.Session, error) {
+	session, err := models.DbQuerySession(ctx, db, hashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := authenticatedUser(ctx, db, session.UserID, twoFactorRoles)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, session, nil
+}
+
+// withoutPassword returns a copy of user without the password hash and
+// two-factor secrets, for responses
+func withoutPassword(user *models.User) *models.User {
+	redacted := *user
+	redacted.Password, redacted.Lockout = "", nil
+	if user.TwoFactor != nil {
+		redacted.TwoFactor = &models.TwoFactor{EnabledAt: user.TwoFactor.EnabledAt}
+	}
+	return &redacted
+}
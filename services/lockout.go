@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"time"
+
+	"gonews/auth"
+	"gonews/config"
+	"gonews/logging"
+	"gonews/mail"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// accountWait returns how long after its last failure the lockout of an
+// account blocks logins, and whether it locks the account rather than
+// delaying the next attempt
+func accountWait(lockout *models.Lockout, cfg config.LockoutConfig) (time.Duration, bool) {
+	if lockout == nil {
+		return 0, false
+	} else if cfg.Threshold > 0 && lockout.Failures >= cfg.Threshold {
+		return cfg.Duration, true
+	} else if lockout.Failures < cfg.DelayAfter {
+		return 0, false
+	}
+
+	// Double the delay with each failure past DelayAfter
+	wait := cfg.Delay
+	for i := cfg.DelayAfter; i < lockout.Failures && wait < cfg.Duration; i++ {
+		wait *= 2
+	}
+	return min(wait, cfg.Duration), false
+}
+
+// checkLockout refuses to check credentials while recent failed logins
+// from the client IP or, if user is not nil, of the user block them
+func checkLockout(ctx context.Context, db *mongo.Database, user *models.User, cfg config.LockoutConfig) error {
+	now := time.Now()
+	if ip := auth.RequestFromContext(ctx).IP; ip != "" && cfg.IPThreshold > 0 {
+		lockout, err := models.DbQueryIPLockout(ctx, db, ip)
+		if err != nil {
+			return err
+		}
+		if lockout != nil && lockout.Failures >= cfg.IPThreshold {
+			if until := lockout.LastFailedAt.Add(cfg.Duration); until.After(now) {
+				return retryAfter(models.ErrLoginThrottled, until.Sub(now))
+			}
+		}
+	}
+
+	if user == nil {
+		return nil
+	}
+	wait, locked := accountWait(user.Lockout, cfg)
+	if wait == 0 {
+		return nil
+	} else if until := user.Lockout.LastFailedAt.Add(wait); !until.After(now) {
+		return nil
+	} else if locked {
+		return retryAfter(models.ErrAccountLocked, until.Sub(now))
+	} else {
+		return retryAfter(models.ErrLoginThrottled, until.Sub(now))
+	}
+}
+
+// retryAfter returns a copy of err telling the client how long to wait
+// before trying again
+func retryAfter(err *models.Error, wait time.Duration) error {
+	withDetails := *err
+	withDetails.Details = map[string]int{"retry_after": int(math.Ceil(wait.Seconds()))}
+	return &withDetails
+}
+
+// recordLoginFailure counts a failed login from the client IP and, if
+// user is not nil, of the user. The user is emailed when the failure
+// locks their account.
+func recordLoginFailure(ctx context.Context, db *mongo.Database, sender mail.Sender, user *models.User, cfg config.LockoutConfig) error {
+	now := time.Now()
+	ip := auth.RequestFromContext(ctx).IP
+	if ip != "" {
+		lockout, err := models.DbRecordIPLoginFailure(ctx, db, ip, now, cfg.Window, max(cfg.Window, cfg.Duration))
+		if err != nil {
+			return err
+		}
+		if cfg.IPThreshold > 0 && lockout.Failures == cfg.IPThreshold {
+			logging.FromContext(ctx).Warn("Locking out IP after failed logins", slog.String("ip", ip), slog.Int("failures", lockout.Failures))
+		}
+	}
+
+	if user == nil {
+		return nil
+	}
+	lockout, err := models.DbRecordUserLoginFailure(ctx, db, user.ID, now, cfg.Window)
+	if err != nil {
+		return err
+	}
+	if cfg.Threshold > 0 && lockout.Failures == cfg.Threshold {
+		logging.FromContext(ctx).Warn("Locking account after failed logins", slog.String("username", user.Username), slog.String("ip", ip))
+		locked := *user
+		locked.Lockout = lockout
+		recordAudit(auth.WithSystem(ctx), db, "user.lock", "user", user.ID.Hex(), user, &locked,
+			map[string]string{"ip": ip, "failures": strconv.Itoa(lockout.Failures)})
+		notifyLockout(ctx, sender, user, ip, lockout.LastFailedAt.Add(cfg.Duration))
+	}
+	return nil
+}
+
+// notifyLockout emails user that their account was locked until the
+// given time. Failures are only logged, the lockout holds either way.
+func notifyLockout(ctx context.Context, sender mail.Sender, user *models.User, ip string, until time.Time) {
+	if ip == "" {
+		ip = "an unknown address"
+	}
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Suspicious login attempts on your GoNews account",
+		Body: fmt.Sprintf("Hi %s,\n\nthere were several failed attempts to log in to your account, the last one from %s. "+
+			"To protect it, logins are blocked until %s.\n\nIf this was you, wait until then or reset your password with "+
+			"POST /auth/password-reset. If it was not, nobody got in, but consider choosing a stronger password.\n",
+			user.Username, ip, until.UTC().Format(time.RFC1123)),
+	}
+	if err := sender.Send(ctx, msg); err != nil {
+		logging.FromContext(ctx).Error("Error sending email", slog.String("purpose", "lockout"), slog.Any("error", err))
+	}
+}
+
+// clearLockout forgets the failed logins of user after a successful one
+func clearLockout(ctx context.Context, db *mongo.Database, user *models.User) error {
+	if user.Lockout == nil {
+		return nil
+	}
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"lockout": nil}); err != nil {
+		return err
+	}
+	user.Lockout = nil
+	return nil
+}
+
+// UnlockUser forgets the failed logins of the user with the given
+// username, lifting any delay or lockout
+func UnlockUser(ctx context.Context, db *mongo.Database, username string) error {
+	user, err := findAccount(ctx, db, bson.M{"username": username})
+	if err != nil {
+		return err
+	} else if user.Lockout == nil {
+		return models.ErrNotLocked
+	}
+
+	before := *user
+	if err := clearLockout(ctx, db, user); err != nil {
+		return err
+	}
+	recordAudit(ctx, db, "user.unlock", "user", user.ID.Hex(), &before, user, nil)
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gonews/config"
+	"gonews/models"
+)
+
+var testLockout = config.LockoutConfig{
+	DelayAfter: 3,
+	Delay:      time.Second,
+	Threshold:  10,
+	Duration:   15 * time.Minute,
+	Window:     time.Hour,
+}
+
+func TestAccountWait(t *testing.T) {
+	tests := []struct {
+		failures int
+		wait     time.Duration
+		locked   bool
+	}{
+		{0, 0, false},
+		{2, 0, false},
+		{3, time.Second, false},
+		{4, 2 * time.Second, false},
+		{5, 4 * time.Second, false},
+		{9, 64 * time.Second, false},
+		{10, 15 * time.Minute, true},
+		{50, 15 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		wait, locked := accountWait(&models.Lockout{Failures: tt.failures}, testLockout)
+		if wait != tt.wait || locked != tt.locked {
+			t.Errorf("%d failures: %v, %v, want %v, %v", tt.failures, wait, locked, tt.wait, tt.locked)
+		}
+	}
+
+	if wait, locked := accountWait(nil, testLockout); wait != 0 || locked {
+		t.Errorf("no lockout: %v, %v, want 0, false", wait, locked)
+	}
+}
+
+func TestAccountWaitCapsDelay(t *testing.T) {
+	cfg := testLockout
+	cfg.Threshold = 0
+	// Without a threshold the doubling delay stops at Duration and never locks
+	for _, failures := range []int{13, 100, 1 << 20} {
+		wait, locked := accountWait(&models.Lockout{Failures: failures}, cfg)
+		if wait != cfg.Duration || locked {
+			t.Errorf("%d failures: %v, %v, want %v, false", failures, wait, locked, cfg.Duration)
+		}
+	}
+}
+
+func TestCheckLockout(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		lockout    *models.Lockout
+		want       error
+		retryAfter int
+	}{
+		{"no failures", nil, nil, 0},
+		{"below delay", &models.Lockout{Failures: 2, LastFailedAt: now}, nil, 0},
+		{"delayed", &models.Lockout{Failures: 5, LastFailedAt: now}, models.ErrLoginThrottled, 4},
+		{"delay over", &models.Lockout{Failures: 5, LastFailedAt: now.Add(-5 * time.Second)}, nil, 0},
+		{"locked", &models.Lockout{Failures: 10, LastFailedAt: now.Add(-5 * time.Minute)}, models.ErrAccountLocked, 600},
+		{"lock over", &models.Lockout{Failures: 10, LastFailedAt: now.Add(-16 * time.Minute)}, nil, 0},
+	}
+	for _, tt := range tests {
+		// Without a client IP only the account is checked, so no database
+		// is needed
+		err := checkLockout(context.Background(), nil, &models.User{Lockout: tt.lockout}, testLockout)
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		var modelErr *models.Error
+		if !errors.As(err, &modelErr) || modelErr.Code != tt.want.(*models.Error).Code {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if got, ok := modelErr.RetryAfter(); !ok || got < tt.retryAfter-1 || got > tt.retryAfter {
+			t.Errorf("%s: retry_after %d, want %d", tt.name, got, tt.retryAfter)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"gonews/auth"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// indefinitely is the suspension end of suspensions without a duration
+var indefinitely = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// SetPostHidden hides or unhides the post with the given hex ID
+func SetPostHidden(ctx context.Context, db *mongo.Database, id string, hidden bool, reason string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return models.ErrInvalidID
+	}
+
+	modified, err := models.DbSetPostHidden(ctx, db, bson.M{"_id": objectID}, hidden)
+	if err != nil {
+		return err
+	}
+
+	action := "post.hide"
+	if !hidden {
+		action = "post.unhide"
+	}
+	before := bson.M{"hidden": hidden}
+	if modified.(int64) > 0 {
+		before["hidden"] = !hidden
+	}
+	recordAudit(ctx, db, action, "post", id, before, bson.M{"hidden": hidden}, map[string]string{"reason": reason})
+	return nil
+}
+
+// SetTagLocked locks or unlocks the tag with the given name
+func SetTagLocked(ctx context.Context, db *mongo.Database, tag string, locked bool, reason string) error {
+	modified, err := models.DbSetTagLocked(ctx, db, tag, locked)
+	if err != nil {
+		return err
+	}
+
+	action := "tag.lock"
+	if !locked {
+		action = "tag.unlock"
+	}
+	before := bson.M{"locked": locked}
+	if modified.(int64) > 0 {
+		before["locked"] = !locked
+	}
+	recordAudit(ctx, db, action, "tag", tag, before, bson.M{"locked": locked}, map[string]string{"reason": reason})
+	return nil
+}
+
+// SuspendUser suspends the user with the given username for duration, or
+// indefinitely if duration is 0. Only users with RoleUser can be
+// suspended; demote staff first.
+func SuspendUser(ctx context.Context, db *mongo.Database, username string, duration time.Duration, reason string) (*models.User, error) {
+	if duration < 0 {
+		return nil, models.NewValidationError("invalid_duration", "Duration must not be negative",
+			[]models.FieldError{{Field: "duration", Message: "must not be negative"}})
+	}
+
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	} else if user.EffectiveRole() != models.RoleUser {
+		return nil, models.ErrPermissionDenied
+	}
+
+	until := indefinitely
+	if duration > 0 {
+		until = time.Now().Add(duration)
+	}
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"suspended_until": until}); err != nil {
+		return nil, err
+	}
+
+	before := *user
+	user.SuspendedUntil = &until
+	recordAudit(ctx, db, "user.suspend", "user", user.ID.Hex(), &before, user, map[string]string{
+		"username": username,
+		"reason":   reason,
+	})
+
+	return user, nil
+}
+
+// UnsuspendUser lifts the suspension of the user with the given username
+func UnsuspendUser(ctx context.Context, db *mongo.Database, username string) (*models.User, error) {
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"suspended_until": nil}); err != nil {
+		return nil, err
+	}
+
+	before := *user
+	user.SuspendedUntil = nil
+	recordAudit(ctx, db, "user.unsuspend", "user", user.ID.Hex(), &before, user, map[string]string{"username": username})
+
+	return user, nil
+}
+
+// SetRole changes the role of the user with the given username. Admins
+// cannot change their own role, so there is always one left.
+func SetRole(ctx context.Context, db *mongo.Database, username, role string) (*models.User, error) {
+	if !auth.ValidRole(role) {
+		return nil, models.NewValidationError("invalid_role", "Role must be user, moderator or admin",
+			[]models.FieldError{{Field: "role", Message: "must be user, moderator or admin"}})
+	} else if auth.Actor(ctx) == username {
+		return nil, models.ErrPermissionDenied
+	}
+
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"role": role}); err != nil {
+		return nil, err
+	}
+
+	before := *user
+	user.Role = role
+	recordAudit(ctx, db, "user.set_role", "user", user.ID.Hex(), &before, user, map[string]string{"username": username})
+
+	return user, nil
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// notificationLimit bounds how many notifications are returned at once
+const notificationLimit = 100
+
+// ListNotifications returns the latest notifications of the user with the
+// given username, only unread ones if unread is set
+func ListNotifications(ctx context.Context, db *mongo.Database, username string, unread bool) (models.Notifications, error) {
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"user_id": user.ID}
+	if unread {
+		filter["read_at"] = bson.M{"$exists": false}
+	}
+	return models.DbQueryNotifications(ctx, db, filter, notificationLimit)
+}
+
+// MarkNotificationsRead marks every notification of the user with the
+// given username as read and returns how many were unread
+func MarkNotificationsRead(ctx context.Context, db *mongo.Database, username string) (int64, error) {
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return 0, err
+	}
+	return models.DbMarkNotificationsRead(ctx, db, bson.M{"user_id": user.ID}, time.Now())
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gonews/filters"
+	"gonews/models"
+	"gonews/validation"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ListPosts returns all posts
+func ListPosts(ctx context.Context, db *mongo.Database) (models.Posts, int, error) {
+	posts, err, count := models.DbQueryPosts(ctx, db, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+	return posts, count, nil
+}
+
+// ListUserPosts returns all posts written by the given author
+func ListUserPosts(ctx context.Context, db *mongo.Database, username string) (models.Posts, int, error) {
+	posts, err, count := models.DbQueryPosts(ctx, db, bson.M{"author": username})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// An author without posts is only an error if the author does not exist
+	if count == 0 {
+		if _, err := GetUser(ctx, db, username); err != nil {
+			return nil, 0, err
+		}
+		return models.Posts{}, 0, nil
+	}
+	return posts, count, nil
+}
+
+// Limits of ListPostsByTag
+const (
+	DefaultTagPostLimit = 50
+	MaxTagPostLimit     = 100
+)
+
+// TagPage is a page of the posts with a tag
+type TagPage struct {
+	Posts models.Posts
+	// PostCount is the number of posts with the tag, see models.Tag
+	PostCount int64
+	// Next is the hex ID to pass as before for the next page, empty on
+	// the last page
+	Next string
+}
+
+// ListPostsByTag returns up to limit posts with the given hashtag, newest
+// first, along with the tag's post count. before is the hex ID of the
+// last post of the previous page, empty for the first page.
+func ListPostsByTag(ctx context.Context, db *mongo.Database, tag, before string, limit int) (*TagPage, error) {
+	if limit == 0 {
+		limit = DefaultTagPostLimit
+	} else if limit < 0 || limit > MaxTagPostLimit {
+		return nil, models.NewValidationError("invalid_limit", "Limit must be between 1 and 100",
+			[]models.FieldError{{Field: "limit", Message: "must be between 1 and 100"}})
+	}
+	filter := bson.M{"tags": tag}
+	if before != "" {
+		objectID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			return nil, models.NewValidationError("invalid_query", "Invalid query parameters",
+				[]models.FieldError{{Field: "before", Message: "must be a post ID"}})
+		}
+		filter["_id"] = bson.M{"$lt": objectID}
+	}
+
+	tags, err, count := models.DbQueryTags(ctx, db, bson.M{"name": tag})
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return &TagPage{Posts: models.Posts{}}, nil
+	}
+
+	posts, err := models.DbQueryPostPage(ctx, db, filter, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	page := &TagPage{Posts: posts, PostCount: tags[0].PostCount}
+	// A full page may be followed by another
+	if len(posts) == limit {
+		page.Next = posts[len(posts)-1].ID.Hex()
+	}
+	return page, nil
+}
+
+// GetPost returns the post with the given hex ID
+func GetPost(ctx context.Context, db *mongo.Database, id string) (*models.Post, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, models.ErrInvalidID
+	}
+
+	posts, err, count := models.DbQueryPosts(ctx, db, bson.M{"_id": objectID})
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, models.ErrPostNotFound
+	}
+	return posts[0], nil
+}
+
+// CreatePost stores a new post for the given author and links it to
+// every hashtag found in its content. The content first goes through
+// contentFilters, if not nil; posts they flag are held hidden until a
+// moderator reviews them.
+func CreatePost(ctx context.Context, db *mongo.Database, username string, post models.Post, contentFilters *filters.Pipeline) (*models.Post, error) {
+	if err := validation.Struct(post); err != nil {
+		return nil, err
+	}
+	post.CreatedAt, post.UpdatedAt = time.Now(), time.Now()
+	post.Author = username
+
+	post.DeletedAt, post.DeletedWithAuthor, post.Hidden = nil, false, false
+
+	var flags []string
+	if contentFilters != nil {
+		result, err := contentFilters.Run(ctx, filters.Input{Author: username, Content: post.Content})
+		if err != nil {
+			return nil, err
+		}
+		post.Content, flags = result.Content, result.Flags
+		post.Hidden = result.Flagged()
+	}
+	post.ContentHash = filters.ContentHash(post.Content)
+
+	// Parse hashtags from content
+	tags := ParseHashtags(post.Content)
+
+	// Refuse tags locked by a moderator
+	if len(tags) > 0 {
+		_, err, locked := models.DbQueryTags(ctx, db, bson.M{"name": bson.M{"$in": tags}, "locked": true})
+		if err != nil {
+			return nil, err
+		} else if locked > 0 {
+			return nil, models.ErrTagLocked
+		}
+	}
+
+	// Create tags in the database if they don't already exist
+	for _, tag := range tags {
+		if _, err := models.DbInsertTag(ctx, db, tag); err == nil {
+			recordAudit(ctx, db, "tag.create", "tag", tag, nil, bson.M{"name": tag}, nil)
+		} else if !errors.Is(err, models.ErrTagExists) {
+			return nil, err
+		}
+	}
+
+	post.Tags = tags
+
+	id, err := models.DbInsertPost(ctx, db, post)
+	if err != nil {
+		return nil, err
+	}
+	post.ID = id.(primitive.ObjectID)
+
+	// Count the post in every tag before anything else can fail, it is
+	// stored and shows up under them
+	counts := make(map[string]int64, len(tags))
+	for _, tag := range tags {
+		counts[tag] = 1
+	}
+	if err := models.DbIncTagPostCounts(ctx, db, counts); err != nil {
+		return nil, err
+	}
+
+	recordAudit(ctx, db, "post.create", "post", post.ID.Hex(), nil, &post, nil)
+
+	if len(flags) > 0 {
+		// Queued like a report, without a reporter to notify
+		report := models.Report{
+			PostID:    post.ID,
+			Reason:    models.ReasonAutomated,
+			Comment:   strings.Join(flags, "; "),
+			Status:    models.ReportOpen,
+			CreatedAt: time.Now(),
+		}
+		if _, err := models.DbInsertReport(ctx, db, report); err != nil {
+			return nil, fmt.Errorf("queueing flagged post: %w", err)
+		}
+	}
+
+	return &post, nil
+}
+
+// DeletePost moves the post with the given hex ID by the given author to
+// the trash
+func DeletePost(ctx context.Context, db *mongo.Database, username, id string) (interface{}, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, models.ErrInvalidID
+	}
+
+	filter := bson.M{"_id": objectID, "author": username}
+	deletedAt := time.Now()
+	res, err := models.DbDeletePost(ctx, db, filter, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit(ctx, db, "post.delete", "post", id, nil, bson.M{"deleted_at": deletedAt}, map[string]string{"author": username})
+	return res, nil
+}
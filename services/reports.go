@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gonews/auth"
+	"gonews/filters"
+	"gonews/logging"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Moderator actions on reported posts
+const (
+	// ReportDismiss finds no violation and unhides a post hidden by reports
+	// or held by the content filters
+	ReportDismiss = "dismiss"
+	// ReportHide hides the post
+	ReportHide = "hide"
+	// ReportDelete moves the post to the trash
+	ReportDelete = "delete"
+	// ReportWarn notifies the author with the moderator's note
+	ReportWarn = "warn"
+	// ReportSuspend suspends the author
+	ReportSuspend = "suspend"
+)
+
+// Limits of ListReportGroups
+const (
+	DefaultReportLimit = 50
+	MaxReportLimit     = 500
+)
+
+// ReportPost files a report of the post with the given hex ID by the
+// authenticated user. Once threshold users have open reports of the post
+// it is hidden until a moderator reviews it; a threshold of 0 never hides.
+func ReportPost(ctx context.Context, db *mongo.Database, id, reason, comment string, threshold int) (*models.Report, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, models.ErrInvalidID
+	}
+	if !validReason(reason) {
+		return nil, models.NewValidationError("invalid_reason", "Reason must be one of "+strings.Join(models.ReportReasons, ", "),
+			[]models.FieldError{{Field: "reason", Message: "must be one of " + strings.Join(models.ReportReasons, ", ")}})
+	}
+	reporter := auth.UserFromContext(ctx)
+	if reporter == nil {
+		return nil, models.ErrAuthRequired
+	}
+
+	posts, err, count := models.DbQueryPosts(ctx, db, bson.M{"_id": objectID})
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, models.ErrPostNotFound
+	} else if posts[0].Author == reporter.Username {
+		return nil, models.NewValidationError("own_post", "You cannot report your own post", nil)
+	}
+
+	report := models.Report{
+		PostID:     objectID,
+		ReporterID: reporter.ID,
+		Reason:     reason,
+		Comment:    comment,
+		Status:     models.ReportOpen,
+		CreatedAt:  time.Now(),
+	}
+	reportID, err := models.DbInsertReport(ctx, db, report)
+	if err != nil {
+		return nil, err
+	}
+	report.ID = reportID.(primitive.ObjectID)
+
+	if threshold > 0 {
+		open, err := models.DbCountReports(ctx, db, bson.M{"post_id": objectID, "status": models.ReportOpen})
+		if err != nil {
+			return nil, err
+		}
+		if open >= int64(threshold) {
+			// Hidden by the server, not by the reporter who tipped it over
+			systemCtx := auth.WithSystem(auth.WithUser(ctx, nil))
+			if err := SetPostHidden(systemCtx, db, id, true, "report threshold reached"); err != nil {
+				return nil, fmt.Errorf("hiding reported post: %w", err)
+			}
+		}
+	}
+
+	return &report, nil
+}
+
+func validReason(reason string) bool {
+	for _, valid := range models.ReportReasons {
+		if reason == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// getAnyPost returns the post with the given ID whether hidden or not
+func getAnyPost(ctx context.Context, db *mongo.Database, id primitive.ObjectID) (*models.Post, error) {
+	posts, err, count := models.DbQueryPosts(ctx, db, bson.M{"_id": id, "hidden": models.AnyVisibility})
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, models.ErrPostNotFound
+	}
+	return posts[0], nil
+}
+
+// ListReportGroups returns up to limit reported posts with the given
+// report status, open or resolved, most reported first
+func ListReportGroups(ctx context.Context, db *mongo.Database, status string, limit int) ([]models.ReportGroup, error) {
+	if status == "" {
+		status = models.ReportOpen
+	} else if status != models.ReportOpen && status != models.ReportResolved {
+		return nil, models.NewValidationError("invalid_status", "Status must be open or resolved",
+			[]models.FieldError{{Field: "status", Message: "must be open or resolved"}})
+	}
+	if limit == 0 {
+		limit = DefaultReportLimit
+	} else if limit < 0 || limit > MaxReportLimit {
+		return nil, models.NewValidationError("invalid_limit", "Limit must be between 1 and 500",
+			[]models.FieldError{{Field: "limit", Message: "must be between 1 and 500"}})
+	}
+
+	groups, err := models.DbQueryReportGroups(ctx, db, bson.M{"status": status}, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, len(groups))
+	for i, group := range groups {
+		ids[i] = group.PostID
+	}
+	filter := bson.M{"_id": bson.M{"$in": ids}, "hidden": models.AnyVisibility}
+	posts, err, _ := models.DbQueryPosts(ctx, db, filter)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[primitive.ObjectID]*models.Post{}
+	for _, post := range posts {
+		byID[post.ID] = post
+	}
+	for i := range groups {
+		groups[i].Post = byID[groups[i].PostID]
+	}
+
+	return groups, nil
+}
+
+// ResolveReports takes action on the post with the given hex ID and
+// resolves its open reports, notifying every reporter. duration only
+// applies to ReportSuspend, 0 suspending indefinitely. It returns the
+// number of reports resolved.
+func ResolveReports(ctx context.Context, db *mongo.Database, id, action, note string, duration time.Duration) (int64, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return 0, models.ErrInvalidID
+	}
+
+	reports, err := models.DbQueryReports(ctx, db, bson.M{"post_id": objectID, "status": models.ReportOpen})
+	if err != nil {
+		return 0, err
+	} else if len(reports) == 0 {
+		return 0, models.ErrReportsNotFound
+	}
+
+	post, err := getAnyPost(ctx, db, objectID)
+	if err != nil && (action != ReportDismiss || !errors.Is(err, models.ErrPostNotFound)) {
+		return 0, err
+	}
+
+	switch action {
+	case ReportDismiss:
+		if post != nil && post.Hidden {
+			err = SetPostHidden(ctx, db, id, false, note)
+		}
+	case ReportHide:
+		err = SetPostHidden(ctx, db, id, true, note)
+	case ReportDelete:
+		_, err = DeletePost(ctx, db, post.Author, id)
+	case ReportWarn:
+		err = warnAuthor(ctx, db, post, note)
+	case ReportSuspend:
+		if err = auth.Require(ctx, auth.SuspendUsers); err == nil {
+			_, err = SuspendUser(ctx, db, post.Author, duration, note)
+		}
+	default:
+		return 0, models.NewValidationError("invalid_action", "Action must be dismiss, hide, delete, warn or suspend",
+			[]models.FieldError{{Field: "action", Message: "must be dismiss, hide, delete, warn or suspend"}})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// Teach the spam classifier which content moderators keep and which
+	// they remove as spam. Posts removed for other reasons are not spam.
+	reportedSpam := false
+	for _, report := range reports {
+		if report.Reason == models.ReasonSpam {
+			reportedSpam = true
+		}
+	}
+	removed := action == ReportHide || action == ReportDelete
+	if post != nil && (action == ReportDismiss || removed && reportedSpam) {
+		if err := filters.Train(ctx, db, post.ID, post.Content, removed); err != nil {
+			logging.FromContext(ctx).Error("Error training spam classifier", slog.String("post_id", id), slog.Any("error", err))
+		}
+	}
+
+	resolved, err := models.DbResolveReports(ctx, db, bson.M{"post_id": objectID}, action, auth.Actor(ctx), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	message := "Thank you for your report. A moderator reviewed the post and took action."
+	if action == ReportDismiss {
+		message = "Thank you for your report. A moderator reviewed the post and found no violation."
+	}
+	// Reports by the content filters have no reporter
+	notified := map[primitive.ObjectID]bool{primitive.NilObjectID: true}
+	var notifications []models.Notification
+	for _, report := range reports {
+		if notified[report.ReporterID] {
+			continue
+		}
+		notified[report.ReporterID] = true
+		notifications = append(notifications, models.Notification{
+			UserID:  report.ReporterID,
+			Kind:    models.NotificationReportResolved,
+			Message: message,
+			PostID:  &objectID,
+		})
+	}
+	if err := models.DbInsertNotifications(ctx, db, notifications); err != nil {
+		return 0, err
+	}
+
+	recordAudit(ctx, db, "post.resolve_reports", "post", id, nil, nil, map[string]string{
+		"action":  action,
+		"reports": fmt.Sprint(resolved),
+		"note":    note,
+	})
+	return resolved, nil
+}
+
+// warnAuthor notifies the author of post that it breaks the rules
+func warnAuthor(ctx context.Context, db *mongo.Database, post *models.Post, note string) error {
+	if note == "" {
+		return models.NewValidationError("note_required", "A warning needs a note for the author",
+			[]models.FieldError{{Field: "note", Message: "is required to warn the author"}})
+	}
+	author, err := GetUser(ctx, db, post.Author)
+	if err != nil {
+		return err
+	}
+	return models.DbInsertNotifications(ctx, db, []models.Notification{{
+		UserID:  author.ID,
+		Kind:    models.NotificationWarning,
+		Message: note,
+		PostID:  &post.ID,
+	}})
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"gonews/logging"
+	"gonews/models"
+	"gonews/oidc"
+	"gonews/validation"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxUsernameLength bounds the usernames given to provisioned users
+const maxUsernameLength = 30
+
+// StartOIDCLogin returns the URL at the provider with the given name to
+// send the user to for signing in, which has to happen within stateTTL
+func StartOIDCLogin(ctx context.Context, db *mongo.Database, providers oidc.Providers, name string, stateTTL time.Duration) (string, error) {
+	provider, ok := providers[name]
+	if !ok {
+		return "", models.ErrNoSuchProvider
+	}
+
+	state, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		logging.FromContext(ctx).Error("Error contacting OIDC provider", slog.String("provider", name), slog.Any("error", err))
+		return "", models.ErrOIDCUnavailable
+	}
+
+	token := models.Token{
+		TokenHash: hashToken(state),
+		Purpose:   models.TokenOIDCState,
+		Data:      map[string]string{"provider": name, "nonce": nonce, "verifier": verifier},
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(stateTTL),
+	}
+	if _, err := models.DbInsertToken(ctx, db, token); err != nil {
+		return "", err
+	}
+	return authURL, nil
+}
+
+// CompleteOIDCLogin handles the redirect back from the provider with the
+// given name. The user is found by their identity at the provider, linked
+// by their verified email or else created, and then logged in as by
+// Login.
+func CompleteOIDCLogin(ctx context.Context, db *mongo.Database, providers oidc.Providers, name, state, code string, ttl time.Duration, twoFactorRoles []string) (*LoginResult, error) {
+	provider, ok := providers[name]
+	if !ok {
+		return nil, models.ErrNoSuchProvider
+	}
+
+	token, err := models.DbConsumeToken(ctx, db, hashToken(state), models.TokenOIDCState)
+	if errors.Is(err, models.ErrInvalidEmailToken) {
+		return nil, models.ErrInvalidOIDCState
+	} else if err != nil {
+		return nil, err
+	} else if token.Data["provider"] != name {
+		return nil, models.ErrInvalidOIDCState
+	}
+
+	claims, err := verifyOIDCCode(ctx, provider, code, token.Data["verifier"], token.Data["nonce"])
+	if err != nil {
+		logging.FromContext(ctx).Warn("OIDC sign-in failed", slog.String("provider", name), slog.Any("error", err))
+		return nil, models.ErrOIDCFailed
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, models.ErrOIDCEmailUnverified
+	}
+
+	user, err := oidcUser(ctx, db, name, claims)
+	if err != nil {
+		return nil, err
+	} else if user.Suspended(time.Now()) {
+		return nil, models.ErrAccountSuspended
+	} else if user.DeletedAt != nil {
+		// Restored with a password login, never by the provider
+		return nil, models.ErrAccountDeleted
+	}
+	return finishLogin(ctx, db, user, ttl, 0, twoFactorRoles)
+}
+
+// verifyOIDCCode exchanges code for an ID token and returns its verified
+// claims
+func verifyOIDCCode(ctx context.Context, provider *oidc.Provider, code, verifier, nonce string) (*oidc.Claims, error) {
+	idToken, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Verify(ctx, idToken, nonce)
+}
+
+// oidcUser returns the user signing in with claims at the provider with
+// the given name, linking or creating them as needed
+func oidcUser(ctx context.Context, db *mongo.Database, name string, claims *oidc.Claims) (*models.User, error) {
+	identity := bson.M{"$elemMatch": bson.M{"provider": name, "subject": claims.Subject}}
+	user, err := findAccount(ctx, db, bson.M{"identities": identity})
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return user, err
+	}
+
+	link := models.Identity{Provider: name, Subject: claims.Subject, LinkedAt: time.Now()}
+
+	// Emails are stored as typed, the provider may spell them differently
+	email := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(claims.Email) + "$", Options: "i"}
+	users, err, _ := models.DbQueryUsers(ctx, db, bson.M{"email": email})
+	if err != nil {
+		return nil, err
+	}
+	user, err = oidcLinkTarget(users, claims.Email)
+	if err != nil {
+		return nil, err
+	} else if user == nil {
+		return provisionOIDCUser(ctx, db, name, claims, link)
+	}
+
+	before := *user
+	user.Identities = append(user.Identities, link)
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"identities": user.Identities}); err != nil {
+		return nil, err
+	}
+	recordAudit(ctx, db, "user.link_identity", "user", user.ID.Hex(), &before, user, map[string]string{"provider": name})
+	return user, nil
+}
+
+// oidcLinkTarget picks the user among users to link a sign-in with email
+// to. Only an account whose owner proved they own the email is linked,
+// otherwise anyone could sign up with it first and take over the sign-in.
+// It returns nil when nobody uses the email, so a user is provisioned.
+func oidcLinkTarget(users models.Users, email string) (*models.User, error) {
+	var verified, matching models.Users
+	for _, u := range users {
+		if !strings.EqualFold(u.Email, email) {
+			continue
+		}
+		matching = append(matching, u)
+		if u.EmailVerifiedAt != nil {
+			verified = append(verified, u)
+		}
+	}
+	if len(verified) == 1 {
+		return verified[0], nil
+	} else if len(matching) > 0 {
+		return nil, models.ErrOIDCEmailInUse
+	}
+	return nil, nil
+}
+
+// provisionOIDCUser creates a user for a first sign-in with a provider.
+// The user has a random password, which they can reset to log in without
+// the provider.
+func provisionOIDCUser(ctx context.Context, db *mongo.Database, name string, claims *oidc.Claims, link models.Identity) (*models.User, error) {
+	user, err := newOIDCUser(claims, link)
+	if err != nil {
+		return nil, err
+	}
+	base := user.Username
+	for attempt := 0; ; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix, err := randomSuffix()
+			if err != nil {
+				return nil, err
+			}
+			user.Username = base[:min(len(base), maxUsernameLength-len(suffix))] + suffix
+		}
+		id, err := models.DbInsertUser(ctx, db, *user)
+		if errors.Is(err, models.ErrUsernameTaken) && attempt < 5 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		user.ID = id.(primitive.ObjectID)
+		break
+	}
+
+	recordAudit(ctx, db, "user.create", "user", user.ID.Hex(), nil, user, map[string]string{"provider": name})
+	return user, nil
+}
+
+// newOIDCUser returns the user to provision for claims, with the verified
+// email of the provider and a random password
+func newOIDCUser(claims *oidc.Claims, link models.Identity) (*models.User, error) {
+	password, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &models.User{
+		Username:        oidcUsername(claims),
+		Email:           claims.Email,
+		Password:        hash,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		EmailVerifiedAt: &now,
+		Identities:      []models.Identity{link},
+	}, nil
+}
+
+// oidcUsername derives a valid username from the preferred username or
+// email of claims
+func oidcUsername(claims *oidc.Claims) string {
+	source := claims.PreferredUsername
+	if source == "" || strings.Contains(source, "@") {
+		source, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(source) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-' {
+			b.WriteRune(r)
+		}
+	}
+	username := strings.TrimLeft(b.String(), "_.-")
+	if len(username) > maxUsernameLength {
+		username = username[:maxUsernameLength]
+	}
+	if len(validation.Field(models.User{}, "Username", username)) > 0 {
+		username = "user"
+	}
+	return username
+}
+
+// randomSuffix returns a short random suffix for a taken username
+func randomSuffix() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating username suffix: %w", err)
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
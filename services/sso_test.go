@@ -0,0 +1,77 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gonews/models"
+	"gonews/oidc"
+)
+
+func TestOIDCLinkTarget(t *testing.T) {
+	verifiedAt := time.Now()
+	verified := &models.User{Username: "alice", Email: "Alice@Example.com", EmailVerifiedAt: &verifiedAt}
+	unverified := &models.User{Username: "mallory", Email: "alice@example.com"}
+	other := &models.User{Username: "bob", Email: "bob@example.com", EmailVerifiedAt: &verifiedAt}
+
+	tests := []struct {
+		name  string
+		users models.Users
+		want  *models.User
+		err   error
+	}{
+		{"verified email, in another case", models.Users{verified}, verified, nil},
+		{"verified email next to an unverified one", models.Users{unverified, verified}, verified, nil},
+		{"only an unverified email", models.Users{unverified}, nil, models.ErrOIDCEmailInUse},
+		{"nobody uses the email", nil, nil, nil},
+		{"only other emails", models.Users{other}, nil, nil},
+	}
+	for _, tt := range tests {
+		user, err := oidcLinkTarget(tt.users, "alice@example.COM")
+		if user != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %v, %v, want %v, %v", tt.name, user, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestNewOIDCUser(t *testing.T) {
+	claims := &oidc.Claims{Subject: "123", Email: "Jane.Doe@example.com", PreferredUsername: "Jane Doe!"}
+	link := models.Identity{Provider: "corp", Subject: "123", LinkedAt: time.Now()}
+
+	user, err := newOIDCUser(claims, link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "janedoe" {
+		t.Errorf("username %q, want janedoe", user.Username)
+	}
+	if user.Email != claims.Email || user.EmailVerifiedAt == nil {
+		t.Errorf("email %q verified at %v, want the provider's verified email", user.Email, user.EmailVerifiedAt)
+	}
+	if len(user.Identities) != 1 || user.Identities[0] != link {
+		t.Errorf("identities %v, want %v", user.Identities, link)
+	}
+	if user.Password == "" || user.Role != "" {
+		t.Errorf("password %q, role %q, want a random password and the default role", user.Password, user.Role)
+	}
+}
+
+func TestOIDCUsername(t *testing.T) {
+	tests := []struct {
+		claims oidc.Claims
+		want   string
+	}{
+		{oidc.Claims{PreferredUsername: "jdoe"}, "jdoe"},
+		{oidc.Claims{PreferredUsername: "jane@example.com", Email: "jane.doe@example.com"}, "jane.doe"},
+		{oidc.Claims{Email: "__jane@example.com"}, "jane"},
+		{oidc.Claims{PreferredUsername: "ünïcödé"}, "ncd"},
+		{oidc.Claims{PreferredUsername: "!!!"}, "user"},
+		{oidc.Claims{PreferredUsername: "a123456789012345678901234567890123"}, "a12345678901234567890123456789"},
+	}
+	for _, tt := range tests {
+		if got := oidcUsername(&tt.claims); got != tt.want {
+			t.Errorf("oidcUsername(%+v) = %q, want %q", tt.claims, got, tt.want)
+		}
+	}
+}
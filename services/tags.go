@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"gonews/logging"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JobMigrateTags is the job type handled by RunTagMigration
+const JobMigrateTags = "migrate_tags"
+
+// tagMigrationJobID is the ID of the only tag migration job, so every
+// instance enqueuing it at startup shares one job
+var tagMigrationJobID = primitive.ObjectID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+// migrationBatchSize bounds how many tags each round of the tag
+// migration loads at once
+const migrationBatchSize = 500
+
+// EnqueueTagMigration starts the job that moves tags listing their posts
+// to post counts, unless it was started before
+func EnqueueTagMigration(ctx context.Context, db *mongo.Database) error {
+	job := models.Job{ID: tagMigrationJobID, Type: JobMigrateTags}
+	if _, err := models.DbInsertJob(ctx, db, job); err != nil && !errors.Is(err, models.ErrJobExists) {
+		return err
+	}
+	return nil
+}
+
+// RunTagMigration replaces the posts array of every tag by its length,
+// added to the posts counted since. Posts are found through their own
+// tags, which they always had, so nothing else changes. Migrated tags
+// are skipped, so it can be repeated safely.
+func RunTagMigration(ctx context.Context, db *mongo.Database, job *models.Job) error {
+	migrated := 0
+	for {
+		names, err := models.DbQueryLegacyTags(ctx, db, migrationBatchSize)
+		if err != nil {
+			return err
+		} else if len(names) == 0 {
+			break
+		}
+		for _, name := range names {
+			if err := models.DbMigrateTag(ctx, db, name); err != nil {
+				return err
+			}
+		}
+		migrated += len(names)
+	}
+
+	logging.FromContext(ctx).Info("Migrated tags to post counts", slog.Int("count", migrated))
+	return nil
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"gonews/logging"
+	"gonews/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// inTrash selects documents that are in the trash
+var inTrash = bson.M{"$exists": true}
+
+// RestoreUser takes the user with the given username and the posts
+// trashed with them out of the trash, if they were deleted less than
+// retention ago
+func RestoreUser(ctx context.Context, db *mongo.Database, username string, retention time.Duration) (*models.User, error) {
+	users, err, count := models.DbQueryUsers(ctx, db, bson.M{"username": username, "deleted_at": inTrash})
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, models.ErrUserNotFound
+	}
+	user := users[0]
+
+	cutoff := time.Now().Add(-retention)
+	if user.PendingDeletion || user.DeletedAt.Before(cutoff) {
+		return nil, models.ErrRestoreExpired
+	}
+
+	// Restore the posts first, a retry after a failure still finds the user in the trash
+	if _, err := models.DbRestorePosts(ctx, db, bson.M{"author": user.Username, "deleted_with_author": true}); err != nil {
+		return nil, err
+	}
+
+	// The purger may have claimed the user in the meantime
+	filter := bson.M{"_id": user.ID, "deleted_at": bson.M{"$gte": cutoff}, "pending_deletion": bson.M{"$ne": true}}
+	if _, err := models.DbRestoreUser(ctx, db, filter); err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, models.ErrRestoreExpired
+		}
+		return nil, err
+	}
+
+	before := *user
+	user.DeletedAt, user.DeletionPolicy = nil, ""
+	recordAudit(ctx, db, "user.restore", "user", user.ID.Hex(), &before, user, nil)
+
+	return withoutPassword(user), nil
+}
+
+// RestorePost takes the post with the given hex ID by the given author
+// out of the trash, if it was deleted less than retention ago
+func RestorePost(ctx context.Context, db *mongo.Database, username, id string, retention time.Duration) (*models.Post, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, models.ErrInvalidID
+	}
+
+	posts, err, count := models.DbQueryPosts(ctx, db, bson.M{"_id": objectID, "author": username, "deleted_at": inTrash})
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, models.ErrPostNotFound
+	}
+	post := posts[0]
+
+	cutoff := time.Now().Add(-retention)
+	if post.DeletedWithAuthor {
+		return nil, models.ErrAuthorDeleted
+	} else if post.DeletedAt.Before(cutoff) {
+		return nil, models.ErrRestoreExpired
+	}
+
+	filter := bson.M{"_id": objectID, "deleted_at": bson.M{"$gte": cutoff}, "deleted_with_author": bson.M{"$ne": true}}
+	if restored, err := models.DbRestorePosts(ctx, db, filter); err != nil {
+		return nil, err
+	} else if restored.(int64) == 0 {
+		return nil, models.ErrRestoreExpired
+	}
+
+	before := *post
+	post.DeletedAt = nil
+	recordAudit(ctx, db, "post.restore", "post", id, &before, post, nil)
+
+	return post, nil
+}
+
+// PurgeTrash permanently deletes posts deleted more than retention ago,
+// lowering the post counts of their tags, and starts deletion jobs for users
+// deleted more than retention ago
+func PurgeTrash(ctx context.Context, db *mongo.Database, retention time.Duration) error {
+	logger := logging.FromContext(ctx)
+	cutoff := time.Now().Add(-retention)
+
+	// Posts trashed with their author are handled by the author's deletion job
+	expired := bson.M{"deleted_at": bson.M{"$lt": cutoff}, "deleted_with_author": bson.M{"$ne": true}}
+	if err := purgePosts(ctx, db, expired); err != nil {
+		return err
+	}
+
+	users, err, _ := models.DbQueryUsers(ctx, db, bson.M{"deleted_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := enqueueUserDeletion(ctx, db, user); err != nil {
+			return err
+		}
+		if !user.PendingDeletion {
+			logger.Info("Purging user", slog.String("username", user.Username))
+		}
+	}
+
+	return nil
+}
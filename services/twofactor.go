@@ -0,0 +1,208 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"gonews/models"
+	"gonews/totp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Issuer names the service in authenticator apps
+const Issuer = "GoNews"
+
+// RecoveryCodeCount is how many recovery codes a user gets at once
+const RecoveryCodeCount = 10
+
+// TwoFactorEnrollment is a new TOTP secret waiting for confirmation
+type TwoFactorEnrollment struct {
+	Secret string
+	// URI is the otpauth:// URI of Secret, usually shown as a QR code
+	URI string
+}
+
+// requiresTwoFactor reports whether the role of user is listed in
+// twoFactorRoles
+func requiresTwoFactor(user *models.User, twoFactorRoles []string) bool {
+	for _, role := range twoFactorRoles {
+		if role == user.EffectiveRole() {
+			return true
+		}
+	}
+	return false
+}
+
+// EnrollTwoFactor gives the user with the given username a new TOTP
+// secret, which takes effect once ConfirmTwoFactor accepts a code of it
+func EnrollTwoFactor(ctx context.Context, db *mongo.Database, username string) (*TwoFactorEnrollment, error) {
+	user, err := findAccount(ctx, db, bson.M{"username": username})
+	if err != nil {
+		return nil, err
+	} else if user.TwoFactorEnabled() {
+		return nil, models.ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"two_factor": models.TwoFactor{Secret: secret}}); err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrollment{Secret: secret, URI: totp.URI(Issuer, user.Username, secret)}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the user with
+// the given username once code matches their new secret, and returns
+// their recovery codes
+func ConfirmTwoFactor(ctx context.Context, db *mongo.Database, username, code string) ([]string, error) {
+	user, err := findAccount(ctx, db, bson.M{"username": username})
+	if err != nil {
+		return nil, err
+	} else if user.TwoFactorEnabled() {
+		return nil, models.ErrTwoFactorEnabled
+	} else if user.TwoFactor == nil {
+		return nil, models.ErrNotEnrolling
+	}
+
+	if err := checkTOTP(ctx, db, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	changes := bson.M{"two_factor.enabled_at": now, "two_factor.recovery_codes": hashes}
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, changes); err != nil {
+		return nil, err
+	}
+
+	before := *user
+	user.TwoFactor = &models.TwoFactor{Secret: user.TwoFactor.Secret, EnabledAt: &now, RecoveryCodes: hashes}
+	recordAudit(ctx, db, "user.enable_two_factor", "user", user.ID.Hex(), &before, user, nil)
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off for the user with
+// the given username after checking a current TOTP or recovery code.
+// Users whose role is listed in twoFactorRoles cannot turn it off.
+func DisableTwoFactor(ctx context.Context, db *mongo.Database, username, code string, twoFactorRoles []string) error {
+	user, err := findAccount(ctx, db, bson.M{"username": username})
+	if err != nil {
+		return err
+	} else if !user.TwoFactorEnabled() {
+		return models.ErrTwoFactorOff
+	} else if requiresTwoFactor(user, twoFactorRoles) {
+		return models.ErrTwoFactorRequired
+	}
+
+	if err := checkTwoFactor(ctx, db, user, code); err != nil {
+		return err
+	}
+	return unsetTwoFactor(ctx, db, user, "user.disable_two_factor")
+}
+
+// ResetTwoFactor turns two-factor authentication off for the user with
+// the given username without a code, for users who lost both their
+// authenticator and their recovery codes
+func ResetTwoFactor(ctx context.Context, db *mongo.Database, username string) error {
+	user, err := findAccount(ctx, db, bson.M{"username": username})
+	if err != nil {
+		return err
+	} else if user.TwoFactor == nil {
+		return models.ErrTwoFactorOff
+	}
+	return unsetTwoFactor(ctx, db, user, "user.reset_two_factor")
+}
+
+// unsetTwoFactor removes the two-factor secret of user, recording action
+func unsetTwoFactor(ctx context.Context, db *mongo.Database, user *models.User, action string) error {
+	if _, err := models.DbUnsetTwoFactor(ctx, db, bson.M{"_id": user.ID}); err != nil {
+		return err
+	}
+
+	before := *user
+	user.TwoFactor = nil
+	recordAudit(ctx, db, action, "user", user.ID.Hex(), &before, user, nil)
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user with
+// the given username after checking a current TOTP or recovery code
+func RegenerateRecoveryCodes(ctx context.Context, db *mongo.Database, username, code string) ([]string, error) {
+	user, err := findAccount(ctx, db, bson.M{"username": username})
+	if err != nil {
+		return nil, err
+	} else if !user.TwoFactorEnabled() {
+		return nil, models.ErrTwoFactorOff
+	}
+
+	if err := checkTwoFactor(ctx, db, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"two_factor.recovery_codes": hashes}); err != nil {
+		return nil, err
+	}
+
+	recordAudit(ctx, db, "user.regenerate_recovery_codes", "user", user.ID.Hex(), nil, nil, nil)
+	return codes, nil
+}
+
+// checkTwoFactor accepts a TOTP code or, failing that, one of the
+// user's recovery codes, which is then used up
+func checkTwoFactor(ctx context.Context, db *mongo.Database, user *models.User, code string) error {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == totp.Digits {
+		return checkTOTP(ctx, db, user, code)
+	}
+	return models.DbUseRecoveryCode(ctx, db, user.ID, hashToken(normalizeRecoveryCode(code)))
+}
+
+// checkTOTP accepts a TOTP code of the user's secret that was not used
+// before
+func checkTOTP(ctx context.Context, db *mongo.Database, user *models.User, code string) error {
+	step, ok := totp.Validate(user.TwoFactor.Secret, strings.ReplaceAll(code, " ", ""), time.Now())
+	if !ok || step <= user.TwoFactor.LastStep {
+		return models.ErrInvalidTwoFactorCode
+	}
+	return models.DbUseTwoFactorStep(ctx, db, user.ID, step)
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes returns RecoveryCodeCount random codes such as
+// abcd-efgh-ijkl and their hashes, as stored
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, fmt.Errorf("generating recovery code: %w", err)
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))[:12]
+		codes[i] = raw[:4] + "-" + raw[4:8] + "-" + raw[8:]
+		hashes[i] = hashToken(raw)
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops the dashes and case of a recovery code
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gonews/models"
+	"gonews/totp"
+)
+
+func TestCheckTOTPRejectsReplayedSteps(t *testing.T) {
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := totp.Step(time.Now())
+	user := &models.User{TwoFactor: &models.TwoFactor{Secret: secret, LastStep: current}}
+
+	// Codes of the last accepted step and earlier ones are refused before
+	// the database is asked
+	for _, step := range []int64{current, current - 1} {
+		code, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkTOTP(context.Background(), nil, user, code); !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+			t.Errorf("code of step %d: %v, want ErrInvalidTwoFactorCode", step-current, err)
+		}
+	}
+}
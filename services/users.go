@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"gonews/auth"
+	"gonews/logging"
+	"gonews/mail"
+	"gonews/models"
+	"gonews/validation"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ListUsers returns all users
+func ListUsers(ctx context.Context, db *mongo.Database) (models.Users, int, error) {
+	users, err, count := models.DbQueryUsers(ctx, db, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range users {
+		users[i] = withoutPassword(users[i])
+	}
+	return users, count, nil
+}
+
+// GetUser returns the user with the given username
+func GetUser(ctx context.Context, db *mongo.Database, username string) (*models.User, error) {
+	user, err := getUser(ctx, db, username)
+	if err != nil {
+		return nil, err
+	}
+	return withoutPassword(user), nil
+}
+
+// getUser returns the user with the given username, including their
+// password hash
+func getUser(ctx context.Context, db *mongo.Database, username string) (*models.User, error) {
+	users, err, count := models.DbQueryUsers(ctx, db, bson.M{"username": username})
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, models.ErrUserNotFound
+	}
+	return users[0], nil
+}
+
+// CreateUser stores a new user and returns it with its generated ID. The
+// user is emailed a verification token valid for verificationTTL.
+func CreateUser(ctx context.Context, db *mongo.Database, user models.User, sender mail.Sender, verificationTTL time.Duration) (*models.User, error) {
+	if err := validation.Struct(user); err != nil {
+		return nil, err
+	}
+	user.DeletedAt, user.DeletionPolicy, user.PendingDeletion, user.RenamedFrom = nil, "", false, ""
+	user.Role, user.SuspendedUntil, user.EmailVerifiedAt, user.TwoFactor, user.Identities, user.Lockout = "", nil, nil, nil, nil, nil
+
+	hash, err := hashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hash
+	user.CreatedAt, user.UpdatedAt = time.Now(), time.Now()
+
+	id, err := models.DbInsertUser(ctx, db, user)
+	if err != nil {
+		return nil, err
+	}
+	user.ID = id.(primitive.ObjectID)
+
+	recordAudit(ctx, db, "user.create", "user", user.ID.Hex(), nil, &user, nil)
+
+	// The account works without verification, the user can ask for a new token
+	if err := sendToken(ctx, db, sender, &user, models.TokenVerifyEmail, verificationTTL); err != nil {
+		logging.FromContext(ctx).Warn("Verification email not sent", slog.String("username", user.Username), slog.Any("error", err))
+	}
+
+	return withoutPassword(&user), nil
+}
+
+// mutableUserFields lists the fields clients may change, keyed by their
+// lowercase patch key. New values must pass the binding tags of the
+// named models.User field.
+var mutableUserFields = map[string]struct {
+	bsonName string
+	name     string
+	field    func(*models.User) *string
+}{
+	"username": {"username", "Username", func(u *models.User) *string { return &u.Username }},
+	"email":    {"email", "Email", func(u *models.User) *string { return &u.Email }},
+	"password": {"password", "Password", func(u *models.User) *string { return &u.Password }},
+}
+
+// PatchUser applies a JSON merge patch (RFC 7396) to the user with the
+// given username. Only the fields in mutableUserFields may be patched and
+// none of them may be removed. It reports whether anything changed.
+// Renaming a user also moves their posts to the new username, and a new
+// email address must be verified again with a token valid for
+// verificationTTL. Changing the password or email requires a session.
+func PatchUser(ctx context.Context, db *mongo.Database, username string, patch map[string]interface{}, sender mail.Sender, verificationTTL time.Duration) (*models.User, bool, error) {
+	user, err := getUser(ctx, db, username)
+	if err != nil {
+		return nil, false, err
+	} else if user.PendingDeletion {
+		return nil, false, models.ErrDeletionPending
+	}
+	// Finish an earlier rename first, its posts would be left behind by
+	// another one
+	if err := finishRename(ctx, db, user); err != nil {
+		return nil, false, err
+	}
+	before := *user
+
+	// Keys match case-insensitively, so two of them could set one field
+	keyCounts := map[string]int{}
+	for key := range patch {
+		keyCounts[strings.ToLower(key)]++
+	}
+
+	changes := bson.M{}
+	var fieldErrs []models.FieldError
+	for key, value := range patch {
+		mutable, ok := mutableUserFields[strings.ToLower(key)]
+		if !ok {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: key, Message: "is not a mutable field"})
+			continue
+		}
+		if keyCounts[strings.ToLower(key)] > 1 {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: key, Message: "is given more than once"})
+			continue
+		}
+		if value == nil {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: key, Message: "cannot be removed"})
+			continue
+		}
+		s, ok := value.(string)
+		if !ok {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: key, Message: "expected string"})
+			continue
+		}
+		if mutable.bsonName == "password" {
+			// The stored hash never equals the new password, compare them instead
+			if ok, needsRehash := checkPassword(user.Password, s); ok && !needsRehash {
+				continue
+			}
+			if errs := validatePatch(key, mutable.name, s); len(errs) > 0 {
+				fieldErrs = append(fieldErrs, errs...)
+				continue
+			}
+			hash, err := hashPassword(s)
+			if err != nil {
+				return nil, false, err
+			}
+			user.Password = hash
+			changes["password"] = hash
+			continue
+		}
+		if field := mutable.field(user); *field != s {
+			if errs := validatePatch(key, mutable.name, s); len(errs) > 0 {
+				fieldErrs = append(fieldErrs, errs...)
+				continue
+			}
+			*field = s
+			changes[mutable.bsonName] = s
+		}
+	}
+	if len(fieldErrs) > 0 {
+		sort.Slice(fieldErrs, func(i, j int) bool { return fieldErrs[i].Field < fieldErrs[j].Field })
+		return nil, false, models.NewValidationError("invalid_patch", "Invalid user patch", fieldErrs)
+	}
+
+	if len(changes) == 0 {
+		return withoutPassword(user), false, nil
+	}
+	// Credentials are only changed with a session, an API key with
+	// users:write cannot take over the account
+	_, passwordChanged := changes["password"]
+	_, emailChanged := changes["email"]
+	if passwordChanged || emailChanged {
+		if err := auth.RequireSession(ctx); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if emailChanged {
+		user.EmailVerifiedAt = nil
+		changes["email_verified_at"] = nil
+	}
+
+	renamed := user.Username != username
+	if renamed {
+		// Users in the trash keep their username so they can be restored,
+		// the unique index catches concurrent renames. The username another
+		// user was renamed from stays taken until their posts are moved.
+		if _, err := findAccount(ctx, db, models.UsernameInUse(user.Username)); err == nil {
+			return nil, false, models.ErrUsernameTaken
+		} else if !errors.Is(err, models.ErrNotFound) {
+			return nil, false, err
+		}
+		// Recorded with the new username, so the posts are moved even if
+		// this request fails after the update
+		user.RenamedFrom = username
+		changes["renamed_from"] = username
+	}
+
+	user.UpdatedAt = time.Now()
+	changes["updated_at"] = user.UpdatedAt
+
+	// Match on the ID, the username may be the one being changed
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, changes); err != nil {
+		return nil, false, err
+	}
+
+	if renamed {
+		if err := enqueueAuthorRename(ctx, db, user); err != nil {
+			return nil, false, err
+		}
+		if err := finishRename(ctx, db, user); err != nil {
+			// The job moves them later
+			logging.FromContext(ctx).Warn("Posts not moved to the new username", slog.String("username", user.Username), slog.Any("error", err))
+		}
+	}
+
+	// Whoever knew the old password is logged out, except the session
+	// that changed it
+	if passwordChanged {
+		filter := bson.M{"user_id": user.ID}
+		if session := auth.SessionFromContext(ctx); session != nil {
+			filter["_id"] = bson.M{"$ne": session.ID}
+		}
+		if _, err := models.DbDeleteSessions(ctx, db, filter); err != nil {
+			return nil, false, err
+		}
+	}
+	// Reset links sent to the old address no longer work
+	if emailChanged {
+		if _, err := models.DbDeleteTokens(ctx, db, bson.M{"user_id": user.ID, "purpose": models.TokenResetPassword}); err != nil {
+			return nil, false, err
+		}
+	}
+
+	recordAudit(ctx, db, "user.update", "user", user.ID.Hex(), &before, user, nil)
+
+	if emailChanged {
+		if err := sendToken(ctx, db, sender, user, models.TokenVerifyEmail, verificationTTL); err != nil {
+			logging.FromContext(ctx).Warn("Verification email not sent", slog.String("username", user.Username), slog.Any("error", err))
+		}
+	}
+
+	return withoutPassword(user), true, nil
+}
+
+// JobRenameAuthor is the job type handled by RunAuthorRename
+const JobRenameAuthor = "rename_author"
+
+// enqueueAuthorRename starts the job that moves the posts of the renamed
+// user to their new username
+func enqueueAuthorRename(ctx context.Context, db *mongo.Database, user *models.User) error {
+	job := models.Job{
+		Type: JobRenameAuthor,
+		Params: map[string]string{
+			"user_id": user.ID.Hex(),
+		},
+	}
+	_, err := models.DbInsertJob(ctx, db, job)
+	return err
+}
+
+// RunAuthorRename moves the posts of the user in job from their previous
+// username, unless that was done already. It can be repeated safely.
+func RunAuthorRename(ctx context.Context, db *mongo.Database, job *models.Job) error {
+	userID, err := primitive.ObjectIDFromHex(job.Params["user_id"])
+	if err != nil {
+		return fmt.Errorf("invalid user_id: %w", err)
+	}
+	user, err := findAccount(ctx, db, bson.M{"_id": userID})
+	if errors.Is(err, models.ErrUserNotFound) {
+		// Purged since, with their posts
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return finishRename(ctx, db, user)
+}
+
+// finishRename moves the posts of user from the username they were
+// renamed from, if any, and clears it
+func finishRename(ctx context.Context, db *mongo.Database, user *models.User) error {
+	if user.RenamedFrom == "" {
+		return nil
+	}
+	if _, err := models.DbRenamePostAuthor(ctx, db, user.RenamedFrom, user.Username); err != nil {
+		return fmt.Errorf("moving posts of %s to %s: %w", user.RenamedFrom, user.Username, err)
+	}
+	if err := models.DbFinishUserRename(ctx, db, user.ID, user.RenamedFrom); err != nil {
+		return err
+	}
+	user.RenamedFrom = ""
+	return nil
+}
+
+// validatePatch checks the new value of the named models.User field,
+// reporting failures under the patch key
+func validatePatch(key, name, value string) []models.FieldError {
+	fieldErrs := validation.Field(models.User{}, name, value)
+	for i := range fieldErrs {
+		fieldErrs[i].Field = key
+	}
+	return fieldErrs
+}
+
+// ReplaceUser sets every mutable field of the user with the given
+// username, as PUT requires. It reports whether anything changed.
+func ReplaceUser(ctx context.Context, db *mongo.Database, username string, user models.User, sender mail.Sender, verificationTTL time.Duration) (*models.User, bool, error) {
+	var fieldErrs []models.FieldError
+	patch := map[string]interface{}{}
+	for key, mutable := range mutableUserFields {
+		value := *mutable.field(&user)
+		if value == "" {
+			fieldErrs = append(fieldErrs, models.FieldError{Field: key, Message: "is required"})
+		}
+		patch[key] = value
+	}
+	if len(fieldErrs) > 0 {
+		sort.Slice(fieldErrs, func(i, j int) bool { return fieldErrs[i].Field < fieldErrs[j].Field })
+		return nil, false, models.NewValidationError("invalid_body", "Every mutable field is required", fieldErrs)
+	}
+
+	return PatchUser(ctx, db, username, patch, sender, verificationTTL)
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gonews/auth"
+	"gonews/logging"
+	"gonews/mail"
+	"gonews/models"
+	"gonews/validation"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// validateEmail checks that email is a bare address such as
+// name@example.com
+func validateEmail(email string) error {
+	if fieldErrs := validation.Var("email", email, "required,mailaddr"); len(fieldErrs) > 0 {
+		return models.NewValidationError("invalid_email", "Email must be a valid address", fieldErrs)
+	}
+	return nil
+}
+
+// sendToken replaces the tokens of user for purpose with a new one
+// valid for ttl and emails it to the user
+func sendToken(ctx context.Context, db *mongo.Database, sender mail.Sender, user *models.User, purpose string, ttl time.Duration) error {
+	if _, err := models.DbDeleteTokens(ctx, db, bson.M{"user_id": user.ID, "purpose": purpose}); err != nil {
+		return err
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		return err
+	}
+	token := models.Token{
+		TokenHash: hashToken(secret),
+		Purpose:   purpose,
+		UserID:    user.ID,
+		Email:     user.Email,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if _, err := models.DbInsertToken(ctx, db, token); err != nil {
+		return err
+	}
+
+	msg := mail.Message{To: user.Email}
+	expires := token.ExpiresAt.UTC().Format(time.RFC1123)
+	switch purpose {
+	case models.TokenVerifyEmail:
+		msg.Subject = "Verify your GoNews email address"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nconfirm this address by sending the token below to POST /auth/verify-email:\n\n%s\n\n"+
+			"The token expires %s.\n", user.Username, secret, expires)
+	case models.TokenResetPassword:
+		msg.Subject = "Reset your GoNews password"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nsomeone asked to reset your password. Choose a new one by sending the token below "+
+			"with it to POST /auth/password-reset/confirm:\n\n%s\n\nThe token expires %s. If this was not you, ignore this email.\n",
+			user.Username, secret, expires)
+	}
+	if err := sender.Send(ctx, msg); err != nil {
+		logging.FromContext(ctx).Error("Error sending email", slog.String("purpose", purpose), slog.Any("error", err))
+		return models.ErrMailUnavailable
+	}
+	return nil
+}
+
+// ResendVerification emails the user with the given username a new
+// verification token valid for ttl
+func ResendVerification(ctx context.Context, db *mongo.Database, sender mail.Sender, username string, ttl time.Duration) error {
+	user, err := GetUser(ctx, db, username)
+	if err != nil {
+		return err
+	} else if user.EmailVerifiedAt != nil {
+		return models.ErrEmailVerified
+	}
+	return sendToken(ctx, db, sender, user, models.TokenVerifyEmail, ttl)
+}
+
+// VerifyEmail marks the email address a verification token was sent to
+// as verified, if it is still the user's address
+func VerifyEmail(ctx context.Context, db *mongo.Database, secret string) (*models.User, error) {
+	token, err := models.DbConsumeToken(ctx, db, hashToken(secret), models.TokenVerifyEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := findAccount(ctx, db, bson.M{"_id": token.UserID})
+	if err != nil {
+		return nil, err
+	} else if user.Email != token.Email {
+		// The address changed after the token was sent
+		return nil, models.ErrInvalidEmailToken
+	}
+
+	before := *user
+	now := time.Now()
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, bson.M{"email_verified_at": now}); err != nil {
+		return nil, err
+	}
+	user.EmailVerifiedAt = &now
+	recordAudit(auth.WithUser(ctx, withoutPassword(user)), db, "user.update", "user", user.ID.Hex(), &before, user,
+		map[string]string{"reason": "email_verification"})
+
+	return withoutPassword(user), nil
+}
+
+// RequestPasswordReset emails a reset token valid for ttl to every
+// account with the given address. It succeeds whether or not there are
+// any, so addresses cannot be probed.
+func RequestPasswordReset(ctx context.Context, db *mongo.Database, sender mail.Sender, email string, ttl time.Duration) error {
+	if err := validateEmail(email); err != nil {
+		return err
+	}
+
+	users, err, _ := models.DbQueryUsers(ctx, db, bson.M{"email": email})
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		if user.PendingDeletion {
+			continue
+		}
+		if err := sendToken(ctx, db, sender, user, models.TokenResetPassword, ttl); err != nil && !errors.Is(err, models.ErrMailUnavailable) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResetPassword sets a new password for the user a reset token was sent
+// to, ends all of their sessions and lifts any lockout. The token also
+// proves they own the address it was sent to, and stops working once the
+// address changes.
+func ResetPassword(ctx context.Context, db *mongo.Database, secret, password string) error {
+	if fieldErrs := validatePatch("password", "Password", password); len(fieldErrs) > 0 {
+		return models.NewValidationError("invalid_password", "Password does not meet the password policy", fieldErrs)
+	}
+
+	token, err := models.DbConsumeToken(ctx, db, hashToken(secret), models.TokenResetPassword)
+	if err != nil {
+		return err
+	}
+	user, err := findAccount(ctx, db, bson.M{"_id": token.UserID})
+	if err != nil {
+		return err
+	} else if user.Email != token.Email {
+		// The address changed after the token was sent
+		return models.ErrInvalidEmailToken
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	before := *user
+	user.Password, user.UpdatedAt, user.Lockout = hash, time.Now(), nil
+	changes := bson.M{"password": hash, "updated_at": user.UpdatedAt, "lockout": nil}
+	if user.EmailVerifiedAt == nil {
+		user.EmailVerifiedAt = &user.UpdatedAt
+		changes["email_verified_at"] = user.UpdatedAt
+	}
+	if _, err := models.DbUpdateUser(ctx, db, bson.M{"_id": user.ID}, changes); err != nil {
+		return err
+	}
+
+	// Whoever knew the old password is logged out
+	if _, err := models.DbDeleteSessions(ctx, db, bson.M{"user_id": user.ID}); err != nil {
+		return err
+	}
+
+	recordAudit(auth.WithUser(ctx, withoutPassword(user)), db, "user.update", "user", user.ID.Hex(), &before, user,
+		map[string]string{"reason": "password_reset"})
+	return nil
+}
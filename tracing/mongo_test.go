@@ -0,0 +1,25 @@
+package tracing
+
+import "testing"
+
+func TestPeerAddr(t *testing.T) {
+	tests := []struct {
+		connectionID string
+		host         string
+		port         int
+		ok           bool
+	}{
+		{"localhost:27017[-4]", "localhost", 27017, true},
+		{"db-0.example.com:27018[-12]", "db-0.example.com", 27018, true},
+		{"[::1]:27017[-1]", "::1", 27017, true},
+		{"10.0.0.5:27017", "10.0.0.5", 27017, true},
+		{"", "", 0, false},
+		{"localhost[-4]", "", 0, false},
+	}
+	for _, tt := range tests {
+		host, port, ok := peerAddr(tt.connectionID)
+		if host != tt.host || port != tt.port || ok != tt.ok {
+			t.Errorf("peerAddr(%q) = %q, %d, %v, want %q, %d, %v", tt.connectionID, host, port, ok, tt.host, tt.port, tt.ok)
+		}
+	}
+}
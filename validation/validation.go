@@ -0,0 +1,192 @@
+// Package validation checks request data against the binding tags on
+// models, with the custom rules of GoNews. The rules are registered with
+// gin's validator, so ShouldBindJSON applies them too.
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gonews/models"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// ReservedUsernames cannot be registered, compared case-insensitively.
+// They would be mistaken for staff or collide with routes, and
+// "deleted" is the author of posts kept after their author was deleted.
+var ReservedUsernames = []string{
+	"admin", "administrator", "anonymous", "api", "auth", "deleted", "gonews", "mod", "moderator",
+	"null", "posts", "root", "staff", "support", "system", "tags", "undefined", "users",
+}
+
+// commonPasswords are refused as passwords, they are the first ones
+// tried by credential stuffing
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "12345678": true, "123456789": true,
+	"1234567890": true, "qwertyuiop": true, "qwerty123": true, "11111111": true, "00000000": true,
+	"iloveyou": true, "sunshine": true, "princess": true, "football": true, "baseball": true,
+	"letmein1": true, "welcome1": true, "abc12345": true, "admin123": true, "passw0rd": true,
+	"gonews123": true, "trustno1": true, "superman": true, "starwars": true, "whatever": true,
+}
+
+// rules are the custom validation tags
+var rules = map[string]validator.Func{
+	"username": func(fl validator.FieldLevel) bool {
+		return isUsername(fl.Field().String())
+	},
+	"notreserved": func(fl validator.FieldLevel) bool {
+		return !isReserved(fl.Field().String())
+	},
+	"mailaddr": func(fl validator.FieldLevel) bool {
+		email := fl.Field().String()
+		addr, err := netmail.ParseAddress(email)
+		return err == nil && addr.Address == email
+	},
+	"maxbytes": func(fl validator.FieldLevel) bool {
+		var max int
+		fmt.Sscan(fl.Param(), &max)
+		return len(fl.Field().String()) <= max
+	},
+	"notcommon": func(fl validator.FieldLevel) bool {
+		return !commonPasswords[strings.ToLower(fl.Field().String())]
+	},
+	"notblank": func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	},
+}
+
+// messages describe each failed tag for clients, %s is the tag's
+// parameter
+var messages = map[string]string{
+	"required":    "is required",
+	"min":         "must be at least %s characters",
+	"max":         "must be at most %s characters",
+	"username":    "may only contain letters, digits, _, . and -, starting with a letter or digit",
+	"notreserved": "is reserved",
+	"mailaddr":    "must be an address like name@example.com",
+	"maxbytes":    "must be at most %s bytes",
+	"notcommon":   "is too common, choose another",
+	"notblank":    "must not be blank",
+}
+
+func init() {
+	engine := binding.Validator.Engine().(*validator.Validate)
+	for tag, rule := range rules {
+		if err := engine.RegisterValidation(tag, rule); err != nil {
+			panic(fmt.Sprintf("registering validation %s: %v", tag, err))
+		}
+	}
+	// Report fields by the names clients send them as
+	engine.RegisterTagNameFunc(fieldName)
+}
+
+func isUsername(username string) bool {
+	for i, r := range username {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+		case i > 0 && (r == '_' || r == '.' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return username != ""
+}
+
+func isReserved(username string) bool {
+	for _, reserved := range ReservedUsernames {
+		if strings.EqualFold(username, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName mirrors encoding/json's naming rules. Models have no json
+// tags, their fields are named as stored, which request types match.
+func fieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	} else if name == "" {
+		name = strings.Split(field.Tag.Get("bson"), ",")[0]
+	}
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// Struct checks v against its binding tags, returning a validation error
+// listing every invalid field
+func Struct(v interface{}) error {
+	return FromBinding(binding.Validator.ValidateStruct(v))
+}
+
+// Field checks value against the binding tags of the named field of the
+// struct v, for values set one at a time such as in a patch
+func Field(v interface{}, name string, value interface{}) []models.FieldError {
+	field, ok := reflect.TypeOf(v).FieldByName(name)
+	if !ok {
+		panic(fmt.Sprintf("validation: %T has no field %s", v, name))
+	}
+	return Var(fieldName(field), value, field.Tag.Get("binding"))
+}
+
+// Var checks value against tag, reporting failures as the field name
+func Var(name string, value interface{}, tag string) []models.FieldError {
+	engine := binding.Validator.Engine().(*validator.Validate)
+	var validationErrs validator.ValidationErrors
+	if err := engine.Var(value, tag); errors.As(err, &validationErrs) {
+		fieldErrs := fieldErrors(validationErrs)
+		for i := range fieldErrs {
+			fieldErrs[i].Field = name
+		}
+		return fieldErrs
+	}
+	return nil
+}
+
+// FromBinding turns an error of ShouldBindJSON or Struct into a
+// validation error, with per-field details when the body decoded but
+// broke a rule, or into ErrBodyTooLarge
+func FromBinding(err error) error {
+	var validationErrs validator.ValidationErrors
+	var tooLarge *http.MaxBytesError
+	if err == nil {
+		return nil
+	} else if errors.As(err, &tooLarge) {
+		return models.ErrBodyTooLarge
+	} else if errors.As(err, &validationErrs) {
+		return models.NewValidationError("invalid_body", "Invalid request body", fieldErrors(validationErrs))
+	}
+	return models.NewValidationError("invalid_body", err.Error(), nil)
+}
+
+func fieldErrors(validationErrs validator.ValidationErrors) []models.FieldError {
+	fieldErrs := make([]models.FieldError, 0, len(validationErrs))
+	for _, e := range validationErrs {
+		message, ok := messages[e.Tag()]
+		if !ok {
+			message = "must satisfy " + e.Tag()
+		}
+		if strings.Contains(message, "%s") {
+			message = fmt.Sprintf(message, e.Param())
+		}
+		// Drop the name of the top-level struct
+		field := e.Namespace()
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		fieldErrs = append(fieldErrs, models.FieldError{Field: field, Message: message})
+	}
+	sort.Slice(fieldErrs, func(i, j int) bool { return fieldErrs[i].Field < fieldErrs[j].Field })
+	return fieldErrs
+}